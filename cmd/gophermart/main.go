@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,51 +10,117 @@ import (
 	"time"
 
 	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/metrics"
 	"github.com/andrei-cloud/gophermart/internal/repo"
 	"github.com/andrei-cloud/gophermart/internal/repo/indb"
 	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/andrei-cloud/gophermart/internal/repo/instrumented"
+	"github.com/andrei-cloud/gophermart/internal/repo/traced"
 	"github.com/andrei-cloud/gophermart/internal/server"
+	"github.com/andrei-cloud/gophermart/internal/tlsconfig"
+	"github.com/andrei-cloud/gophermart/internal/tracing"
 	"github.com/andrei-cloud/gophermart/internal/worker"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
 	cfg := config.GetConfig()
 	var (
 		db  repo.Repository
 		err error
 	)
 
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	if cfg.Debug {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-		log.Debug().Msg("DEBUG LEVEL IS ENABLED")
-
+	if *printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			log.Fatal().AnErr("YAML", err).Msg("main")
+		}
+		os.Stdout.Write(out)
+		return
 	}
 
+	level, _ := zerolog.ParseLevel(cfg.LogLevel)
+	zerolog.SetGlobalLevel(level)
+
 	log.Info().Msg("Starting...")
+	log.Info().Fields(cfg.Effective()).Msg("effective configuration")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     cfg.TraceExporter,
+		File:         cfg.TraceFile,
+		OTLPEndpoint: cfg.TraceOTLPEndpoint,
+		OTLPInsecure: cfg.TraceOTLPInsecure,
+		SampleRatio:  cfg.TraceSampleRatio,
+	})
+	if err != nil {
+		log.Fatal().AnErr("tracing.Setup", err).Msg("main")
+	}
 
 	if cfg.DBURI == "" {
 		db = inmem.NewInMemRepo()
 	} else {
 		db = indb.NewDB(cfg.DBURI)
 	}
+	m := metrics.New()
+	db = traced.New(instrumented.New(db, m.ObserveRepository))
+
+	for _, login := range cfg.AdminLogins {
+		if err := domain.GrantRole(context.Background(), db, login, repo.RoleAdmin); err != nil {
+			log.Error().AnErr("GrantRole", err).Msgf("admin %s not granted", login)
+		}
+	}
 
 	s := server.NewServer(cfg)
 
-	s.WithDB(db).SetupRoutes()
+	s.WithDB(db).WithMetrics(m)
+	if cfg.RateLimitStore == "db" {
+		s.WithRateLimitStore(db)
+	}
+	s.SetupRoutes()
 
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
 
+	var redirect *http.Server
+	if cfg.TLSEnabled() {
+		tlsConfig, certs, err := tlsconfig.New(tlsconfig.Config{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			MinVersion:   cfg.TLSMinVersion,
+			CipherPolicy: cfg.TLSCipherPolicy,
+			ClientCAFile: cfg.TLSClientCAFile,
+		})
+		if err != nil {
+			log.Fatal().AnErr("tlsconfig.New", err).Msg("main")
+		}
+		s.TLSConfig = tlsConfig
+		go certs.Watch(serverCtx, cfg.TLSReloadInterval)
+
+		if cfg.TLSRedirectAddress != "" {
+			redirect = server.NewRedirectServer(cfg)
+			go func() {
+				if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatal().AnErr("redirect", err).Msg("main")
+				}
+			}()
+		}
+	}
+
 	// Listen for syscall signals for process to interrupt/quit
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		<-sig
 
-		// Shutdown signal with grace period of 30 seconds
-		shutdownCtx, cancel := context.WithTimeout(serverCtx, 30*time.Second)
+		// Fail readiness first so that load balancers drain the instance
+		s.Drain()
+		log.Info().Msgf("draining for %s", cfg.ShutdownDrainDelay)
+		time.Sleep(cfg.ShutdownDrainDelay)
+
+		// Shutdown signal with grace period
+		shutdownCtx, cancel := context.WithTimeout(serverCtx, cfg.ShutdownTimeout)
 		defer cancel()
 		go func() {
 			<-shutdownCtx.Done()
@@ -63,6 +130,11 @@ func main() {
 		}()
 
 		// Trigger graceful shutdown
+		if redirect != nil {
+			if err := redirect.Shutdown(shutdownCtx); err != nil {
+				log.Error().AnErr("redirect shutdown", err).Msg("main")
+			}
+		}
 		err := s.Shutdown(shutdownCtx)
 		if err != nil {
 			log.Fatal().Msg(err.Error())
@@ -70,18 +142,90 @@ func main() {
 		serverStopCtx()
 	}()
 
+	tiers := domain.NewTiers(
+		domain.TierLevel{Tier: repo.SILVER, Threshold: cfg.TierSilverThreshold, Multiplier: cfg.TierSilverMultiplier},
+		domain.TierLevel{Tier: repo.GOLD, Threshold: cfg.TierGoldThreshold, Multiplier: cfg.TierGoldMultiplier},
+		domain.TierLevel{Tier: repo.PLATINUM, Threshold: cfg.TierPlatinumThreshold, Multiplier: cfg.TierPlatinumMultiplier},
+	)
+
 	// launch worker
-	wrkr := worker.NewWorker(cfg.AccrualSystem, db)
+	wrkr := worker.NewWorker(cfg.AccrualSystem, db).
+		WithTiers(tiers).
+		WithMetrics(m).
+		WithInterval(cfg.WorkerInterval).
+		WithPoolSize(cfg.WorkerPoolSize).
+		WithAccrualTimeout(cfg.AccrualTimeout)
+	s.WithReadinessChecks(wrkr.HealthChecks()...)
+
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		current := cfg
+		for range hup {
+			current = reload(current, s, wrkr)
+		}
+	}()
 
 	go wrkr.Run(serverCtx)
 
-	// Run the server
-	err = s.ListenAndServe()
+	// launch tier recalculation
+	tierWrkr := worker.NewTierWorker(db, tiers, cfg.TierRecalcInterval)
+
+	go tierWrkr.Run(serverCtx)
+
+	// Run the server; the certificate comes from TLSConfig
+	if cfg.TLSEnabled() {
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatal().Msg(err.Error())
 	}
 
 	// Wait for server context to be stopped
 	<-serverCtx.Done()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Error().AnErr("tracing shutdown", err).Msg("main")
+	}
 	log.Info().Msg("Stopped...")
 }
+
+// reload applies the settings that may change at runtime and returns
+// the configuration in effect. An invalid configuration is rejected as
+// a whole.
+func reload(
+	current *config.Config,
+	s interface{ Reconfigure(*config.Config) error },
+	w interface {
+		Reconfigure(accrualURL string, interval time.Duration, poolSize int)
+	},
+) *config.Config {
+	next, err := config.ReloadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("configuration reload rejected")
+		return current
+	}
+	next, changes := current.Update(next)
+	if err := s.Reconfigure(next); err != nil {
+		log.Error().Err(err).Msg("configuration reload rejected")
+		return current
+	}
+	level, _ := zerolog.ParseLevel(next.LogLevel)
+	zerolog.SetGlobalLevel(level)
+	w.Reconfigure(next.AccrualSystem, next.WorkerInterval, next.WorkerPoolSize)
+
+	for _, c := range changes {
+		if c.Applied {
+			log.Info().Interface("old", c.Old).Interface("new", c.New).Msgf("%s changed", c.Key)
+		} else {
+			log.Warn().Interface("old", c.Old).Interface("new", c.New).Msgf("%s changed, restart to apply", c.Key)
+		}
+	}
+	log.Info().Int("changes", len(changes)).Msg("configuration reloaded")
+	return next
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain runs main instead of the tests when the binary is started
+// by TestStartup, so that the default startup path is exercised.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOPHERMART_RUN_MAIN") == "1" {
+		os.Args = os.Args[:1]
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestStartup_NoJWTSecret(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = []string{"GOPHERMART_RUN_MAIN=1"}
+	out, err := cmd.CombinedOutput()
+
+	var exit *exec.ExitError
+	require.ErrorAs(t, err, &exit, "startup must fail: %s", out)
+	assert.Contains(t, string(out), "invalid configuration")
+	assert.Contains(t, string(out), "jwt_secret: set jwt_secret or jwt_key_file")
+}
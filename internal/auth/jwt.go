@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid jwt key")
+	ErrNoKey      = errors.New("no jwt key configured")
+)
+
+type Config struct {
+	// Algorithm overrides the algorithm inferred from the signing key.
+	Algorithm string
+	// Secret is the HMAC secret, used when KeyFile is empty.
+	Secret string
+	// KeyFile is a PEM encoded RSA or Ed25519 private key.
+	KeyFile string
+	// KeyID is the kid of the signing key, derived from the key when empty.
+	KeyID string
+	// VerifyKeys are additional "kid=path" keys accepted for verification,
+	// so tokens signed by a retired key stay valid until they expire.
+	VerifyKeys []string
+	Issuer     string
+	Audience   string
+	// Ephemeral allows a random per-process secret when neither Secret
+	// nor KeyFile is set. Meant for development only.
+	Ephemeral bool
+}
+
+type JWTAuth struct {
+	alg      jwa.SignatureAlgorithm
+	signKey  jwk.Key
+	issuer   string
+	audience string
+
+	mu   sync.RWMutex
+	keys jwk.Set
+}
+
+func New(cfg Config) (*JWTAuth, error) {
+	var (
+		raw interface{}
+		err error
+	)
+	switch {
+	case cfg.KeyFile != "":
+		raw, err = readKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	case cfg.Secret != "":
+		raw = []byte(cfg.Secret)
+	case !cfg.Ephemeral:
+		return nil, ErrNoKey
+	default:
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Warn().Msg("dev mode: no jwt key configured, using an ephemeral secret")
+		raw = secret
+	}
+
+	alg := jwa.SignatureAlgorithm(cfg.Algorithm)
+	if alg == "" {
+		alg, err = algorithmFor(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signKey, err := newKey(raw, cfg.KeyID, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := verifyKeys(signKey, cfg.VerifyKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTAuth{
+		alg:      alg,
+		signKey:  signKey,
+		keys:     keys,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+	}, nil
+}
+
+// SetVerifyKeys replaces the additional verification keys, see
+// Config.VerifyKeys. The current keys stay in use when one of the new
+// ones cannot be loaded.
+func (a *JWTAuth) SetVerifyKeys(entries []string) error {
+	keys, err := verifyKeys(a.signKey, entries)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+	return nil
+}
+
+// Encode issues a token for subject valid for ttl. The kid of
+// the signing key is written into the token header.
+func (a *JWTAuth) Encode(subject string, claims map[string]interface{}, ttl time.Duration) (jwt.Token, string, error) {
+	now := time.Now()
+	token := jwt.New()
+	for k, v := range claims {
+		if err := token.Set(k, v); err != nil {
+			return nil, "", err
+		}
+	}
+	for k, v := range map[string]interface{}{
+		jwt.SubjectKey:    subject,
+		jwt.IssuerKey:     a.issuer,
+		jwt.AudienceKey:   a.audience,
+		jwt.IssuedAtKey:   now,
+		jwt.ExpirationKey: now.Add(ttl),
+	} {
+		if err := token.Set(k, v); err != nil {
+			return nil, "", err
+		}
+	}
+
+	signed, err := jwt.Sign(token, a.alg, a.signKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, string(signed), nil
+}
+
+// Decode verifies the signature against the key matching the token kid
+// and validates expiry, issuer and audience.
+func (a *JWTAuth) Decode(tokenString string) (jwt.Token, error) {
+	a.mu.RLock()
+	keys := a.keys
+	a.mu.RUnlock()
+
+	opts := []jwt.ParseOption{
+		jwt.WithKeySet(keys),
+		jwt.WithValidate(true),
+		jwt.WithRequiredClaim(jwt.SubjectKey),
+	}
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+	return jwt.Parse([]byte(tokenString), opts...)
+}
+
+// verifyKeys builds the key set accepted by Decode: the public half of
+// signKey and every "kid=path" entry.
+func verifyKeys(signKey jwk.Key, entries []string) (jwk.Set, error) {
+	keys := jwk.NewSet()
+	verifyKey, err := jwk.PublicKeyOf(signKey)
+	if err != nil {
+		return nil, err
+	}
+	keys.Add(verifyKey)
+
+	for _, entry := range entries {
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("%w: verify key %q must be kid=path", ErrInvalidKey, entry)
+		}
+		raw, err := readKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keyAlg, err := algorithmFor(raw)
+		if err != nil {
+			return nil, err
+		}
+		key, err := newKey(raw, kid, keyAlg)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = jwk.PublicKeyOf(key); err != nil {
+			return nil, err
+		}
+		keys.Add(key)
+	}
+	return keys, nil
+}
+
+func readKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(string(data), "-----BEGIN") {
+		return []byte(strings.TrimSpace(string(data))), nil
+	}
+	key, err := jwk.ParseKey(data, jwk.WithPEM(true))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, path, err)
+	}
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func algorithmFor(raw interface{}) (jwa.SignatureAlgorithm, error) {
+	switch raw.(type) {
+	case []byte:
+		return jwa.HS256, nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return jwa.RS256, nil
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jwa.EdDSA, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, raw)
+	}
+}
+
+func newKey(raw interface{}, kid string, alg jwa.SignatureAlgorithm) (jwk.Key, error) {
+	key, err := jwk.New(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err = key.Set(jwk.AlgorithmKey, alg); err != nil {
+		return nil, err
+	}
+	switch {
+	case kid != "":
+		err = key.Set(jwk.KeyIDKey, kid)
+	case key.KeyType() == jwa.OctetSeq:
+		// thumbprint of a symmetric key would disclose a hash of the secret
+		err = key.Set(jwk.KeyIDKey, "default")
+	default:
+		err = jwk.AssignKeyID(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, name string, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), name)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	rsaFile := writeKey(t, "rsa.pem", rsaKey)
+	edFile := writeKey(t, "ed.pem", edKey)
+
+	oldAuth, err := New(Config{KeyFile: rsaFile, KeyID: "old", Issuer: "gophermart", Audience: "gophermart"})
+	require.NoError(t, err)
+	_, oldToken, err := oldAuth.Encode("1", nil, time.Hour)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		alg     string
+		token   string
+		wantErr bool
+	}{
+		{
+			name: "HS256 secret",
+			cfg:  Config{Secret: "secret", Issuer: "gophermart", Audience: "gophermart"},
+			alg:  "HS256",
+		},
+		{
+			name: "RS256 key file",
+			cfg:  Config{KeyFile: rsaFile, Issuer: "gophermart", Audience: "gophermart"},
+			alg:  "RS256",
+		},
+		{
+			name: "EdDSA key file",
+			cfg:  Config{KeyFile: edFile, Issuer: "gophermart", Audience: "gophermart"},
+			alg:  "EdDSA",
+		},
+		{
+			name:  "rotated key still verifies",
+			cfg:   Config{KeyFile: edFile, VerifyKeys: []string{"old=" + rsaFile}, Issuer: "gophermart", Audience: "gophermart"},
+			alg:   "EdDSA",
+			token: oldToken,
+		},
+		{
+			name:    "retired key is rejected",
+			cfg:     Config{KeyFile: edFile, Issuer: "gophermart", Audience: "gophermart"},
+			alg:     "EdDSA",
+			token:   oldToken,
+			wantErr: true,
+		},
+		{
+			name:    "foreign audience is rejected",
+			cfg:     Config{KeyFile: rsaFile, KeyID: "old", Issuer: "gophermart", Audience: "partner"},
+			alg:     "RS256",
+			token:   oldToken,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(tt.cfg)
+			require.NoError(t, err)
+
+			token := tt.token
+			if token == "" {
+				_, token, err = a.Encode("42", map[string]interface{}{"roles": []string{"admin"}}, time.Hour)
+				require.NoError(t, err)
+
+				msg, err := jws.Parse([]byte(token))
+				require.NoError(t, err)
+				headers := msg.Signatures()[0].ProtectedHeaders()
+				assert.Equal(t, tt.alg, headers.Algorithm().String())
+				assert.NotEmpty(t, headers.KeyID())
+			}
+
+			got, err := a.Decode(token)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, got.Subject())
+		})
+	}
+}
+
+func TestJWTAuth_SetVerifyKeys(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	oldFile := writeKey(t, "old.pem", oldKey)
+
+	oldAuth, err := New(Config{KeyFile: oldFile, KeyID: "old"})
+	require.NoError(t, err)
+	_, oldToken, err := oldAuth.Encode("1", nil, time.Hour)
+	require.NoError(t, err)
+
+	a, err := New(Config{Secret: "secret"})
+	require.NoError(t, err)
+	_, err = a.Decode(oldToken)
+	assert.Error(t, err)
+
+	require.NoError(t, a.SetVerifyKeys([]string{"old=" + oldFile}))
+	_, err = a.Decode(oldToken)
+	assert.NoError(t, err, "retired key accepted after reload")
+
+	assert.ErrorIs(t, a.SetVerifyKeys([]string{"old"}), ErrInvalidKey)
+	_, err = a.Decode(oldToken)
+	assert.NoError(t, err, "invalid keys leave the current ones in place")
+
+	require.NoError(t, a.SetVerifyKeys(nil))
+	_, err = a.Decode(oldToken)
+	assert.Error(t, err)
+}
+
+func TestJWTAuth_NoKey(t *testing.T) {
+	_, err := New(Config{})
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	a, err := New(Config{Ephemeral: true})
+	require.NoError(t, err)
+	_, token, err := a.Encode("1", nil, time.Hour)
+	require.NoError(t, err)
+	_, err = a.Decode(token)
+	assert.NoError(t, err)
+}
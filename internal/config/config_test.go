@@ -0,0 +1,270 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test-secret"
+
+// env serves vars as the environment. JWT_SECRET defaults to testSecret
+// so that the configuration validates.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		if !ok && key == "JWT_SECRET" {
+			return testSecret, true
+		}
+		return v, ok
+	}
+}
+
+func load(t *testing.T, args []string, vars map[string]string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("gophermart", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args, env(vars))
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t, nil, nil)
+	require.NoError(t, err)
+	want := Default()
+	want.JWTSecret = testSecret
+	assert.Equal(t, &want, cfg)
+}
+
+func TestLoad_DevMode(t *testing.T) {
+	_, err := load(t, nil, map[string]string{"JWT_SECRET": ""})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jwt_secret: set jwt_secret or jwt_key_file")
+
+	cfg, err := load(t, []string{"-dev-mode"}, map[string]string{"JWT_SECRET": ""})
+	require.NoError(t, err, "dev mode allows an ephemeral key")
+	assert.True(t, cfg.DevMode)
+
+	_, err = load(t, nil, map[string]string{"JWT_SECRET": "", "JWT_KEY_FILE": "jwt.pem"})
+	require.NoError(t, err)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "gophermart.yaml", `
+address: ":7000"
+worker_interval: 1m
+accrual_timeout: 3s
+log_level: warn
+`)
+
+	cfg, err := load(t,
+		[]string{"-config", path, "-worker-interval", "5s"},
+		map[string]string{"WORKER_INTERVAL": "30s", "ACCRUAL_TIMEOUT": "4s", "LOG_LEVEL": ""})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7000", cfg.Address, "file over default")
+	assert.Equal(t, 4*time.Second, cfg.AccrualTimeout, "env over file")
+	assert.Equal(t, 5*time.Second, cfg.WorkerInterval, "flag over env")
+	assert.Equal(t, "warn", cfg.LogLevel, "empty env is ignored")
+}
+
+func TestLoad_Formats(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "gophermart.yml",
+			content: `
+cookie_secure: true
+rate_limit_auth: 5
+tier_gold_multiplier: 1.5
+admin_logins: [alice, bob]
+`,
+		},
+		{
+			name: "toml",
+			file: "gophermart.toml",
+			content: `
+cookie_secure = true
+rate_limit_auth = 5
+tier_gold_multiplier = 1.5
+admin_logins = ["alice", "bob"]
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.content)
+			cfg, err := load(t, nil, map[string]string{ConfigFileEnv: path})
+			require.NoError(t, err)
+			assert.True(t, cfg.CookieSecure)
+			assert.Equal(t, 5, cfg.RateLimitAuth)
+			assert.Equal(t, 1.5, cfg.TierGoldMultiplier)
+			assert.Equal(t, []string{"alice", "bob"}, cfg.AdminLogins)
+		})
+	}
+}
+
+func TestLoad_Normalize(t *testing.T) {
+	cfg, err := load(t, []string{"-a", "localhost:8081", "-r", "localhost:9091/", "-cookie-secure"},
+		map[string]string{"ADMIN_LOGINS": "alice, bob,"})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:8081", cfg.Address)
+	assert.Equal(t, "http://localhost:9091", cfg.AccrualSystem)
+	assert.True(t, cfg.CookieSecure)
+	assert.Equal(t, []string{"alice", "bob"}, cfg.AdminLogins)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		vars map[string]string
+		file string
+		want []string
+	}{
+		{
+			name: "bad env",
+			vars: map[string]string{"WORKER_INTERVAL": "often"},
+			want: []string{"env WORKER_INTERVAL"},
+		},
+		{
+			name: "bad flag",
+			args: []string{"-rate-limit-auth", "many"},
+			want: []string{"flag -rate-limit-auth", `invalid integer "many"`},
+		},
+		{
+			name: "unknown file key",
+			file: "wokrer_interval: 1s\n",
+			want: []string{`unknown setting "wokrer_interval"`},
+		},
+		{
+			name: "tls",
+			vars: map[string]string{
+				"TLS_CERT_FILE":        "tls.crt",
+				"TLS_MIN_VERSION":      "1.0",
+				"TLS_CIPHER_POLICY":    "legacy",
+				"TLS_REDIRECT_ADDRESS": ":80",
+			},
+			want: []string{
+				"tls_cert_file: tls_cert_file and tls_key_file must be set together",
+				`tls_min_version: unsupported TLS version "1.0"`,
+				`tls_cipher_policy: unknown cipher policy "legacy"`,
+				"tls_redirect_address: requires tls_cert_file and tls_key_file",
+			},
+		},
+		{
+			name: "invalid values",
+			vars: map[string]string{
+				"RUN_ADDRESS":          "8080",
+				"LOG_LEVEL":            "true",
+				"ACCRUAL_TIMEOUT":      "-1s",
+				"PASSWORD_ALGORITHM":   "bcrypt",
+				"PASSWORD_BCRYPT_COST": "40",
+				"TRACE_SAMPLE_RATIO":   "2",
+			},
+			want: []string{
+				"address: want host:port",
+				`log_level: want one of`,
+				"accrual_timeout: must be positive, got -1s",
+				"password_bcrypt_cost: must be between 4 and 31, got 40",
+				"trace_sample_ratio: must be between 0 and 1",
+			},
+		},
+		{
+			name: "samesite none without secure",
+			vars: map[string]string{"COOKIE_SAMESITE": "none"},
+			want: []string{"cookie_samesite: none requires cookie_secure or TLS"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append(args, "-c", writeFile(t, "gophermart.yaml", tt.file))
+			}
+			_, err := load(t, args, tt.vars)
+			require.Error(t, err)
+			for _, want := range tt.want {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestConfig_Effective(t *testing.T) {
+	cfg, err := load(t, nil, map[string]string{
+		"JWT_SECRET":   "hunter2",
+		"DATABASE_URI": "postgres://gopher:hunter2@db:5432/gophermart",
+	})
+	require.NoError(t, err)
+
+	effective := cfg.Effective()
+	assert.Equal(t, redacted, effective["jwt_secret"])
+	assert.Equal(t, "", effective["password_pepper"], "unset secrets stay empty")
+	assert.Equal(t, "postgres://gopher:REDACTED@db:5432/gophermart", effective["database_uri"])
+	assert.Equal(t, "10s", effective["worker_interval"])
+
+	out, err := cfg.YAML()
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "hunter2")
+
+	assert.Equal(t, "host=db password=REDACTED user=gopher", redactDSN("host=db password=hunter2 user=gopher"))
+}
+
+func TestLoader_Reload(t *testing.T) {
+	path := writeFile(t, "gophermart.yaml", "rate_limit_auth: 5\nworker_interval: 1m\n")
+	fs := flag.NewFlagSet("gophermart", flag.ContinueOnError)
+	l, err := newLoader(fs, []string{"-c", path, "-worker-interval", "5s"}, env(nil))
+	require.NoError(t, err)
+	cfg, err := l.load()
+	require.NoError(t, err)
+	assert.Equal(t, 5, cfg.RateLimitAuth)
+
+	require.NoError(t, os.WriteFile(path, []byte("rate_limit_auth: 7\nworker_interval: 2m\n"), 0o600))
+	cfg, err = l.load()
+	require.NoError(t, err)
+	assert.Equal(t, 7, cfg.RateLimitAuth)
+	assert.Equal(t, 5*time.Second, cfg.WorkerInterval, "flags still win")
+
+	require.NoError(t, os.WriteFile(path, []byte("rate_limit_auth: -1\n"), 0o600))
+	_, err = l.load()
+	assert.Error(t, err)
+}
+
+func TestConfig_Update(t *testing.T) {
+	current := Default()
+	next := Default()
+	next.LogLevel = "debug"
+	next.RateLimitAuth = 7
+	next.Address = ":9000"
+	next.JWTSecret = "rotated"
+
+	merged, changes := current.Update(&next)
+
+	assert.Equal(t, "debug", merged.LogLevel)
+	assert.Equal(t, 7, merged.RateLimitAuth)
+	assert.Equal(t, current.Address, merged.Address, "address requires a restart")
+	assert.Equal(t, "", merged.JWTSecret)
+	assert.Equal(t, []Change{
+		{Key: "address", Old: ":8080", New: ":9000"},
+		{Key: "log_level", Old: "info", New: "debug", Applied: true},
+		{Key: "jwt_secret", Old: "", New: redacted},
+		{Key: "rate_limit_auth", Old: 20, New: 7, Applied: true},
+	}, changes)
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file when the -config flag is not set.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// aliases keeps the short flags of earlier releases working.
+var aliases = map[string]string{
+	"a": "address",
+	"r": "accrual-system-address",
+	"d": "database-uri",
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting describes a field of Config.
+type setting struct {
+	index  int
+	key    string
+	env    string
+	flag   string
+	secret string
+	reload bool
+}
+
+func settings() []setting {
+	t := reflect.TypeOf(Config{})
+	out := make([]setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("key")
+		out = append(out, setting{
+			index:  i,
+			key:    key,
+			env:    f.Tag.Get("env"),
+			flag:   strings.ReplaceAll(key, "_", "-"),
+			secret: f.Tag.Get("secret"),
+			reload: f.Tag.Get("reload") == "true",
+		})
+	}
+	return out
+}
+
+// flagValue holds the raw value of a flag until the flags are applied
+// on top of the other layers.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *flagValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+
+// Load builds the configuration from the defaults, the config file named
+// by -config or CONFIG_FILE, the environment and the flags in args, each
+// layer overriding the previous one. Empty environment variables are
+// ignored. The result is validated.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	l, err := newLoader(fs, args, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return l.load()
+}
+
+// loader remembers the command line so that the configuration can be
+// loaded again with the same flags.
+type loader struct {
+	file      string
+	flags     []flagSetting
+	lookupEnv func(string) (string, bool)
+}
+
+type flagSetting struct {
+	setting
+	name  string // as given, possibly an alias
+	value string
+}
+
+func newLoader(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*loader, error) {
+	fields := settings()
+	values := make(map[string]*flagValue, len(fields))
+	byFlag := make(map[string]setting, len(fields))
+	defaults := reflect.ValueOf(Default())
+	for _, f := range fields {
+		field := defaults.Field(f.index)
+		values[f.flag] = &flagValue{value: format(field), isBool: field.Kind() == reflect.Bool}
+		byFlag[f.flag] = f
+		fs.Var(values[f.flag], f.flag, "overrides $"+f.env)
+	}
+	for alias, name := range aliases {
+		fs.Var(values[name], alias, "shorthand for -"+name)
+	}
+	file := fs.String("config", "", "YAML or TOML config file; overrides $"+ConfigFileEnv)
+	fs.StringVar(file, "c", "", "shorthand for -config")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	l := &loader{file: *file, lookupEnv: lookupEnv}
+	fs.Visit(func(fl *flag.Flag) {
+		name := fl.Name
+		if full, ok := aliases[name]; ok {
+			name = full
+		}
+		if f, ok := byFlag[name]; ok {
+			l.flags = append(l.flags, flagSetting{setting: f, name: fl.Name, value: values[name].value})
+		}
+	})
+	return l, nil
+}
+
+func (l *loader) load() (*Config, error) {
+	c := Default()
+	v := reflect.ValueOf(&c).Elem()
+	fields := settings()
+
+	path := l.file
+	if path == "" {
+		path, _ = l.lookupEnv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := loadFile(v, fields, path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		s, ok := l.lookupEnv(f.env)
+		if !ok || s == "" {
+			continue
+		}
+		if err := set(v.Field(f.index), s); err != nil {
+			return nil, fmt.Errorf("env %s: %w", f.env, err)
+		}
+	}
+
+	for _, f := range l.flags {
+		if err := set(v.Field(f.index), f.value); err != nil {
+			return nil, fmt.Errorf("flag -%s: %w", f.name, err)
+		}
+	}
+
+	c.normalize()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// loadFile applies the settings of a YAML or TOML file. Keys are the
+// key tags of Config; unknown keys are rejected so that typos do not go
+// unnoticed.
+func loadFile(v reflect.Value, fields []setting, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &values)
+	case ".toml":
+		_, err = toml.Decode(string(raw), &values)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, want .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	byKey := make(map[string]setting, len(fields))
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		if err := setFileValue(v.Field(f.index), values[key]); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+func setFileValue(field reflect.Value, raw interface{}) error {
+	switch raw := raw.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		if field.Kind() != reflect.Slice {
+			return fmt.Errorf("got a list, want a single value")
+		}
+		list := make([]string, 0, len(raw))
+		for _, item := range raw {
+			list = append(list, fmt.Sprint(item))
+		}
+		field.Set(reflect.ValueOf(list))
+		return nil
+	case map[string]interface{}:
+		return fmt.Errorf("got a table, want a single value")
+	default:
+		return set(field, fmt.Sprint(raw))
+	}
+}
+
+// set parses s into field. Lists are comma separated.
+func set(field reflect.Value, s string) error {
+	s = strings.TrimSpace(s)
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+func format(field reflect.Value) string {
+	switch v := field.Interface().(type) {
+	case time.Duration:
+		return v.String()
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password=)\S+`)
+
+// Effective returns the settings by key with secrets redacted, ready to
+// be logged or printed.
+func (c *Config) Effective() map[string]interface{} {
+	v := reflect.ValueOf(c).Elem()
+	out := make(map[string]interface{})
+	for _, f := range settings() {
+		field := v.Field(f.index).Interface()
+		switch f.secret {
+		case "":
+		case "dsn":
+			field = redactDSN(field.(string))
+		default:
+			if s, _ := field.(string); s != "" {
+				field = redacted
+			}
+		}
+		if d, ok := field.(time.Duration); ok {
+			field = d.String()
+		}
+		out[f.key] = field
+	}
+	return out
+}
+
+// YAML renders Effective as a config file.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c.Effective())
+}
+
+// redactDSN hides the password of a URL or key=value connection string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
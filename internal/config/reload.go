@@ -0,0 +1,33 @@
+package config
+
+import "reflect"
+
+// Change is a setting that differs between two configurations. Values
+// are redacted as in Effective.
+type Change struct {
+	Key     string
+	Old     interface{}
+	New     interface{}
+	Applied bool
+}
+
+// Update returns next with every setting that requires a restart kept
+// at its value in c, and lists the settings that differ. Changes that
+// were not applied take effect on the next start.
+func (c *Config) Update(next *Config) (*Config, []Change) {
+	merged := *next
+	cur, nv, mv := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem(), reflect.ValueOf(&merged).Elem()
+	before, after := c.Effective(), next.Effective()
+
+	var changes []Change
+	for _, f := range settings() {
+		if reflect.DeepEqual(cur.Field(f.index).Interface(), nv.Field(f.index).Interface()) {
+			continue
+		}
+		if !f.reload {
+			mv.Field(f.index).Set(cur.Field(f.index))
+		}
+		changes = append(changes, Change{Key: f.key, Old: before[f.key], New: after[f.key], Applied: f.reload})
+	}
+	return &merged, changes
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/tlsconfig"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors lists every problem found by Validate.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+func (c *Config) normalize() {
+	c.LogLevel = strings.ToLower(strings.TrimSpace(c.LogLevel))
+	c.CookieSameSite = strings.ToLower(c.CookieSameSite)
+	c.PasswordAlgorithm = strings.ToLower(c.PasswordAlgorithm)
+	c.RateLimitStore = strings.ToLower(c.RateLimitStore)
+	c.TraceExporter = strings.ToLower(c.TraceExporter)
+	c.TLSCipherPolicy = strings.ToLower(c.TLSCipherPolicy)
+
+	// The accrual system used to be given as host:port.
+	if c.AccrualSystem != "" && !strings.Contains(c.AccrualSystem, "://") {
+		c.AccrualSystem = "http://" + c.AccrualSystem
+	}
+	c.AccrualSystem = strings.TrimRight(c.AccrualSystem, "/")
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs Errors
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if host, port, err := net.SplitHostPort(c.Address); err != nil {
+		fail("address", "want host:port, got %q", c.Address)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		fail("address", "invalid port %q on host %q", port, host)
+	}
+	if u, err := url.Parse(c.AccrualSystem); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("accrual_system_address", "want an http(s) URL or host:port, got %q", c.AccrualSystem)
+	}
+	if _, err := zerolog.ParseLevel(c.LogLevel); err != nil || c.LogLevel == "" {
+		fail("log_level", "want one of trace, debug, info, warn, error, fatal, panic or disabled, got %q", c.LogLevel)
+	}
+
+	if c.JWTSecret == "" && c.JWTKeyFile == "" && !c.DevMode {
+		fail("jwt_secret", "set jwt_secret or jwt_key_file; an ephemeral key is allowed only with dev_mode")
+	}
+
+	positive := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"server_read_timeout", c.ServerReadTimeout},
+		{"server_write_timeout", c.ServerWriteTimeout},
+		{"server_idle_timeout", c.ServerIdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"readiness_check_timeout", c.ReadinessCheckTimeout},
+		{"worker_interval", c.WorkerInterval},
+		{"accrual_timeout", c.AccrualTimeout},
+		{"tier_recalc_interval", c.TierRecalcInterval},
+		{"access_token_ttl", c.AccessTokenTTL},
+		{"refresh_token_ttl", c.RefreshTokenTTL},
+		{"password_reset_ttl", c.PasswordResetTTL},
+		{"two_factor_challenge_ttl", c.TwoFactorChallengeTTL},
+		{"login_failure_window", c.LoginFailureWindow},
+		{"rate_limit_window", c.RateLimitWindow},
+	}
+	for _, p := range positive {
+		if p.d <= 0 {
+			fail(p.key, "must be positive, got %s", p.d)
+		}
+	}
+	nonNegative := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"shutdown_drain_delay", c.ShutdownDrainDelay},
+		{"cookie_lifetime", c.CookieLifetime},
+		{"login_lockout", c.LoginLockout},
+		{"login_base_delay", c.LoginBaseDelay},
+		{"login_max_delay", c.LoginMaxDelay},
+	}
+	for _, p := range nonNegative {
+		if p.d < 0 {
+			fail(p.key, "must not be negative, got %s", p.d)
+		}
+	}
+	if c.LoginMaxDelay < c.LoginBaseDelay {
+		fail("login_max_delay", "must not be below login_base_delay (%s), got %s", c.LoginBaseDelay, c.LoginMaxDelay)
+	}
+
+	if c.WorkerPoolSize < 1 {
+		fail("worker_pool_size", "must be at least 1, got %d", c.WorkerPoolSize)
+	}
+	if c.ServerMaxHeaderBytes <= 0 {
+		fail("server_max_header_bytes", "must be positive, got %d", c.ServerMaxHeaderBytes)
+	}
+	if c.MaxBodyBytes <= 0 {
+		fail("max_body_bytes", "must be positive, got %d", c.MaxBodyBytes)
+	}
+
+	if !(c.TierSilverThreshold < c.TierGoldThreshold && c.TierGoldThreshold < c.TierPlatinumThreshold) {
+		fail("tier_silver_threshold", "tier thresholds must increase from silver to platinum, got %g, %g, %g",
+			c.TierSilverThreshold, c.TierGoldThreshold, c.TierPlatinumThreshold)
+	}
+	for key, m := range map[string]float64{
+		"tier_silver_multiplier":   c.TierSilverMultiplier,
+		"tier_gold_multiplier":     c.TierGoldMultiplier,
+		"tier_platinum_multiplier": c.TierPlatinumMultiplier,
+	} {
+		if m < 1 {
+			fail(key, "must be at least 1, got %g", m)
+		}
+	}
+
+	if c.TransferMin <= 0 {
+		fail("transfer_min", "must be positive, got %g", c.TransferMin)
+	}
+	if c.TransferMax < c.TransferMin {
+		fail("transfer_max", "must not be below transfer_min (%g), got %g", c.TransferMin, c.TransferMax)
+	}
+	if c.TransferDaily <= 0 {
+		fail("transfer_daily_limit", "must be positive, got %g", c.TransferDaily)
+	}
+
+	switch c.CookieSameSite {
+	case "lax", "strict", "none":
+	default:
+		fail("cookie_samesite", "want lax, strict or none, got %q", c.CookieSameSite)
+	}
+	if c.CookieSameSite == "none" && !c.CookieSecure && !c.TLSEnabled() {
+		fail("cookie_samesite", "none requires cookie_secure or TLS")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("tls_cert_file", "tls_cert_file and tls_key_file must be set together")
+	}
+	if _, err := tlsconfig.ParseMinVersion(c.TLSMinVersion); err != nil {
+		fail("tls_min_version", "%v", err)
+	}
+	if _, err := tlsconfig.CipherSuites(c.TLSCipherPolicy); err != nil {
+		fail("tls_cipher_policy", "%v", err)
+	}
+	if c.TLSReloadInterval <= 0 {
+		fail("tls_reload_interval", "must be positive, got %s", c.TLSReloadInterval)
+	}
+	if c.TLSRedirectAddress != "" {
+		if !c.TLSEnabled() {
+			fail("tls_redirect_address", "requires tls_cert_file and tls_key_file")
+		}
+		if _, _, err := net.SplitHostPort(c.TLSRedirectAddress); err != nil {
+			fail("tls_redirect_address", "want host:port, got %q", c.TLSRedirectAddress)
+		}
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		fail("tls_client_ca_file", "requires tls_cert_file and tls_key_file")
+	}
+
+	switch c.PasswordAlgorithm {
+	case password.Argon2id:
+		if c.PasswordArgon2Time <= 0 || c.PasswordArgon2Memory <= 0 || c.PasswordArgon2KeyLength <= 0 {
+			fail("password_argon2_time", "argon2 time, memory and key length must be positive")
+		}
+		if c.PasswordArgon2Threads <= 0 || c.PasswordArgon2Threads > 255 {
+			fail("password_argon2_threads", "must be between 1 and 255, got %d", c.PasswordArgon2Threads)
+		}
+	case password.Bcrypt:
+		if c.PasswordBcryptCost < bcrypt.MinCost || c.PasswordBcryptCost > bcrypt.MaxCost {
+			fail("password_bcrypt_cost", "must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.PasswordBcryptCost)
+		}
+	default:
+		fail("password_algorithm", "want %s or %s, got %q", password.Argon2id, password.Bcrypt, c.PasswordAlgorithm)
+	}
+
+	if c.TwoFactorWithdrawalThreshold < 0 {
+		fail("two_factor_withdrawal_threshold", "must not be negative, got %g", c.TwoFactorWithdrawalThreshold)
+	}
+	if c.LoginMaxFailures < 0 {
+		fail("login_max_failures", "must not be negative, got %d", c.LoginMaxFailures)
+	}
+	if c.LoginMaxAddrFailures < 0 {
+		fail("login_max_addr_failures", "must not be negative, got %d", c.LoginMaxAddrFailures)
+	}
+
+	switch c.RateLimitStore {
+	case "memory", "db":
+	default:
+		fail("rate_limit_store", "want memory or db, got %q", c.RateLimitStore)
+	}
+	for key, n := range map[string]int{
+		"rate_limit_auth":   c.RateLimitAuth,
+		"rate_limit_upload": c.RateLimitUpload,
+		"rate_limit_read":   c.RateLimitRead,
+		"rate_limit_write":  c.RateLimitWrite,
+	} {
+		if n < 0 {
+			fail(key, "must not be negative, got %d", n)
+		}
+	}
+
+	switch c.TraceExporter {
+	case "none", "stdout", "otlp":
+	case "file":
+		if c.TraceFile == "" {
+			fail("trace_file", "required by the file exporter")
+		}
+	default:
+		fail("trace_exporter", "want none, stdout, file or otlp, got %q", c.TraceExporter)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		fail("trace_sample_ratio", "must be between 0 and 1, got %g", c.TraceSampleRatio)
+	}
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errs
+	}
+	return nil
+}
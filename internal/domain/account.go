@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+)
+
+// UserExport is the personal data archive of a user.
+type UserExport struct {
+	ExportedAt     string          `json:"exported_at"`
+	Profile        ProfileModel    `json:"profile"`
+	Balance        BalanceModel    `json:"balance"`
+	Orders         []OrderModel    `json:"orders"`
+	Withdrawals    []OrderModel    `json:"withdrawals"`
+	Transfers      []TransferModel `json:"transfers"`
+	BalanceHistory []LedgerEntry   `json:"balance_history"`
+}
+
+type ProfileModel struct {
+	ID        int64    `json:"id"`
+	Login     string   `json:"login"`
+	Roles     []string `json:"roles"`
+	Tier      string   `json:"tier"`
+	CreatedAt string   `json:"created_at,omitempty"`
+}
+
+// LedgerEntry is a single change of the balance of a user.
+type LedgerEntry struct {
+	Type      string  `json:"type"`
+	Reference string  `json:"reference"`
+	Amount    float64 `json:"amount"`
+	Time      string  `json:"time"`
+}
+
+const (
+	LedgerAccrual     = "accrual"
+	LedgerWithdrawal  = "withdrawal"
+	LedgerTransferIn  = "transfer_in"
+	LedgerTransferOut = "transfer_out"
+	LedgerAdjustment  = "adjustment"
+)
+
+// Delete anonymises the account once the password is confirmed.
+// Orders, withdrawals and transfers are kept for accounting.
+func (u *UserModel) Delete(ctx context.Context, r repo.Repository, h *password.Hasher, current string) (err error) {
+	ctx, end := startSpan(ctx, "user.Delete")
+	defer end(&err)
+
+	user, err := r.UserGetByID(ctx, u.ID)
+	if err != nil {
+		return fmt.Errorf("get by id user failed: %w", err)
+	}
+
+	if match, _ := verifyPassword(h, user, current, "Delete"); !match {
+		return ErrWrongPassword
+	}
+
+	if err := r.LoginAttemptReset(ctx, userAttemptKey(user.Username)); err != nil {
+		return fmt.Errorf("reset login attempts failed: %w", err)
+	}
+	if err := r.UserDelete(ctx, user.Username); err != nil {
+		return fmt.Errorf("delete user failed: %w", err)
+	}
+	return nil
+}
+
+func (u *UserModel) Export(ctx context.Context, r repo.Repository) (_ *UserExport, err error) {
+	ctx, end := startSpan(ctx, "user.Export")
+	defer end(&err)
+
+	user, err := r.UserGetByID(ctx, u.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get by id user failed: %w", err)
+	}
+	balance, err := u.GetBalance(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := r.OrderGetList(ctx, u.ID, repo.CREDIT)
+	if err != nil {
+		return nil, fmt.Errorf("get orders failed: %w", err)
+	}
+	debits, err := r.OrderGetList(ctx, u.ID, repo.DEBIT)
+	if err != nil {
+		return nil, fmt.Errorf("get withdrawals failed: %w", err)
+	}
+	transfers, err := r.TransferGetList(ctx, u.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get transfers failed: %w", err)
+	}
+	adjustments, err := r.AdjustmentGetList(ctx, u.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get adjustments failed: %w", err)
+	}
+
+	export := &UserExport{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Profile: ProfileModel{
+			ID:    user.ID,
+			Login: user.Username,
+			Roles: user.Roles,
+			Tier:  balance.Tier,
+		},
+		Balance:        *balance,
+		Orders:         creditModels(credits),
+		Withdrawals:    debitModels(debits),
+		Transfers:      transferModels(u.ID, transfers),
+		BalanceHistory: ledger(u.ID, credits, debits, transfers, adjustments),
+	}
+	if export.Profile.Roles == nil {
+		export.Profile.Roles = []string{}
+	}
+	if !user.CreatedAt.IsZero() {
+		export.Profile.CreatedAt = user.CreatedAt.Format(time.RFC3339)
+	}
+	return export, nil
+}
+
+// ledger lists the balance changes of the user in time order; they sum
+// to the current balance.
+func ledger(uid int64, credits, debits []repo.Order, transfers []repo.Transfer, adjustments []repo.Adjustment) []LedgerEntry {
+	type entry struct {
+		LedgerEntry
+		at time.Time
+	}
+	history := make([]entry, 0, len(credits)+len(debits)+len(transfers)+len(adjustments))
+	for _, o := range credits {
+		// re-polled orders keep what was credited before
+		if o.Credited != 0 {
+			history = append(history, entry{LedgerEntry{Type: LedgerAccrual, Reference: o.Order, Amount: o.Credited}, o.UploadedAt})
+		}
+	}
+	for _, o := range debits {
+		history = append(history, entry{LedgerEntry{Type: LedgerWithdrawal, Reference: o.Order, Amount: -o.Value}, o.UploadedAt})
+	}
+	for _, t := range transfers {
+		e := entry{LedgerEntry{Type: LedgerTransferOut, Reference: t.To, Amount: -t.Value}, t.CreatedAt}
+		if t.ToUserID == uid {
+			e.LedgerEntry = LedgerEntry{Type: LedgerTransferIn, Reference: t.From, Amount: t.Value}
+		}
+		history = append(history, e)
+	}
+	for _, a := range adjustments {
+		history = append(history, entry{LedgerEntry{Type: LedgerAdjustment, Reference: a.Reason, Amount: a.Value}, a.CreatedAt})
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].at.Before(history[j].at) })
+
+	list := make([]LedgerEntry, 0, len(history))
+	for _, e := range history {
+		e.Time = e.at.Format(time.RFC3339)
+		list = append(list, e.LedgerEntry)
+	}
+	return list
+}
@@ -0,0 +1,231 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+var (
+	ErrReasonRequired = repo.NewError(repo.KindValidation, "reason_required", "reason is required")
+	ErrUserLocked     = repo.NewError(repo.KindForbidden, "user_locked", "user is locked")
+)
+
+// AdminModel performs operator actions on behalf of the admin with ID.
+// Every action is recorded in the audit log.
+type AdminModel struct {
+	ID int64
+}
+
+type UserInfo struct {
+	ID        int64         `json:"id"`
+	Login     string        `json:"login"`
+	Roles     []string      `json:"roles"`
+	Locked    bool          `json:"locked"`
+	Tier      string        `json:"tier"`
+	Balance   *BalanceModel `json:"balance,omitempty"`
+	CreatedAt string        `json:"created_at,omitempty"`
+}
+
+type AdminOrder struct {
+	UserID     int64   `json:"user_id"`
+	Number     string  `json:"number"`
+	Type       string  `json:"type"`
+	Status     string  `json:"status,omitempty"`
+	Value      float64 `json:"accrual"`
+	Bonus      float64 `json:"bonus"`
+	UploadedAt string  `json:"uploaded_at"`
+}
+
+type AuditRecord struct {
+	ActorID   int64  `json:"actor_id"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	Details   string `json:"details,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func newUserInfo(u *repo.User) *UserInfo {
+	info := &UserInfo{
+		ID:     u.ID,
+		Login:  u.Username,
+		Roles:  u.Roles,
+		Locked: u.Locked,
+		Tier:   string(u.Tier),
+	}
+	if info.Roles == nil {
+		info.Roles = []string{}
+	}
+	if info.Tier == "" {
+		info.Tier = string(repo.BASIC)
+	}
+	if !u.CreatedAt.IsZero() {
+		info.CreatedAt = u.CreatedAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+// record describes an action of the admin for the audit log.
+func (a *AdminModel) record(action, target, details string) *repo.Audit {
+	return &repo.Audit{
+		ActorID:   a.ID,
+		Action:    action,
+		Target:    target,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (a *AdminModel) audit(ctx context.Context, r repo.Repository, action, target, details string) error {
+	_, err := r.AuditCreate(ctx, a.record(action, target, details))
+	if err != nil {
+		return fmt.Errorf("audit failed: %w", err)
+	}
+	return nil
+}
+
+func (a *AdminModel) SearchUsers(ctx context.Context, r repo.Repository, query string, limit, offset int) (_ []UserInfo, err error) {
+	ctx, end := startSpan(ctx, "admin.SearchUsers")
+	defer end(&err)
+
+	if err := a.audit(ctx, r, "user.search", query, ""); err != nil {
+		return nil, err
+	}
+
+	users, err := r.UserSearch(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]UserInfo, 0)
+	for i := range users {
+		list = append(list, *newUserInfo(&users[i]))
+	}
+	return list, nil
+}
+
+func (a *AdminModel) UserDetail(ctx context.Context, r repo.Repository, id int64) (_ *UserInfo, err error) {
+	ctx, end := startSpan(ctx, "admin.UserDetail")
+	defer end(&err)
+
+	if err := a.audit(ctx, r, "user.view", strconv.FormatInt(id, 10), ""); err != nil {
+		return nil, err
+	}
+
+	user, err := r.UserGetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	info := newUserInfo(user)
+	info.Balance, err = (&UserModel{ID: id}).GetBalance(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (a *AdminModel) SearchOrders(ctx context.Context, r repo.Repository, f repo.OrderFilter) (_ []AdminOrder, err error) {
+	ctx, end := startSpan(ctx, "admin.SearchOrders")
+	defer end(&err)
+
+	details := fmt.Sprintf("number=%s status=%s", f.Number, f.Status)
+	if err := a.audit(ctx, r, "order.search", "", details); err != nil {
+		return nil, err
+	}
+
+	orders, err := r.OrderSearch(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]AdminOrder, 0)
+	for _, order := range orders {
+		list = append(list, AdminOrder{
+			UserID:     order.UserID,
+			Number:     order.Order,
+			Type:       string(order.Type),
+			Status:     string(order.Status),
+			Value:      order.Value,
+			Bonus:      order.Bonus,
+			UploadedAt: order.UploadedAt.Format(time.RFC3339),
+		})
+	}
+	return list, nil
+}
+
+func (a *AdminModel) AdjustBalance(ctx context.Context, r repo.Repository, id int64, value float64, reason string) (err error) {
+	ctx, end := startSpan(ctx, "admin.AdjustBalance")
+	defer end(&err)
+
+	if reason == "" {
+		return ErrReasonRequired
+	}
+
+	details := fmt.Sprintf("sum=%v reason=%s", value, reason)
+	adj := &repo.Adjustment{UserID: id, Value: value, Reason: reason, CreatedAt: time.Now()}
+	return r.UserAdjust(ctx, adj, a.record("user.adjust", strconv.FormatInt(id, 10), details))
+}
+
+func (a *AdminModel) RepollOrder(ctx context.Context, r repo.Repository, number string) (err error) {
+	ctx, end := startSpan(ctx, "admin.RepollOrder")
+	defer end(&err)
+
+	return r.OrderRepoll(ctx, number, a.record("order.repoll", number, ""))
+}
+
+// LockUser locks or unlocks the account. Unlocking also lifts a
+// lockout caused by failed logins.
+func (a *AdminModel) LockUser(ctx context.Context, r repo.Repository, id int64, locked bool) (err error) {
+	ctx, end := startSpan(ctx, "admin.LockUser")
+	defer end(&err)
+
+	action := "user.unlock"
+	if locked {
+		action = "user.lock"
+	}
+	err = r.UserLock(ctx, id, locked, a.record(action, strconv.FormatInt(id, 10), ""))
+	if err != nil || locked {
+		return err
+	}
+
+	user, err := r.UserGetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return r.LoginAttemptReset(ctx, userAttemptKey(user.Username))
+}
+
+func (a *AdminModel) AuditList(ctx context.Context, r repo.Repository, limit, offset int) (_ []AuditRecord, err error) {
+	ctx, end := startSpan(ctx, "admin.AuditList")
+	defer end(&err)
+
+	records, err := r.AuditGetList(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]AuditRecord, 0)
+	for _, record := range records {
+		list = append(list, AuditRecord{
+			ActorID:   record.ActorID,
+			Action:    record.Action,
+			Target:    record.Target,
+			Details:   record.Details,
+			CreatedAt: record.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return list, nil
+}
+
+// GrantRole adds role to the user with the given login.
+func GrantRole(ctx context.Context, r repo.Repository, login, role string) error {
+	user, err := r.UserGet(ctx, login)
+	if err != nil {
+		return err
+	}
+	if user.HasRole(role) {
+		return nil
+	}
+	return r.UserRolesUpdate(ctx, user.ID, append(user.Roles, role))
+}
@@ -0,0 +1,311 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+)
+
+var ErrTooManyAttempts = repo.NewError(repo.KindTooManyRequests, "too_many_attempts", "too many failed login attempts")
+
+// LockedOutError refuses a login attempt until the lockout expires.
+type LockedOutError struct {
+	Until time.Time
+}
+
+func (e *LockedOutError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *LockedOutError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+func (e *LockedOutError) RetryAfter() time.Duration {
+	return time.Until(e.Until)
+}
+
+// LoginGuard throttles password guessing. Failures are counted per
+// username and per client address within Window. From the second
+// failure of a username on, the failed response is delayed by
+// BaseDelay, doubled on each further failure up to MaxDelay. Reaching
+// MaxFailures for a username or MaxAddrFailures for an address locks it
+// for Lockout. Zero limits disable the respective check.
+//
+// Users with two-factor authentication get a login challenge valid for
+// ChallengeTTL instead; wrong one-time codes count as failures too, as
+// do wrong passwords and codes given to change the password, disable
+// two-factor authentication or withdraw above the threshold.
+type LoginGuard struct {
+	MaxFailures     int
+	MaxAddrFailures int
+	Window          time.Duration
+	Lockout         time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	ChallengeTTL    time.Duration
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func addrAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Login authenticates u unless the username or ip are locked out,
+// keeping the failure counters up to date. When the user enabled
+// two-factor authentication u.Challenge is set and the login has to be
+// finished with CompleteLogin before any token is issued.
+func (g *LoginGuard) Login(ctx context.Context, r repo.Repository, h *password.Hasher, u *UserModel, ip string) (_ int64, err error) {
+	ctx, end := startSpan(ctx, "loginGuard.Login")
+	defer end(&err)
+
+	now := time.Now()
+	userKey, addrKey := userAttemptKey(u.Username), addrAttemptKey(ip)
+	for _, key := range []string{userKey, addrKey} {
+		if err := checkLockout(ctx, r, key, now); err != nil {
+			return 0, err
+		}
+	}
+
+	id, err := u.Login(ctx, r, h)
+	if errors.Is(err, ErrInvalidCredentials) {
+		if ferr := g.failed(ctx, r, userKey, addrKey, now); ferr != nil {
+			return 0, ferr
+		}
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	t, err := enabledTwoFactor(ctx, r, id)
+	if err != nil {
+		return 0, err
+	}
+	if t != nil {
+		// The failure counter of the user is kept until the second
+		// step succeeds so that codes cannot be guessed by repeating
+		// the password step.
+		u.Challenge, err = g.challenge(ctx, r, id, now)
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+
+	if err := r.LoginAttemptReset(ctx, userKey); err != nil {
+		return 0, fmt.Errorf("reset login attempts failed: %w", err)
+	}
+	return id, nil
+}
+
+func (g *LoginGuard) challenge(ctx context.Context, r repo.Repository, uid int64, now time.Time) (string, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	err = r.LoginChallengeCreate(ctx, &repo.LoginChallenge{
+		Hash:      hash,
+		UserID:    uid,
+		ExpiresAt: now.Add(g.ChallengeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("create login challenge failed: %w", err)
+	}
+	return token, nil
+}
+
+// CompleteLogin verifies the one-time or recovery code for a challenge
+// issued by Login and returns the authenticated user. A challenge is
+// consumed by the first attempt whatever its outcome.
+func (g *LoginGuard) CompleteLogin(ctx context.Context, r repo.Repository, challenge, code, ip string) (_ *UserModel, err error) {
+	ctx, end := startSpan(ctx, "loginGuard.CompleteLogin")
+	defer end(&err)
+
+	now := time.Now()
+	addrKey := addrAttemptKey(ip)
+	if err := checkLockout(ctx, r, addrKey, now); err != nil {
+		return nil, err
+	}
+
+	hash := hashToken(challenge)
+	c, err := r.LoginChallengeGet(ctx, hash)
+	if errors.Is(err, repo.ErrNotExists) {
+		return nil, ErrInvalidLoginChallenge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get login challenge failed: %w", err)
+	}
+	if c.Used || now.After(c.ExpiresAt) {
+		return nil, ErrInvalidLoginChallenge
+	}
+	if err := r.LoginChallengeUse(ctx, hash); err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return nil, ErrInvalidLoginChallenge
+		}
+		return nil, fmt.Errorf("use login challenge failed: %w", err)
+	}
+
+	user, err := r.UserGetByID(ctx, c.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("get by id user failed: %w", err)
+	}
+	if user.Locked {
+		return nil, ErrUserLocked
+	}
+	userKey := userAttemptKey(user.Username)
+	if err := checkLockout(ctx, r, userKey, now); err != nil {
+		return nil, err
+	}
+
+	t, err := enabledTwoFactor(ctx, r, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrInvalidLoginChallenge
+	}
+	err = verifyOTP(ctx, r, t, code, true)
+	if errors.Is(err, ErrInvalidOTP) {
+		if ferr := g.failed(ctx, r, userKey, addrKey, now); ferr != nil {
+			return nil, ferr
+		}
+		return nil, ErrInvalidLoginOTP
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.LoginAttemptReset(ctx, userKey); err != nil {
+		return nil, fmt.Errorf("reset login attempts failed: %w", err)
+	}
+	return &UserModel{ID: user.ID, Username: user.Username, Roles: user.Roles}, nil
+}
+
+func checkLockout(ctx context.Context, r repo.Repository, key string, now time.Time) error {
+	attempt, err := r.LoginAttemptGet(ctx, key)
+	if errors.Is(err, repo.ErrNotExists) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get login attempts failed: %w", err)
+	}
+	if now.Before(attempt.LockedUntil) {
+		return &LockedOutError{Until: attempt.LockedUntil}
+	}
+	return nil
+}
+
+// ChangePassword changes the password of u like
+// UserModel.ChangePassword. A wrong current password counts as a failed
+// login of the user from ip, so it is throttled and locked out alike.
+func (g *LoginGuard) ChangePassword(ctx context.Context, r repo.Repository, h *password.Hasher, u *UserModel,
+	current, next string, keepSession int64, ip string) (err error) {
+	ctx, end := startSpan(ctx, "loginGuard.ChangePassword")
+	defer end(&err)
+
+	return g.guard(ctx, r, u.ID, ip, func() error {
+		return u.ChangePassword(ctx, r, h, current, next, keepSession)
+	})
+}
+
+// CheckWithdrawal checks the one-time code of a withdrawal like
+// TwoFactor.CheckWithdrawal. A wrong code counts as a failed login of
+// the user from ip, so codes cannot be guessed faster than passwords.
+func (g *LoginGuard) CheckWithdrawal(ctx context.Context, r repo.Repository, f *TwoFactor,
+	uid int64, sum float64, code, ip string) (err error) {
+	ctx, end := startSpan(ctx, "loginGuard.CheckWithdrawal")
+	defer end(&err)
+
+	if code == "" || !f.requiresOTP(sum) {
+		return f.CheckWithdrawal(ctx, r, uid, sum, code)
+	}
+	return g.guard(ctx, r, uid, ip, func() error {
+		return f.CheckWithdrawal(ctx, r, uid, sum, code)
+	})
+}
+
+// DisableTwoFactor disables two-factor authentication like
+// TwoFactor.Disable. A wrong password or code counts as a failed login
+// of the user from ip.
+func (g *LoginGuard) DisableTwoFactor(ctx context.Context, r repo.Repository, h *password.Hasher, f *TwoFactor,
+	uid int64, current, code, ip string) (err error) {
+	ctx, end := startSpan(ctx, "loginGuard.DisableTwoFactor")
+	defer end(&err)
+
+	return g.guard(ctx, r, uid, ip, func() error {
+		return f.Disable(ctx, r, h, uid, current, code)
+	})
+}
+
+// guard runs check unless the user or ip are locked out and counts a
+// wrong password or one-time code it reports as a failed login.
+func (g *LoginGuard) guard(ctx context.Context, r repo.Repository, uid int64, ip string, check func() error) error {
+	user, err := r.UserGetByID(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("get by id user failed: %w", err)
+	}
+
+	now := time.Now()
+	userKey, addrKey := userAttemptKey(user.Username), addrAttemptKey(ip)
+	for _, key := range []string{userKey, addrKey} {
+		if err := checkLockout(ctx, r, key, now); err != nil {
+			return err
+		}
+	}
+
+	err = check()
+	if errors.Is(err, ErrWrongPassword) || errors.Is(err, ErrInvalidOTP) {
+		if ferr := g.failed(ctx, r, userKey, addrKey, now); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+// failed counts a failure of the user and of the address, then holds
+// the response back by the delay earned by the user.
+func (g *LoginGuard) failed(ctx context.Context, r repo.Repository, userKey, addrKey string, now time.Time) error {
+	attempt, err := r.LoginAttemptFail(ctx, userKey, now, g.Window, g.MaxFailures, g.Lockout)
+	if err != nil {
+		return fmt.Errorf("count login failure failed: %w", err)
+	}
+	_, err = r.LoginAttemptFail(ctx, addrKey, now, g.Window, g.MaxAddrFailures, g.Lockout)
+	if err != nil {
+		return fmt.Errorf("count login failure failed: %w", err)
+	}
+
+	d := g.delay(attempt.Failures)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay is BaseDelay from the second failure on, doubled on each further
+// failure up to MaxDelay.
+func (g *LoginGuard) delay(failures int) time.Duration {
+	if failures < 2 || g.BaseDelay <= 0 {
+		return 0
+	}
+	d := g.BaseDelay << (failures - 2)
+	if d <= 0 || (g.MaxDelay > 0 && d > g.MaxDelay) {
+		d = g.MaxDelay
+	}
+	return d
+}
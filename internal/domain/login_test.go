@@ -0,0 +1,215 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+	"github.com/andrei-cloud/gophermart/pkg/totp"
+)
+
+func TestLoginGuard(t *testing.T) {
+	r := inmem.NewInMemRepo()
+	h, err := password.New(password.DefaultParams())
+	require.NoError(t, err)
+	alice := UserModel{Username: "alice", Password: "secret"}
+	_, err = alice.Register(context.Background(), r, h)
+	require.NoError(t, err)
+
+	guard := LoginGuard{MaxFailures: 3, MaxAddrFailures: 10, Window: time.Minute, Lockout: time.Minute}
+	login := func(password, ip string) error {
+		_, err := guard.Login(context.Background(), r, h, &UserModel{Username: "alice", Password: password}, ip)
+		return err
+	}
+
+	t.Run("LoginGuard: success resets failures", func(t *testing.T) {
+		assert.ErrorIs(t, login("wrong", "10.0.0.1"), ErrInvalidCredentials)
+		assert.ErrorIs(t, login("wrong", "10.0.0.1"), ErrInvalidCredentials)
+		assert.NoError(t, login("secret", "10.0.0.1"))
+		assert.ErrorIs(t, login("wrong", "10.0.0.1"), ErrInvalidCredentials)
+	})
+
+	t.Run("LoginGuard: lockout refuses the right password", func(t *testing.T) {
+		assert.ErrorIs(t, login("wrong", "10.0.0.2"), ErrInvalidCredentials)
+		assert.ErrorIs(t, login("wrong", "10.0.0.3"), ErrInvalidCredentials)
+
+		err := login("secret", "10.0.0.4")
+		var lockout *LockedOutError
+		require.True(t, errors.As(err, &lockout))
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+		assert.Greater(t, lockout.RetryAfter(), time.Duration(0))
+	})
+
+	t.Run("LoginGuard: operator unlock lifts lockout", func(t *testing.T) {
+		admin := AdminModel{ID: 1}
+		require.NoError(t, admin.LockUser(context.Background(), r, alice.ID, false))
+		assert.NoError(t, login("secret", "10.0.0.4"))
+	})
+
+	t.Run("LoginGuard: unknown user is counted per address", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			_, err := guard.Login(context.Background(), r, h, &UserModel{Username: "user" + string(rune('a'+i)), Password: "x"}, "10.0.0.5")
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		}
+		assert.ErrorIs(t, login("secret", "10.0.0.5"), ErrTooManyAttempts)
+	})
+
+	t.Run("LoginGuard: progressive delay", func(t *testing.T) {
+		delayed := LoginGuard{Window: time.Minute, BaseDelay: 50 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+		bob := UserModel{Username: "bob", Password: "secret"}
+		_, err := bob.Register(context.Background(), r, h)
+		require.NoError(t, err)
+
+		login := func(password string) (error, time.Duration) {
+			start := time.Now()
+			_, err := delayed.Login(context.Background(), r, h, &UserModel{Username: "bob", Password: password}, "10.0.0.6")
+			return err, time.Since(start)
+		}
+		for _, want := range []time.Duration{0, 50, 100, 100} {
+			err, took := login("wrong")
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+			assert.GreaterOrEqual(t, took, want*time.Millisecond)
+		}
+		err, _ = login("secret")
+		assert.NoError(t, err, "delays do not lock the account")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = delayed.Login(ctx, r, h, &UserModel{Username: "bob", Password: "wrong"}, "10.0.0.6")
+		assert.ErrorIs(t, err, ErrInvalidCredentials, "first failure is not delayed")
+		_, err = delayed.Login(ctx, r, h, &UserModel{Username: "bob", Password: "wrong"}, "10.0.0.6")
+		assert.ErrorIs(t, err, context.Canceled, "delay ends with the request")
+	})
+
+	t.Run("LoginGuard: wrong current password counts as failure", func(t *testing.T) {
+		carol := UserModel{Username: "carol", Password: "secret"}
+		_, err := carol.Register(context.Background(), r, h)
+		require.NoError(t, err)
+
+		change := func(current string) error {
+			return guard.ChangePassword(context.Background(), r, h, &UserModel{ID: carol.ID}, current, "secret2", 0, "10.0.0.7")
+		}
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, change("wrong"), ErrWrongPassword)
+		}
+		assert.ErrorIs(t, change("secret"), ErrTooManyAttempts)
+		_, err = guard.Login(context.Background(), r, h, &UserModel{Username: "carol", Password: "secret"}, "10.0.0.8")
+		assert.ErrorIs(t, err, ErrTooManyAttempts, "password change failures lock the login")
+	})
+
+	t.Run("LoginGuard: wrong one-time codes count as failures", func(t *testing.T) {
+		dave := UserModel{Username: "dave", Password: "secret"}
+		_, err := dave.Register(context.Background(), r, h)
+		require.NoError(t, err)
+		f := TwoFactor{WithdrawalThreshold: 100}
+		setup, err := f.Setup(context.Background(), r, dave.ID)
+		require.NoError(t, err)
+		code, err := totp.Code(setup.Secret, totp.Step(time.Now()))
+		require.NoError(t, err)
+		recovery, err := f.Confirm(context.Background(), r, dave.ID, code)
+		require.NoError(t, err)
+
+		withdraw := func(code string) error {
+			return guard.CheckWithdrawal(context.Background(), r, &f, dave.ID, 200, code, "10.0.0.9")
+		}
+		assert.ErrorIs(t, withdraw(""), ErrOTPRequired, "a missing code is not a failure")
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, withdraw("000000"), ErrInvalidOTP)
+		}
+		next, err := totp.Code(setup.Secret, totp.Step(time.Now().Add(totp.Period)))
+		require.NoError(t, err)
+		assert.ErrorIs(t, withdraw(next), ErrTooManyAttempts, "a valid code is refused once locked out")
+		err = guard.DisableTwoFactor(context.Background(), r, h, &f, dave.ID, "secret", recovery[0], "10.0.0.10")
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+	})
+
+	t.Run("LoginGuard: wrong password to disable two-factor counts as failure", func(t *testing.T) {
+		erin := UserModel{Username: "erin", Password: "secret"}
+		_, err := erin.Register(context.Background(), r, h)
+		require.NoError(t, err)
+		f := TwoFactor{}
+		setup, err := f.Setup(context.Background(), r, erin.ID)
+		require.NoError(t, err)
+		code, err := totp.Code(setup.Secret, totp.Step(time.Now()))
+		require.NoError(t, err)
+		recovery, err := f.Confirm(context.Background(), r, erin.ID, code)
+		require.NoError(t, err)
+
+		disable := func(current string) error {
+			return guard.DisableTwoFactor(context.Background(), r, h, &f, erin.ID, current, recovery[0], "10.0.0.11")
+		}
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, disable("wrong"), ErrWrongPassword)
+		}
+		assert.ErrorIs(t, disable("secret"), ErrTooManyAttempts)
+	})
+}
+
+func TestUserLoginRehash(t *testing.T) {
+	r := inmem.NewInMemRepo()
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), 8)
+	require.NoError(t, err)
+	id, err := r.UserCreate(context.Background(), &repo.User{Username: "alice", Password: string(legacy)})
+	require.NoError(t, err)
+
+	h, err := password.New(password.DefaultParams())
+	require.NoError(t, err)
+
+	user := UserModel{Username: "alice", Password: "secret"}
+	_, err = user.Login(context.Background(), r, h)
+	require.NoError(t, err)
+
+	stored, err := r.UserGetByID(context.Background(), id)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+
+	_, err = user.Login(context.Background(), r, h)
+	assert.NoError(t, err)
+}
+
+func TestUnusablePasswordHash(t *testing.T) {
+	r := inmem.NewInMemRepo()
+	h, err := password.New(password.DefaultParams())
+	require.NoError(t, err)
+	peppered := password.DefaultParams()
+	peppered.Pepper = "other"
+	hp, err := password.New(peppered)
+	require.NoError(t, err)
+	hash, err := hp.Hash("secret")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "empty hash", hash: ""},
+		{name: "pepper missing", hash: hash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := strings.ReplaceAll(tt.name, " ", "-")
+			uid, err := r.UserCreate(context.Background(), &repo.User{Username: login, Password: tt.hash})
+			require.NoError(t, err)
+			u := UserModel{ID: uid, Username: login, Password: "secret"}
+
+			_, err = u.Login(context.Background(), r, h)
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+			err = u.ChangePassword(context.Background(), r, h, "secret", "next", 0)
+			assert.ErrorIs(t, err, ErrWrongPassword)
+			err = u.Delete(context.Background(), r, h, "secret")
+			assert.ErrorIs(t, err, ErrWrongPassword)
+			err = (&TwoFactor{}).Disable(context.Background(), r, h, uid, "secret", "000000")
+			assert.ErrorIs(t, err, ErrWrongPassword)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -8,8 +9,9 @@ import (
 )
 
 var (
-	ErrDontMatch        = errors.New("user dont match")
-	ErrIsufficientFunds = errors.New("issuficient funds")
+	ErrDontMatch        = repo.NewError(repo.KindConflict, "order_owned_by_another_user", "order uploaded by another user")
+	ErrIsufficientFunds = repo.ErrInsufficientFunds
+	ErrOrderNumberUsed  = repo.NewError(repo.KindUnprocessable, "order_number_used", "order number already used")
 )
 
 type OrderModel struct {
@@ -18,10 +20,14 @@ type OrderModel struct {
 	Status     string  `json:"status,omitempty"`
 	Value      float64 `json:"accrual,omitempty"`
 	UploadedAt string  `json:"uploaded_at,omitempty"`
+	RequestID  string  `json:"-"`
 }
 
-func (o *OrderModel) Register(r repo.Repository) error {
-	order, err := r.OrderGet(o.Number)
+func (o *OrderModel) Register(ctx context.Context, r repo.Repository) (err error) {
+	ctx, end := startSpan(ctx, "order.Register")
+	defer end(&err)
+
+	order, err := r.OrderGet(ctx, o.Number)
 	if err != nil && !errors.Is(err, repo.ErrNotExists) {
 		return err
 	} else if errors.Is(err, repo.ErrNotExists) {
@@ -31,8 +37,9 @@ func (o *OrderModel) Register(r repo.Repository) error {
 			UserID:     o.UserID,
 			Status:     repo.NEW,
 			UploadedAt: time.Now(),
+			RequestID:  o.RequestID,
 		}
-		_, err = r.OrderCreate(&order)
+		_, err = r.OrderCreate(ctx, &order)
 		if err != nil {
 			return err
 		}
@@ -45,70 +52,62 @@ func (o *OrderModel) Register(r repo.Repository) error {
 	return repo.ErrAlreadyExists
 }
 
-func (o *OrderModel) Withdraw(r repo.Repository) error {
-	_, err := r.OrderGet(o.Number)
-	if err != nil && !errors.Is(err, repo.ErrNotExists) {
-		return err
-	} else if errors.Is(err, repo.ErrNotExists) {
-		user, err := r.UserGetByID(o.UserID)
-		if err != nil {
-			return err
-		}
-
-		if user.Balance < o.Value {
-			return ErrIsufficientFunds
-		}
-
-		order := repo.Order{
-			Order:      o.Number,
-			Type:       repo.DEBIT,
-			Value:      o.Value,
-			UserID:     o.UserID,
-			UploadedAt: time.Now(),
-		}
-		_, err = r.OrderCreate(&order)
-		if err != nil {
-			return err
-		}
-
-		user.Balance -= o.Value
-		user.Withdrawal += o.Value
+func (o *OrderModel) Withdraw(ctx context.Context, r repo.Repository) (err error) {
+	ctx, end := startSpan(ctx, "order.Withdraw")
+	defer end(&err)
 
-		err = r.UserUpdate(user)
-		if err != nil {
-			return err
-		}
-
-		return nil
+	order := repo.Order{
+		Order:      o.Number,
+		Type:       repo.DEBIT,
+		Value:      o.Value,
+		UserID:     o.UserID,
+		UploadedAt: time.Now(),
 	}
-
-	return repo.ErrAlreadyExists
+	_, err = r.OrderWithdraw(ctx, &order)
+	if errors.Is(err, repo.ErrAlreadyExists) {
+		return ErrOrderNumberUsed
+	}
+	return err
 }
 
-func (o *OrderModel) CreditList(r repo.Repository) ([]OrderModel, error) {
-	orders, err := r.OrderGetList(o.UserID, repo.CREDIT)
+func (o *OrderModel) CreditList(ctx context.Context, r repo.Repository) (_ []OrderModel, err error) {
+	ctx, end := startSpan(ctx, "order.CreditList")
+	defer end(&err)
+
+	orders, err := r.OrderGetList(ctx, o.UserID, repo.CREDIT)
 	if err != nil {
 		return nil, err
 	}
+	return creditModels(orders), nil
+}
+
+func creditModels(orders []repo.Order) []OrderModel {
 	list := make([]OrderModel, 0)
 	for _, order := range orders {
 		listitem := &OrderModel{
 			UserID:     order.UserID,
 			Number:     order.Order,
 			Status:     string(order.Status),
-			Value:      order.Value,
+			Value:      order.Value + order.Bonus,
 			UploadedAt: order.UploadedAt.Format(time.RFC3339),
 		}
 		list = append(list, *listitem)
 	}
-	return list, nil
+	return list
 }
 
-func (o *OrderModel) DebitList(r repo.Repository) ([]OrderModel, error) {
-	orders, err := r.OrderGetList(o.UserID, repo.DEBIT)
+func (o *OrderModel) DebitList(ctx context.Context, r repo.Repository) (_ []OrderModel, err error) {
+	ctx, end := startSpan(ctx, "order.DebitList")
+	defer end(&err)
+
+	orders, err := r.OrderGetList(ctx, o.UserID, repo.DEBIT)
 	if err != nil {
 		return nil, err
 	}
+	return debitModels(orders), nil
+}
+
+func debitModels(orders []repo.Order) []OrderModel {
 	list := make([]OrderModel, 0)
 	for _, order := range orders {
 		listitem := &OrderModel{
@@ -120,5 +119,5 @@ func (o *OrderModel) DebitList(r repo.Repository) ([]OrderModel, error) {
 		}
 		list = append(list, *listitem)
 	}
-	return list, nil
+	return list
 }
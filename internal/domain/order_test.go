@@ -0,0 +1,82 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+)
+
+func TestWithdraw(t *testing.T) {
+	r := inmem.NewInMemRepo()
+	uid, err := r.UserCreate(context.Background(), &repo.User{Username: "alice", Balance: 100})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		order   OrderModel
+		wantErr error
+	}{
+		{
+			name:  "Withdraw: success",
+			order: OrderModel{UserID: uid, Number: "2377225624", Value: 30},
+		},
+		{
+			name:    "Withdraw: number used",
+			order:   OrderModel{UserID: uid, Number: "2377225624", Value: 10},
+			wantErr: ErrOrderNumberUsed,
+		},
+		{
+			name:    "Withdraw: insufficient funds",
+			order:   OrderModel{UserID: uid, Number: "12345678903", Value: 80},
+			wantErr: ErrIsufficientFunds,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.order.Withdraw(context.Background(), r)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	user, err := r.UserGetByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.Equal(t, float64(70), user.Balance)
+	assert.Equal(t, float64(30), user.Withdrawal)
+}
+
+func TestWithdrawConcurrent(t *testing.T) {
+	r := inmem.NewInMemRepo()
+	uid, err := r.UserCreate(context.Background(), &repo.User{Username: "alice", Balance: 100})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			order := OrderModel{UserID: uid, Number: fmt.Sprint(1000 + i), Value: 10}
+			err := order.Withdraw(context.Background(), r)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrIsufficientFunds)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	user, err := r.UserGetByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), user.Balance)
+	assert.Equal(t, float64(100), user.Withdrawal)
+}
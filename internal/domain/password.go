@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/notify"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrWrongPassword     = repo.NewError(repo.KindForbidden, "wrong_password", "current password is wrong")
+	ErrInvalidResetToken = repo.NewError(repo.KindValidation, "invalid_reset_token", "reset token is invalid or expired")
+)
+
+// ChangePassword replaces the password of the user after checking the
+// current one. All sessions but keepSession are revoked.
+func (u *UserModel) ChangePassword(ctx context.Context, r repo.Repository, h *password.Hasher, current, next string, keepSession int64) (err error) {
+	ctx, end := startSpan(ctx, "user.ChangePassword")
+	defer end(&err)
+
+	user, err := r.UserGetByID(ctx, u.ID)
+	if err != nil {
+		return fmt.Errorf("get by id user failed: %w", err)
+	}
+
+	if match, _ := verifyPassword(h, user, current, "ChangePassword"); !match {
+		return ErrWrongPassword
+	}
+
+	return setPassword(ctx, r, h, user, next, keepSession)
+}
+
+func setPassword(ctx context.Context, r repo.Repository, h *password.Hasher, user *repo.User, next string, keepSession int64) error {
+	hash, err := h.Hash(next)
+	if err != nil {
+		return err
+	}
+	if err := r.UserPasswordUpdate(ctx, user.ID, hash); err != nil {
+		return fmt.Errorf("update password failed: %w", err)
+	}
+	if err := r.SessionRevokeAll(ctx, user.ID, keepSession); err != nil {
+		return fmt.Errorf("revoke sessions failed: %w", err)
+	}
+	if err := r.LoginAttemptReset(ctx, userAttemptKey(user.Username)); err != nil {
+		return fmt.Errorf("reset login attempts failed: %w", err)
+	}
+	return nil
+}
+
+// PasswordReset issues single-use reset tokens valid for TTL and
+// delivers them through Notifier.
+type PasswordReset struct {
+	TTL      time.Duration
+	Notifier notify.Notifier
+}
+
+// Request sends a reset token to the user with login. Unknown logins
+// are not reported so that the endpoint cannot probe for accounts.
+func (p *PasswordReset) Request(ctx context.Context, r repo.Repository, login string) (err error) {
+	ctx, end := startSpan(ctx, "passwordReset.Request")
+	defer end(&err)
+
+	user, err := r.UserGet(ctx, login)
+	if errors.Is(err, repo.ErrNotExists) || (err == nil && user.Deleted) {
+		log.Debug().Str("login", login).Msg("password reset for unknown user")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get user failed: %w", err)
+	}
+
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(p.TTL)
+	err = r.PasswordResetCreate(ctx, &repo.PasswordReset{
+		Hash:      hash,
+		UserID:    user.ID,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return fmt.Errorf("create password reset failed: %w", err)
+	}
+
+	return p.Notifier.Notify(notify.Message{
+		To:      user.Username,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Use the token %s to set a new password before %s.",
+			token, expires.Format(time.RFC3339)),
+	})
+}
+
+// Confirm sets a new password using a token issued by Request and
+// revokes every session of the user.
+func (p *PasswordReset) Confirm(ctx context.Context, r repo.Repository, h *password.Hasher, token, next string) (err error) {
+	ctx, end := startSpan(ctx, "passwordReset.Confirm")
+	defer end(&err)
+
+	hash := hashToken(token)
+	reset, err := r.PasswordResetGet(ctx, hash)
+	if errors.Is(err, repo.ErrNotExists) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return fmt.Errorf("get password reset failed: %w", err)
+	}
+	if reset.Used || time.Now().After(reset.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	if err := r.PasswordResetUse(ctx, hash); err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("use password reset failed: %w", err)
+	}
+
+	user, err := r.UserGetByID(ctx, reset.UserID)
+	if err != nil {
+		return fmt.Errorf("get by id user failed: %w", err)
+	}
+	return setPassword(ctx, r, h, user, next, 0)
+}
@@ -0,0 +1,204 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+var (
+	ErrSessionRevoked = repo.NewError(repo.KindUnauthorized, "session_revoked", "session revoked")
+	ErrTokenReused    = repo.NewError(repo.KindUnauthorized, "refresh_token_reused", "refresh token reused")
+)
+
+type SessionModel struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"-"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	Current    bool   `json:"current"`
+}
+
+func newSecretToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func issueRefreshToken(ctx context.Context, r repo.Repository, sessionID int64, expires time.Time) (string, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+	err = r.RefreshTokenCreate(ctx, &repo.RefreshToken{
+		Hash:      hash,
+		SessionID: sessionID,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return "", fmt.Errorf("create refresh token failed: %w", err)
+	}
+	return token, nil
+}
+
+// Start opens a new session for the user and returns its refresh token.
+func (s *SessionModel) Start(ctx context.Context, r repo.Repository, ttl time.Duration) (_ string, err error) {
+	ctx, end := startSpan(ctx, "session.Start")
+	defer end(&err)
+
+	now := time.Now()
+	session := repo.Session{
+		UserID:     s.UserID,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	id, err := r.SessionCreate(ctx, &session)
+	if err != nil {
+		return "", fmt.Errorf("create session failed: %w", err)
+	}
+	s.ID = id
+
+	return issueRefreshToken(ctx, r, id, session.ExpiresAt)
+}
+
+// Refresh rotates the refresh token of the session it belongs to.
+// Presenting an already used token revokes the whole session.
+func (s *SessionModel) Refresh(ctx context.Context, r repo.Repository, token string, ttl time.Duration) (_ string, err error) {
+	ctx, end := startSpan(ctx, "session.Refresh")
+	defer end(&err)
+
+	hash := hashToken(token)
+	stored, err := r.RefreshTokenGet(ctx, hash)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return "", ErrSessionRevoked
+		}
+		return "", err
+	}
+
+	session, err := r.SessionGet(ctx, stored.SessionID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return "", ErrSessionRevoked
+		}
+		return "", err
+	}
+	now := time.Now()
+	if session.Revoked || now.After(session.ExpiresAt) || now.After(stored.ExpiresAt) {
+		return "", ErrSessionRevoked
+	}
+
+	if stored.Used {
+		if err := r.SessionRevoke(ctx, session.ID); err != nil {
+			return "", err
+		}
+		return "", ErrTokenReused
+	}
+	if err := r.RefreshTokenUse(ctx, hash); err != nil {
+		// lost the race against a concurrent refresh with the same token
+		if errors.Is(err, repo.ErrNotExists) {
+			if err := r.SessionRevoke(ctx, session.ID); err != nil {
+				return "", err
+			}
+			return "", ErrTokenReused
+		}
+		return "", err
+	}
+
+	expires := now.Add(ttl)
+	if err := r.SessionTouch(ctx, session.ID, now, expires); err != nil {
+		return "", err
+	}
+
+	s.ID = session.ID
+	s.UserID = session.UserID
+	return issueRefreshToken(ctx, r, session.ID, expires)
+}
+
+// Active reports an error unless the session is live.
+func (s *SessionModel) Active(ctx context.Context, r repo.Repository) (err error) {
+	ctx, end := startSpan(ctx, "session.Active")
+	defer end(&err)
+
+	session, err := r.SessionGet(ctx, s.ID)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return ErrSessionRevoked
+		}
+		return err
+	}
+	if session.Revoked || session.UserID != s.UserID || time.Now().After(session.ExpiresAt) {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (s *SessionModel) List(ctx context.Context, r repo.Repository) (_ []SessionModel, err error) {
+	ctx, end := startSpan(ctx, "session.List")
+	defer end(&err)
+
+	sessions, err := r.SessionGetList(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	list := make([]SessionModel, 0)
+	for _, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+		list = append(list, SessionModel{
+			ID:         session.ID,
+			UserID:     session.UserID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt.Format(time.RFC3339),
+			LastUsedAt: session.LastUsedAt.Format(time.RFC3339),
+			Current:    session.ID == s.ID,
+		})
+	}
+	return list, nil
+}
+
+// Revoke ends the session id owned by the user.
+func (s *SessionModel) Revoke(ctx context.Context, r repo.Repository, id int64) (err error) {
+	ctx, end := startSpan(ctx, "session.Revoke")
+	defer end(&err)
+
+	session, err := r.SessionGet(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session.UserID != s.UserID {
+		return repo.ErrNotExists
+	}
+	return r.SessionRevoke(ctx, id)
+}
+
+// RevokeOthers ends every session of the user except the current one.
+func (s *SessionModel) RevokeOthers(ctx context.Context, r repo.Repository) (err error) {
+	ctx, end := startSpan(ctx, "session.RevokeOthers")
+	defer end(&err)
+
+	return r.SessionRevokeAll(ctx, s.UserID, s.ID)
+}
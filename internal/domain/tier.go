@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+// TierPeriod is the rolling window of accruals used to assign a tier.
+const TierPeriod = 365 * 24 * time.Hour
+
+type TierLevel struct {
+	Tier       repo.UserTier
+	Threshold  float64
+	Multiplier float64
+}
+
+// Tiers holds the tier ladder ordered by ascending threshold.
+type Tiers []TierLevel
+
+func NewTiers(levels ...TierLevel) Tiers {
+	t := make(Tiers, len(levels))
+	copy(t, levels)
+	sort.Slice(t, func(i, j int) bool { return t[i].Threshold < t[j].Threshold })
+	return t
+}
+
+// For returns the highest tier whose threshold is reached by accrued.
+func (t Tiers) For(accrued float64) repo.UserTier {
+	tier := repo.BASIC
+	for _, level := range t {
+		if accrued >= level.Threshold {
+			tier = level.Tier
+		}
+	}
+	return tier
+}
+
+func (t Tiers) Multiplier(tier repo.UserTier) float64 {
+	for _, level := range t {
+		if level.Tier == tier {
+			return level.Multiplier
+		}
+	}
+	return 1
+}
+
+// Bonus calculates the extra points granted on top of accrual
+// for the owner of the order according to the owner's tier.
+func (t Tiers) Bonus(ctx context.Context, r repo.Repository, number string, accrual float64) (float64, error) {
+	if accrual <= 0 {
+		return 0, nil
+	}
+
+	order, err := r.OrderGet(ctx, number)
+	if err != nil {
+		return 0, fmt.Errorf("get order failed: %w", err)
+	}
+
+	user, err := r.UserGetByID(ctx, order.UserID)
+	if err != nil {
+		return 0, fmt.Errorf("get by id user failed: %w", err)
+	}
+
+	bonus := accrual * (t.Multiplier(user.Tier) - 1)
+	if bonus <= 0 {
+		return 0, nil
+	}
+	return math.Round(bonus*100) / 100, nil
+}
+
+// Recalculate assigns every user the tier matching
+// the accruals over the last TierPeriod.
+func (t Tiers) Recalculate(ctx context.Context, r repo.Repository, now time.Time) error {
+	accruals, err := r.UserAccruals(ctx, now.Add(-TierPeriod))
+	if err != nil {
+		return fmt.Errorf("get accruals failed: %w", err)
+	}
+
+	for id, accrued := range accruals {
+		err = r.UserTierUpdate(ctx, id, t.For(accrued))
+		if err != nil {
+			return fmt.Errorf("update tier failed: %w", err)
+		}
+	}
+	return nil
+}
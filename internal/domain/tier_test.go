@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+)
+
+func TestTiers(t *testing.T) {
+	tiers := NewTiers(
+		TierLevel{Tier: repo.GOLD, Threshold: 500, Multiplier: 1.1},
+		TierLevel{Tier: repo.SILVER, Threshold: 100, Multiplier: 1.05},
+		TierLevel{Tier: repo.PLATINUM, Threshold: 1000, Multiplier: 1.2},
+	)
+
+	tests := []struct {
+		name      string
+		tier      repo.UserTier
+		accrued   float64
+		accrual   float64
+		wantBonus float64
+		wantTier  repo.UserTier
+	}{
+		{
+			name:      "Bonus: basic tier gets no bonus and reaches gold",
+			tier:      repo.BASIC,
+			accrual:   600,
+			wantBonus: 0,
+			wantTier:  repo.GOLD,
+		},
+		{
+			name:      "Bonus: gold tier gets multiplier and reaches platinum",
+			tier:      repo.GOLD,
+			accrued:   600,
+			accrual:   400,
+			wantBonus: 40,
+			wantTier:  repo.PLATINUM,
+		},
+		{
+			name:      "Bonus: silver tier gets multiplier and stays silver",
+			tier:      repo.SILVER,
+			accrued:   100,
+			accrual:   100,
+			wantBonus: 5,
+			wantTier:  repo.SILVER,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := inmem.NewInMemRepo()
+			uid, err := r.UserCreate(context.Background(), &repo.User{Username: "user", Tier: tt.tier})
+			require.NoError(t, err)
+			if tt.accrued > 0 {
+				_, err = r.OrderCreate(context.Background(), &repo.Order{Order: "1", Type: repo.CREDIT, UserID: uid,
+					Status: repo.PROCESSED, Value: tt.accrued, UploadedAt: time.Now()})
+				require.NoError(t, err)
+			}
+			_, err = r.OrderCreate(context.Background(), &repo.Order{Order: "2", Type: repo.CREDIT, UserID: uid,
+				Status: repo.NEW, UploadedAt: time.Now()})
+			require.NoError(t, err)
+
+			bonus, err := tiers.Bonus(context.Background(), r, "2", tt.accrual)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBonus, bonus)
+
+			_, err = r.OrderUpdate(context.Background(), "2", repo.PROCESSED, tt.accrual, bonus)
+			require.NoError(t, err)
+
+			err = tiers.Recalculate(context.Background(), r, time.Now())
+			require.NoError(t, err)
+
+			user, err := r.UserGetByID(context.Background(), uid)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantTier, user.Tier)
+		})
+	}
+}
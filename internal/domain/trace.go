@@ -0,0 +1,15 @@
+package domain
+
+import (
+	"context"
+
+	"github.com/andrei-cloud/gophermart/internal/tracing"
+)
+
+// startSpan starts the span of the domain call name under ctx. The
+// returned function records the outcome held in err and ends the span;
+// callers defer it with their named error result.
+func startSpan(ctx context.Context, name string) (context.Context, func(*error)) {
+	ctx, span := tracing.Tracer().Start(ctx, name)
+	return ctx, func(err *error) { tracing.End(span, *err) }
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+var (
+	ErrSelfTransfer     = repo.NewError(repo.KindValidation, "self_transfer", "transfer to self")
+	ErrUnknownRecipient = repo.NewError(repo.KindNotFound, "unknown_recipient", "unknown recipient")
+	ErrTransferLimit    = repo.ErrTransferLimit
+	ErrInvalidTransfer  = repo.NewError(repo.KindValidation, "invalid_transfer", "invalid transfer")
+)
+
+const (
+	TransferIn  = "in"
+	TransferOut = "out"
+
+	MaxTransferMemoLength = 140
+)
+
+// TransferLimits bounds a single transfer and the total sent over a day.
+// Zero value disables the corresponding check.
+type TransferLimits struct {
+	Min   float64
+	Max   float64
+	Daily float64
+}
+
+type TransferModel struct {
+	UserID      int64   `json:"-"`
+	Login       string  `json:"login,omitempty" validate:"required"`
+	Value       float64 `json:"sum" validate:"gt=0"`
+	Memo        string  `json:"memo,omitempty" validate:"max=140"`
+	Direction   string  `json:"direction,omitempty"`
+	ProcessedAt string  `json:"processed_at,omitempty"`
+}
+
+func (t *TransferModel) Transfer(ctx context.Context, r repo.Repository, limits TransferLimits) (err error) {
+	ctx, end := startSpan(ctx, "transfer.Transfer")
+	defer end(&err)
+
+	if t.Value <= 0 || utf8.RuneCountInString(t.Memo) > MaxTransferMemoLength {
+		return ErrInvalidTransfer
+	}
+	if (limits.Min > 0 && t.Value < limits.Min) || (limits.Max > 0 && t.Value > limits.Max) {
+		return ErrTransferLimit
+	}
+
+	recipient, err := r.UserGet(ctx, t.Login)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return ErrUnknownRecipient
+		}
+		return fmt.Errorf("get recipient failed: %w", err)
+	}
+	if recipient.Deleted {
+		return ErrUnknownRecipient
+	}
+	if recipient.ID == t.UserID {
+		return ErrSelfTransfer
+	}
+
+	_, err = r.TransferCreate(ctx, &repo.Transfer{
+		FromUserID: t.UserID,
+		ToUserID:   recipient.ID,
+		Value:      t.Value,
+		Memo:       t.Memo,
+		CreatedAt:  time.Now(),
+	}, limits.Daily)
+	if err != nil {
+		return fmt.Errorf("create transfer failed: %w", err)
+	}
+	return nil
+}
+
+func (t *TransferModel) List(ctx context.Context, r repo.Repository) (_ []TransferModel, err error) {
+	ctx, end := startSpan(ctx, "transfer.List")
+	defer end(&err)
+
+	transfers, err := r.TransferGetList(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return transferModels(t.UserID, transfers), nil
+}
+
+func transferModels(uid int64, transfers []repo.Transfer) []TransferModel {
+	list := make([]TransferModel, 0)
+	for _, transfer := range transfers {
+		listitem := &TransferModel{
+			UserID:      uid,
+			Login:       transfer.To,
+			Value:       transfer.Value,
+			Memo:        transfer.Memo,
+			Direction:   TransferOut,
+			ProcessedAt: transfer.CreatedAt.Format(time.RFC3339),
+		}
+		if transfer.ToUserID == uid {
+			listitem.Login = transfer.From
+			listitem.Direction = TransferIn
+		}
+		list = append(list, *listitem)
+	}
+	return list
+}
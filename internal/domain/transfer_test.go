@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+)
+
+func TestTransfer(t *testing.T) {
+	r := inmem.NewInMemRepo()
+	_, err := r.UserCreate(context.Background(), &repo.User{Username: "alice", Balance: 100})
+	require.NoError(t, err)
+	_, err = r.UserCreate(context.Background(), &repo.User{Username: "bob"})
+	require.NoError(t, err)
+
+	limits := TransferLimits{Min: 1, Max: 80, Daily: 90}
+	memo := strings.Repeat("я", MaxTransferMemoLength)
+
+	tests := []struct {
+		name     string
+		transfer TransferModel
+		wantErr  error
+	}{
+		{
+			name:     "Transfer: to self",
+			transfer: TransferModel{UserID: 1, Login: "alice", Value: 10},
+			wantErr:  ErrSelfTransfer,
+		},
+		{
+			name:     "Transfer: unknown recipient",
+			transfer: TransferModel{UserID: 1, Login: "carol", Value: 10},
+			wantErr:  ErrUnknownRecipient,
+		},
+		{
+			name:     "Transfer: negative sum",
+			transfer: TransferModel{UserID: 1, Login: "bob", Value: -10},
+			wantErr:  ErrInvalidTransfer,
+		},
+		{
+			name:     "Transfer: above single limit",
+			transfer: TransferModel{UserID: 1, Login: "bob", Value: 85},
+			wantErr:  ErrTransferLimit,
+		},
+		{
+			name:     "Transfer: memo too long",
+			transfer: TransferModel{UserID: 1, Login: "bob", Value: 10, Memo: memo + "я"},
+			wantErr:  ErrInvalidTransfer,
+		},
+		{
+			name:     "Transfer: success",
+			transfer: TransferModel{UserID: 1, Login: "bob", Value: 60, Memo: memo},
+			wantErr:  nil,
+		},
+		{
+			name:     "Transfer: above daily limit",
+			transfer: TransferModel{UserID: 1, Login: "bob", Value: 35},
+			wantErr:  ErrTransferLimit,
+		},
+		{
+			name:     "Transfer: insufficient funds",
+			transfer: TransferModel{UserID: 2, Login: "alice", Value: 70},
+			wantErr:  ErrIsufficientFunds,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.transfer.Transfer(context.Background(), r, limits)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+
+	alice, err := r.UserGet(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.Equal(t, float64(40), alice.Balance)
+
+	list, err := (&TransferModel{UserID: 2}).List(context.Background(), r)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "alice", list[0].Login)
+	assert.Equal(t, TransferIn, list[0].Direction)
+	assert.Equal(t, memo, list[0].Memo, "the memo limit counts characters, not bytes")
+}
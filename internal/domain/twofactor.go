@@ -0,0 +1,251 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+	"github.com/andrei-cloud/gophermart/pkg/totp"
+)
+
+var (
+	ErrOTPRequired           = repo.NewError(repo.KindForbidden, "otp_required", "one-time code required")
+	ErrInvalidOTP            = repo.NewError(repo.KindForbidden, "invalid_otp", "one-time code is invalid")
+	ErrTwoFactorEnabled      = repo.NewError(repo.KindConflict, "two_factor_enabled", "two-factor authentication is already enabled")
+	ErrTwoFactorDisabled     = repo.NewError(repo.KindConflict, "two_factor_disabled", "two-factor authentication is not enabled")
+	ErrInvalidLoginChallenge = repo.NewError(repo.KindUnauthorized, "invalid_login_challenge", "login challenge is invalid or expired")
+	ErrInvalidLoginOTP       = repo.NewError(repo.KindUnauthorized, "invalid_otp", "one-time code is invalid")
+)
+
+const (
+	// otpSkew accepts codes of the neighbouring time steps to tolerate
+	// clock drift of the authenticator.
+	otpSkew           = 1
+	recoveryCodeCount = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor configures TOTP enrolment. Withdrawals above
+// WithdrawalThreshold need a fresh code from enrolled users; zero
+// disables the check.
+type TwoFactor struct {
+	Issuer              string
+	WithdrawalThreshold float64
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URL    string `json:"otpauth_url"`
+}
+
+type TwoFactorStatus struct {
+	Enabled       bool `json:"enabled"`
+	RecoveryCodes int  `json:"recovery_codes_left"`
+}
+
+// Setup starts enrolment with a new secret. The secret becomes
+// effective once Confirm receives a valid code.
+func (f *TwoFactor) Setup(ctx context.Context, r repo.Repository, uid int64) (_ *TwoFactorSetup, err error) {
+	ctx, end := startSpan(ctx, "twoFactor.Setup")
+	defer end(&err)
+
+	user, err := r.UserGetByID(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("get by id user failed: %w", err)
+	}
+	current, err := r.TwoFactorGet(ctx, uid)
+	if err != nil && !errors.Is(err, repo.ErrNotExists) {
+		return nil, fmt.Errorf("get two-factor failed: %w", err)
+	}
+	if err == nil && current.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.TwoFactorSave(ctx, &repo.TwoFactor{UserID: uid, Secret: secret}); err != nil {
+		return nil, fmt.Errorf("save two-factor failed: %w", err)
+	}
+	return &TwoFactorSetup{
+		Secret: secret,
+		URL:    totp.URL(f.Issuer, user.Username, secret),
+	}, nil
+}
+
+// Confirm enables two-factor authentication and returns the recovery
+// codes. They are shown only once.
+func (f *TwoFactor) Confirm(ctx context.Context, r repo.Repository, uid int64, code string) (_ []string, err error) {
+	ctx, end := startSpan(ctx, "twoFactor.Confirm")
+	defer end(&err)
+
+	t, err := r.TwoFactorGet(ctx, uid)
+	if errors.Is(err, repo.ErrNotExists) {
+		return nil, ErrTwoFactorDisabled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get two-factor failed: %w", err)
+	}
+	if t.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := totp.Validate(t.Secret, code, time.Now(), otpSkew)
+	if !ok {
+		return nil, ErrInvalidOTP
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	t.Enabled = true
+	t.LastStep = step
+	t.RecoveryCodes = hashes
+	if err := r.TwoFactorSave(ctx, t); err != nil {
+		return nil, fmt.Errorf("save two-factor failed: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable removes the enrolment after checking the password and either
+// a one-time or a recovery code.
+func (f *TwoFactor) Disable(ctx context.Context, r repo.Repository, h *password.Hasher, uid int64, current, code string) (err error) {
+	ctx, end := startSpan(ctx, "twoFactor.Disable")
+	defer end(&err)
+
+	user, err := r.UserGetByID(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("get by id user failed: %w", err)
+	}
+	if match, _ := verifyPassword(h, user, current, "Disable"); !match {
+		return ErrWrongPassword
+	}
+
+	t, err := enabledTwoFactor(ctx, r, uid)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return ErrTwoFactorDisabled
+	}
+	if err := verifyOTP(ctx, r, t, code, true); err != nil {
+		return err
+	}
+
+	if err := r.TwoFactorDelete(ctx, uid); err != nil {
+		return fmt.Errorf("delete two-factor failed: %w", err)
+	}
+	return nil
+}
+
+func (f *TwoFactor) Status(ctx context.Context, r repo.Repository, uid int64) (_ *TwoFactorStatus, err error) {
+	ctx, end := startSpan(ctx, "twoFactor.Status")
+	defer end(&err)
+
+	t, err := enabledTwoFactor(ctx, r, uid)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return &TwoFactorStatus{}, nil
+	}
+	return &TwoFactorStatus{Enabled: true, RecoveryCodes: len(t.RecoveryCodes)}, nil
+}
+
+// CheckWithdrawal requires a fresh one-time code for withdrawals above
+// the threshold by users who enabled two-factor authentication.
+// Recovery codes are not accepted here.
+func (f *TwoFactor) CheckWithdrawal(ctx context.Context, r repo.Repository, uid int64, sum float64, code string) (err error) {
+	ctx, end := startSpan(ctx, "twoFactor.CheckWithdrawal")
+	defer end(&err)
+
+	if !f.requiresOTP(sum) {
+		return nil
+	}
+	t, err := enabledTwoFactor(ctx, r, uid)
+	if err != nil || t == nil {
+		return err
+	}
+	if code == "" {
+		return ErrOTPRequired
+	}
+	return verifyOTP(ctx, r, t, code, false)
+}
+
+// requiresOTP reports whether withdrawing sum needs a one-time code
+// from enrolled users.
+func (f *TwoFactor) requiresOTP(sum float64) bool {
+	return f.WithdrawalThreshold > 0 && sum > f.WithdrawalThreshold
+}
+
+// enabledTwoFactor returns the enrolment of the user, or nil when
+// two-factor authentication is not enabled.
+func enabledTwoFactor(ctx context.Context, r repo.Repository, uid int64) (*repo.TwoFactor, error) {
+	t, err := r.TwoFactorGet(ctx, uid)
+	if errors.Is(err, repo.ErrNotExists) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get two-factor failed: %w", err)
+	}
+	if !t.Enabled {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// verifyOTP accepts each time step once so that an observed code
+// cannot be replayed. Recovery codes are consumed on use.
+func verifyOTP(ctx context.Context, r repo.Repository, t *repo.TwoFactor, code string, recovery bool) error {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(t.Secret, code, time.Now(), otpSkew); ok {
+		err := r.TwoFactorUseStep(ctx, t.UserID, step)
+		if errors.Is(err, repo.ErrNotExists) {
+			return ErrInvalidOTP
+		}
+		if err != nil {
+			return fmt.Errorf("use one-time code failed: %w", err)
+		}
+		return nil
+	}
+
+	if !recovery || code == "" {
+		return ErrInvalidOTP
+	}
+	err := r.TwoFactorUseRecoveryCode(ctx, t.UserID, hashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, repo.ErrNotExists) {
+		return ErrInvalidOTP
+	}
+	if err != nil {
+		return fmt.Errorf("use recovery code failed: %w", err)
+	}
+	return nil
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	b := make([]byte, 5)
+	for i := 0; i < recoveryCodeCount; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
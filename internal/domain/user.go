@@ -1,66 +1,138 @@
 package domain
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/andrei-cloud/gophermart/internal/repo"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/andrei-cloud/gophermart/pkg/password"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidCredentials = repo.NewError(repo.KindUnauthorized, "invalid_credentials", "invalid login or password")
+	ErrLoginTaken         = repo.NewError(repo.KindConflict, "login_taken", "login already taken")
 )
 
 type User interface {
-	Register(repo.Repository) (int64, error)
-	Login(repo.Repository) error
-	GetBalance(repo.Repository) (float64, error)
+	Register(context.Context, repo.Repository, *password.Hasher) (int64, error)
+	Login(context.Context, repo.Repository, *password.Hasher) (int64, error)
+	GetBalance(context.Context, repo.Repository) (*BalanceModel, error)
+}
+
+// BalanceModel is the balance of a user. PendingOrders counts orders
+// still awaiting accrual and Pending sums the accrual already reported
+// for them, which is credited once they are processed.
+type BalanceModel struct {
+	Current       float64 `json:"current"`
+	Withdrawn     float64 `json:"withdrawn"`
+	Pending       float64 `json:"pending"`
+	PendingOrders int64   `json:"pending_orders"`
+	Tier          string  `json:"tier"`
 }
 
 type UserModel struct {
-	ID       int64  `json:"-"`
-	Username string `json:"login"`
-	Password string `json:"password"`
+	ID       int64    `json:"-"`
+	Username string   `json:"login" validate:"required,max=64"`
+	Password string   `json:"password" validate:"required,maxbytes=72"`
+	Roles    []string `json:"-"`
+	// Challenge is set by LoginGuard.Login when a second factor is
+	// required.
+	Challenge string `json:"-"`
 }
 
-func (u *UserModel) Register(r repo.Repository) (int64, error) {
-	var err error
-	err = u.hashPassword()
+func (u *UserModel) Register(ctx context.Context, r repo.Repository, h *password.Hasher) (_ int64, err error) {
+	ctx, end := startSpan(ctx, "user.Register")
+	defer end(&err)
+
+	hash, err := h.Hash(u.Password)
 	if err != nil {
 		return 0, err
 	}
-	id, err := r.UserCreate(&repo.User{Username: u.Username, Password: u.Password})
+	id, err := r.UserCreate(ctx, &repo.User{Username: u.Username, Password: hash})
 	if err != nil {
+		if errors.Is(err, repo.ErrAlreadyExists) {
+			return 0, ErrLoginTaken
+		}
 		return 0, fmt.Errorf("register user failed: %w", err)
 	}
+	u.ID = id
 	return id, nil
 }
 
-func (u *UserModel) Login(r repo.Repository) (int64, error) {
-	user, err := r.UserGet(u.Username)
+// Login verifies the credentials and upgrades the stored hash when
+// it was made with outdated parameters.
+func (u *UserModel) Login(ctx context.Context, r repo.Repository, h *password.Hasher) (int64, error) {
+	user, err := r.UserGet(ctx, u.Username)
 	if err != nil {
-		return 0, repo.ErrNotExists
+		if errors.Is(err, repo.ErrNotExists) {
+			h.VerifyDummy(u.Password)
+			return 0, ErrInvalidCredentials
+		}
+		return 0, fmt.Errorf("get user failed: %w", err)
 	}
 
-	if !u.checkPasswordHash(user.Password) {
-		return 0, repo.ErrNotExists
+	match, rehash := verifyPassword(h, user, u.Password, "Login")
+	if !match {
+		return 0, ErrInvalidCredentials
 	}
 
+	if user.Locked {
+		return 0, ErrUserLocked
+	}
+
+	if rehash {
+		hash, err := h.Hash(u.Password)
+		if err == nil {
+			err = r.UserPasswordUpdate(ctx, user.ID, hash)
+		}
+		if err != nil {
+			log.Error().AnErr("rehash", err).Msg("Login")
+		}
+	}
+
+	u.ID = user.ID
+	u.Roles = user.Roles
 	return user.ID, nil
 }
 
-func (u *UserModel) GetBalance(r repo.Repository) (map[string]float64, error) {
-	user, err := r.UserGetByID(u.ID)
+// verifyPassword checks plain against the stored hash of user. A
+// hash that cannot be checked, such as the empty hash of an anonymised
+// account or one made with another pepper, is logged and never matches.
+func verifyPassword(h *password.Hasher, user *repo.User, plain, caller string) (match, rehash bool) {
+	match, rehash, err := h.Verify(plain, user.Password)
+	if err != nil {
+		log.Warn().Err(err).Int64("user_id", user.ID).Msg(caller)
+		return false, false
+	}
+	return match, rehash
+}
+
+func (u *UserModel) GetBalance(ctx context.Context, r repo.Repository) (_ *BalanceModel, err error) {
+	ctx, end := startSpan(ctx, "user.GetBalance")
+	defer end(&err)
+
+	user, err := r.UserGetByID(ctx, u.ID)
 	if err != nil {
 		return nil, fmt.Errorf("get by id user failed: %w", err)
 	}
 
-	return map[string]float64{"current": user.Balance, "withdrawn": user.Withdrawal}, nil
-}
+	tier := user.Tier
+	if tier == "" {
+		tier = repo.BASIC
+	}
 
-func (u *UserModel) hashPassword() error {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(u.Password), 8)
-	u.Password = string(bytes)
-	return err
-}
+	count, pending, err := r.OrderPending(ctx, u.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get pending orders failed: %w", err)
+	}
 
-func (u *UserModel) checkPasswordHash(hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(u.Password))
-	return err == nil
+	return &BalanceModel{
+		Current:       user.Balance,
+		Withdrawn:     user.Withdrawal,
+		Pending:       pending,
+		PendingOrders: count,
+		Tier:          string(tier),
+	}, nil
 }
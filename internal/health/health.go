@@ -0,0 +1,152 @@
+// Package health runs dependency checks for the readiness probe.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check and report statuses. A failing non-critical check degrades the
+// report without making the instance unready.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+const defaultTimeout = 2 * time.Second
+
+var errTimeout = errors.New("check timed out")
+
+// Check probes a dependency. Run returns details worth reporting, if
+// any, and an error when the dependency is not usable. Run should honour
+// ctx; it is abandoned once the timeout expires either way.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) (map[string]string, error)
+}
+
+type Result struct {
+	Status   string            `json:"status"`
+	Critical bool              `json:"critical"`
+	Error    string            `json:"error,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+	Duration string            `json:"duration"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Ready reports whether the instance should receive traffic.
+func (r Report) Ready() bool {
+	return r.Status == StatusOK || r.Status == StatusDegraded
+}
+
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	draining int32
+}
+
+func New(checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: defaultTimeout}
+}
+
+// WithTimeout bounds each check; non-positive values keep the default.
+func (c *Checker) WithTimeout(d time.Duration) *Checker {
+	if d > 0 {
+		c.timeout = d
+	}
+	return c
+}
+
+// Add registers more checks. It must not race with Run.
+func (c *Checker) Add(checks ...Check) {
+	c.checks = append(c.checks, checks...)
+}
+
+// Drain makes every later report fail so that load balancers stop
+// routing to the instance before it shuts down.
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+func (c *Checker) Draining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// Run executes the checks concurrently.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			res := c.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = res
+			switch {
+			case res.Status == StatusOK:
+			case check.Critical:
+				report.Status = StatusFail
+			case report.Status == StatusOK:
+				report.Status = StatusDegraded
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	if c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type outcome struct {
+		details map[string]string
+		err     error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		details, err := check.Run(ctx)
+		done <- outcome{details, err}
+	}()
+
+	var out outcome
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = errTimeout
+	}
+
+	res := Result{
+		Status:   StatusOK,
+		Critical: check.Critical,
+		Details:  out.details,
+		Duration: time.Since(start).Round(time.Microsecond).String(),
+	}
+	if out.err != nil {
+		res.Status = StatusFail
+		res.Error = out.err.Error()
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func check(name string, critical bool, err error) Check {
+	return Check{Name: name, Critical: critical, Run: func(context.Context) (map[string]string, error) {
+		return map[string]string{"name": name}, err
+	}}
+}
+
+func TestChecker_Run(t *testing.T) {
+	down := errors.New("down")
+
+	tests := []struct {
+		name   string
+		checks []Check
+		status string
+		ready  bool
+	}{
+		{"no checks", nil, StatusOK, true},
+		{"all pass", []Check{check("db", true, nil), check("accrual", false, nil)}, StatusOK, true},
+		{"non-critical fails", []Check{check("db", true, nil), check("accrual", false, down)}, StatusDegraded, true},
+		{"critical fails", []Check{check("db", true, down), check("accrual", false, down)}, StatusFail, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := New(tt.checks...).Run(context.Background())
+			assert.Equal(t, tt.status, report.Status)
+			assert.Equal(t, tt.ready, report.Ready())
+			assert.Len(t, report.Checks, len(tt.checks))
+		})
+	}
+
+	t.Run("result", func(t *testing.T) {
+		res := New(check("accrual", false, down)).Run(context.Background()).Checks["accrual"]
+		assert.Equal(t, StatusFail, res.Status)
+		assert.Equal(t, "down", res.Error)
+		assert.Equal(t, map[string]string{"name": "accrual"}, res.Details)
+		assert.NotEmpty(t, res.Duration)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		c := New(Check{Name: "stuck", Critical: true, Run: func(context.Context) (map[string]string, error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		}})
+		c.timeout = 10 * time.Millisecond
+
+		start := time.Now()
+		report := c.Run(context.Background())
+		assert.Less(t, time.Since(start), time.Second, "stuck checks are abandoned")
+		assert.Equal(t, StatusFail, report.Status)
+		assert.Equal(t, errTimeout.Error(), report.Checks["stuck"].Error)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		c := New(check("db", true, nil))
+		c.Drain()
+		report := c.Run(context.Background())
+		assert.Equal(t, StatusDraining, report.Status)
+		assert.False(t, report.Ready())
+		assert.Equal(t, StatusOK, report.Checks["db"].Status, "checks are still reported")
+	})
+}
@@ -0,0 +1,207 @@
+// Package metrics exposes the service metrics in Prometheus format.
+//
+// The metric names below are part of the operational contract and are
+// kept stable; dashboards and alerts depend on them.
+//
+//	gophermart_http_requests_total{method,route,status}            counter
+//	gophermart_http_request_duration_seconds{method,route,status}  histogram
+//	gophermart_accrual_requests_total{outcome}                     counter
+//	gophermart_accrual_request_duration_seconds{outcome}           histogram
+//	gophermart_worker_queue_depth                                  gauge
+//	gophermart_order_age_seconds{status}                           gauge
+//	gophermart_repository_duration_seconds{method}                 histogram
+//	gophermart_points_accrued_total                                counter
+//	gophermart_points_withdrawn_total                              counter
+//	gophermart_rate_limit_errors_total{policy}                     counter
+//
+// route is the matched route pattern, or "unmatched" for unknown paths.
+// outcome is one of 200, 204, 429, 5xx, other or error. order age is
+// the age of the oldest order waiting for accrual in each status.
+// rate limit errors count requests let through because the rate limit
+// store failed. Go runtime and process metrics are exported as well.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+// Accrual call outcomes.
+const (
+	OutcomeOK          = "200"
+	OutcomeNoContent   = "204"
+	OutcomeRateLimited = "429"
+	OutcomeServerError = "5xx"
+	OutcomeOther       = "other"
+	OutcomeError       = "error"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	accrualRequests *prometheus.CounterVec
+	accrualDuration *prometheus.HistogramVec
+	queueDepth      prometheus.Gauge
+	orderAge        *prometheus.GaugeVec
+	repoDuration    *prometheus.HistogramVec
+	pointsAccrued   prometheus.Counter
+	pointsWithdrawn prometheus.Counter
+	rateLimitErrors *prometheus.CounterVec
+}
+
+// New creates the metrics in a registry of their own so that several
+// instances, as in tests, do not collide.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		accrualRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "accrual_requests_total",
+			Help:      "Calls to the accrual system by outcome.",
+		}, []string{"outcome"}),
+		accrualDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "accrual_request_duration_seconds",
+			Help:      "Latency of calls to the accrual system by outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_queue_depth",
+			Help:      "Orders waiting for accrual at the last poll.",
+		}),
+		orderAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "order_age_seconds",
+			Help:      "Age of the oldest order waiting for accrual by status.",
+		}, []string{"status"}),
+		repoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_duration_seconds",
+			Help:      "Latency of repository calls by method.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"method"}),
+		pointsAccrued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "points_accrued_total",
+			Help:      "Points credited for processed orders, bonuses included.",
+		}),
+		pointsWithdrawn: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "points_withdrawn_total",
+			Help:      "Points spent on withdrawals.",
+		}),
+		rateLimitErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_errors_total",
+			Help:      "Requests let through unchecked because the rate limit store failed, by policy.",
+		}, []string{"policy"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.accrualRequests, m.accrualDuration,
+		m.queueDepth, m.orderAge,
+		m.repoDuration,
+		m.pointsAccrued, m.pointsWithdrawn,
+		m.rateLimitErrors,
+	)
+	return m
+}
+
+// Handler serves the metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) ObserveHTTP(method, route string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, code).Inc()
+	m.httpDuration.WithLabelValues(method, route, code).Observe(d.Seconds())
+}
+
+// ObserveAccrual records a call to the accrual system. A non-nil err
+// means no response was received.
+func (m *Metrics) ObserveAccrual(status int, err error, d time.Duration) {
+	outcome := OutcomeOther
+	switch {
+	case err != nil:
+		outcome = OutcomeError
+	case status == http.StatusOK:
+		outcome = OutcomeOK
+	case status == http.StatusNoContent:
+		outcome = OutcomeNoContent
+	case status == http.StatusTooManyRequests:
+		outcome = OutcomeRateLimited
+	case status >= http.StatusInternalServerError:
+		outcome = OutcomeServerError
+	}
+	m.accrualRequests.WithLabelValues(outcome).Inc()
+	m.accrualDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// ObserveQueue records the orders waiting for accrual.
+func (m *Metrics) ObserveQueue(orders []repo.Order, now time.Time) {
+	m.queueDepth.Set(float64(len(orders)))
+
+	oldest := make(map[repo.OrderStatus]time.Time)
+	for _, o := range orders {
+		if t, ok := oldest[o.Status]; !ok || o.UploadedAt.Before(t) {
+			oldest[o.Status] = o.UploadedAt
+		}
+	}
+	m.orderAge.Reset()
+	for status, t := range oldest {
+		m.orderAge.WithLabelValues(string(status)).Set(now.Sub(t).Seconds())
+	}
+}
+
+// ObserveRepository records the latency of a repository call. It
+// matches the observer expected by the instrumented repository.
+func (m *Metrics) ObserveRepository(method string, d time.Duration) {
+	m.repoDuration.WithLabelValues(method).Observe(d.Seconds())
+}
+
+func (m *Metrics) AddAccrued(points float64) {
+	if points > 0 {
+		m.pointsAccrued.Add(points)
+	}
+}
+
+func (m *Metrics) AddWithdrawn(points float64) {
+	if points > 0 {
+		m.pointsWithdrawn.Add(points)
+	}
+}
+
+// AddRateLimitError counts a request let through because the rate
+// limit store failed.
+func (m *Metrics) AddRateLimitError(policy string) {
+	m.rateLimitErrors.WithLabelValues(policy).Inc()
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Names(t *testing.T) {
+	m := New()
+	m.ObserveHTTP("GET", "/api/user/balance", http.StatusOK, time.Millisecond)
+	m.ObserveAccrual(http.StatusOK, nil, time.Millisecond)
+	m.ObserveQueue([]repo.Order{{Status: repo.NEW}}, time.Now())
+	m.ObserveRepository("UserGet", time.Millisecond)
+	m.AddAccrued(1)
+	m.AddWithdrawn(1)
+	m.AddRateLimitError("auth")
+
+	families, err := m.registry.Gather()
+	require.NoError(t, err)
+	var names []string
+	for _, f := range families {
+		if strings.HasPrefix(f.GetName(), namespace+"_") {
+			names = append(names, f.GetName())
+		}
+	}
+	// renaming any of these breaks dashboards; update the package
+	// documentation together with this list
+	assert.ElementsMatch(t, []string{
+		"gophermart_http_requests_total",
+		"gophermart_http_request_duration_seconds",
+		"gophermart_accrual_requests_total",
+		"gophermart_accrual_request_duration_seconds",
+		"gophermart_worker_queue_depth",
+		"gophermart_order_age_seconds",
+		"gophermart_repository_duration_seconds",
+		"gophermart_points_accrued_total",
+		"gophermart_points_withdrawn_total",
+		"gophermart_rate_limit_errors_total",
+	}, names)
+}
+
+func TestMetrics_ObserveAccrual(t *testing.T) {
+	m := New()
+	for _, status := range []int{200, 204, 429, 500, 503, 404} {
+		m.ObserveAccrual(status, nil, time.Millisecond)
+	}
+	m.ObserveAccrual(0, errors.New("connection refused"), time.Millisecond)
+
+	for outcome, want := range map[string]float64{
+		OutcomeOK:          1,
+		OutcomeNoContent:   1,
+		OutcomeRateLimited: 1,
+		OutcomeServerError: 2,
+		OutcomeOther:       1,
+		OutcomeError:       1,
+	} {
+		assert.Equal(t, want, testutil.ToFloat64(m.accrualRequests.WithLabelValues(outcome)), outcome)
+	}
+}
+
+func TestMetrics_ObserveQueue(t *testing.T) {
+	m := New()
+	now := time.Now()
+	m.ObserveQueue([]repo.Order{
+		{Status: repo.NEW, UploadedAt: now.Add(-time.Minute)},
+		{Status: repo.NEW, UploadedAt: now.Add(-time.Hour)},
+		{Status: repo.PROCESSING, UploadedAt: now.Add(-time.Second)},
+	}, now)
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.queueDepth))
+	assert.Equal(t, time.Hour.Seconds(), testutil.ToFloat64(m.orderAge.WithLabelValues(string(repo.NEW))))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.orderAge.WithLabelValues(string(repo.PROCESSING))))
+
+	m.ObserveQueue(nil, now)
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.queueDepth))
+	assert.Zero(t, testutil.CollectAndCount(m.orderAge), "drained statuses are dropped")
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.AddWithdrawn(12.5)
+	m.AddWithdrawn(-1)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(t, w.Body.String(), "gophermart_points_withdrawn_total 12.5\n")
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
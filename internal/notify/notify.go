@@ -0,0 +1,73 @@
+// Package notify delivers messages to users. The default notifiers
+// need no external service: they write to the log or to a file.
+package notify
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+type Notifier interface {
+	Notify(Message) error
+}
+
+// New returns a FileNotifier writing to path, or a LogNotifier if
+// path is empty.
+func New(path string) Notifier {
+	if path == "" {
+		return LogNotifier{}
+	}
+	return &FileNotifier{Path: path}
+}
+
+// LogNotifier writes messages to the application log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(m Message) error {
+	log.Info().
+		Str("to", m.To).
+		Str("subject", m.Subject).
+		Str("body", m.Body).
+		Msg("notify")
+	return nil
+}
+
+// FileNotifier appends messages to Path, one JSON document per line.
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Notify(m Message) error {
+	if m.SentAt.IsZero() {
+		m.SentAt = time.Now()
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
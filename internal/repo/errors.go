@@ -0,0 +1,52 @@
+package repo
+
+import "errors"
+
+// Kind classifies an error so that callers can react to a whole class
+// of failures instead of matching every sentinel value.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindConflict
+	KindInsufficientFunds
+	KindValidation
+	KindUnprocessable
+	KindUnauthorized
+	KindForbidden
+	KindTooManyRequests
+	KindUnavailable
+)
+
+// Error is a classified error carrying a stable machine readable code.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+}
+
+func NewError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// AsError returns the first classified error in err's chain.
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// KindOf returns the Kind of err, KindInternal for unclassified errors.
+func KindOf(err error) Kind {
+	if e, ok := AsError(err); ok {
+		return e.Kind
+	}
+	return KindInternal
+}
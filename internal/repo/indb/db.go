@@ -3,7 +3,13 @@ package indb
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgconn"
@@ -15,8 +21,14 @@ import (
 
 var _ repo.Repository = &dbRepo{}
 
+// ratePurgeInterval is how often expired rate limit windows are purged.
+const ratePurgeInterval = time.Minute
+
 type dbRepo struct {
 	db *sql.DB
+
+	purgeMu       sync.Mutex
+	nextRatePurge time.Time
 }
 
 func NewDB(dsn string) *dbRepo {
@@ -33,14 +45,22 @@ func NewDB(dsn string) *dbRepo {
 	}
 	log.Debug().Msg("create schema if not already exists")
 	if err := createTables(ctx, db); err != nil {
-		log.Fatal().AnErr("db.PingContext", err).Msg("NewDB")
+		log.Fatal().AnErr("createTables", err).Msg("NewDB")
 		os.Exit(1)
 	}
 	return &dbRepo{db: db}
 }
 
 func createTables(ctx context.Context, db *sql.DB) error {
-	_, err := db.ExecContext(ctx,
+	stored, err := storedSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(stored); err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
 		`CREATE TABLE IF NOT EXISTS "users" (
 		"id" BIGSERIAL PRIMARY KEY,
 		"username" varchar,
@@ -114,187 +134,1467 @@ func createTables(ctx context.Context, db *sql.DB) error {
 
 	}
 
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS "tier" varchar NOT NULL DEFAULT 'BASIC';`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS "bonus" float NOT NULL DEFAULT 0;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS "request_id" varchar NOT NULL DEFAULT '';`)
+	if err != nil {
+		return err
+	}
+
+	// orders stored before the column existed had their accrual credited
+	// as soon as it was reported
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS "credited" float;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`UPDATE orders SET credited = CASE WHEN type = $1 THEN value + bonus ELSE 0 END
+		WHERE credited IS NULL;`,
+		repo.CREDIT)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE orders
+		ALTER COLUMN "credited" SET DEFAULT 0,
+		ALTER COLUMN "credited" SET NOT NULL;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "transfers" (
+			"id" BIGSERIAL PRIMARY KEY,
+			"from_user_id" bigint REFERENCES "users" ("id"),
+			"to_user_id" bigint REFERENCES "users" ("id"),
+			"value" float,
+			"memo" varchar,
+			"created_at" timestamp
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "adjustments" (
+			"id" BIGSERIAL PRIMARY KEY,
+			"user_id" bigint REFERENCES "users" ("id"),
+			"value" float,
+			"reason" varchar,
+			"created_at" timestamp
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS "roles" varchar NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS "locked" boolean NOT NULL DEFAULT false;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS "deleted" boolean NOT NULL DEFAULT false;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "audit" (
+			"id" BIGSERIAL PRIMARY KEY,
+			"actor_id" bigint REFERENCES "users" ("id"),
+			"action" varchar,
+			"target" varchar,
+			"details" varchar,
+			"created_at" timestamp
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "sessions" (
+			"id" BIGSERIAL PRIMARY KEY,
+			"user_id" bigint REFERENCES "users" ("id"),
+			"user_agent" varchar,
+			"ip" varchar,
+			"created_at" timestamp,
+			"last_used_at" timestamp,
+			"expires_at" timestamp,
+			"revoked" boolean NOT NULL DEFAULT false
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "refresh_tokens" (
+			"hash" varchar PRIMARY KEY,
+			"session_id" bigint REFERENCES "sessions" ("id"),
+			"used" boolean NOT NULL DEFAULT false,
+			"expires_at" timestamp
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "password_resets" (
+			"hash" varchar PRIMARY KEY,
+			"user_id" bigint REFERENCES "users" ("id"),
+			"used" boolean NOT NULL DEFAULT false,
+			"expires_at" timestamp
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "two_factor" (
+			"user_id" bigint PRIMARY KEY REFERENCES "users" ("id"),
+			"secret" varchar NOT NULL,
+			"enabled" boolean NOT NULL DEFAULT false,
+			"last_step" bigint NOT NULL DEFAULT 0,
+			"recovery_codes" varchar NOT NULL DEFAULT ''
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "login_challenges" (
+			"hash" varchar PRIMARY KEY,
+			"user_id" bigint REFERENCES "users" ("id"),
+			"used" boolean NOT NULL DEFAULT false,
+			"expires_at" timestamp
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "login_attempts" (
+			"key" varchar PRIMARY KEY,
+			"failures" int NOT NULL DEFAULT 0,
+			"last_failure" timestamp NOT NULL,
+			"locked_until" timestamp NOT NULL DEFAULT 'epoch'
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "rate_limits" (
+			"key" varchar PRIMARY KEY,
+			"count" int NOT NULL,
+			"reset_at" timestamp NOT NULL
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	// a single row; never lowered, so that a build started concurrently
+	// with a newer one cannot undo its bump
+	_, err = db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS "schema_version" (
+			"id" boolean PRIMARY KEY DEFAULT true CHECK (id),
+			"version" int NOT NULL
+		  );
+		  `)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO schema_version(version) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE
+		SET version = GREATEST(schema_version.version, EXCLUDED.version);`,
+		repo.SchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// storedSchemaVersion returns the schema version recorded in db, or 0
+// when the database has not been migrated yet.
+func storedSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, `SELECT version FROM schema_version`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// checkSchemaVersion refuses to migrate a schema made by a newer build,
+// which this build would not understand.
+func checkSchemaVersion(stored int) error {
+	if stored > repo.SchemaVersion {
+		return fmt.Errorf("schema version %d is newer than %d supported by this build", stored, repo.SchemaVersion)
+	}
 	return nil
 }
 
-func checkUserExists(db *sql.DB, u *repo.User) bool {
+func joinRoles(roles []string) string {
+	return strings.Join(roles, ",")
+}
+
+func splitRoles(roles string) []string {
+	if roles == "" {
+		return nil
+	}
+	return strings.Split(roles, ",")
+}
+
+func checkUserExists(ctx context.Context, db *sql.DB, u *repo.User) bool {
 	var count int
-	err := db.QueryRow("SELECT count(1) FROM users WHERE username=$1", u.Username).Scan(&count)
+	err := db.QueryRowContext(ctx, "SELECT count(1) FROM users WHERE username=$1", u.Username).Scan(&count)
 	if err != nil || count > 0 {
 		return true
 	}
 	return false
 }
 
-func (r *dbRepo) UserCreate(u *repo.User) (int64, error) {
-	if checkUserExists(r.db, u) {
+func (r *dbRepo) UserCreate(ctx context.Context, u *repo.User) (int64, error) {
+	if checkUserExists(ctx, r.db, u) {
 		return 0, repo.ErrAlreadyExists
 	}
 
 	var id int64
-	err := r.db.QueryRow(`
-	INSERT INTO users(username, password, balance, withdrawn, created_at) 
-	VALUES ($1, $2, $3, $4, $5) 
+	err := r.db.QueryRowContext(ctx, `
+	INSERT INTO users(username, password, balance, withdrawn, tier, created_at) 
+	VALUES ($1, $2, $3, $4, $5, $6) 
 	RETURNING id`,
-		u.Username, u.Password, 0, 0, time.Now()).
+		u.Username, u.Password, 0, 0, repo.BASIC, time.Now()).
 		Scan(&id)
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 	return id, nil
 }
-func (r *dbRepo) UserGet(username string) (*repo.User, error) {
+func (r *dbRepo) UserGet(ctx context.Context, username string) (*repo.User, error) {
+	var roles string
 	user := repo.User{}
-	err := r.db.QueryRow(`
-	SELECT id, username, password, balance, withdrawn FROM users
+	err := r.db.QueryRowContext(ctx, `
+	SELECT id, username, password, balance, withdrawn, tier, roles, locked, deleted, created_at FROM users
 	WHERE username=$1`,
 		username).
-		Scan(&user.ID, &user.Username, &user.Password, &user.Balance, &user.Withdrawal)
+		Scan(&user.ID, &user.Username, &user.Password, &user.Balance, &user.Withdrawal, &user.Tier, &roles, &user.Locked,
+			&user.Deleted, &user.CreatedAt)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
+	user.Roles = splitRoles(roles)
 	return &user, nil
 }
 
-func (r *dbRepo) UserUpdate(u *repo.User) error {
-	_, err := r.db.Exec(`
+func (r *dbRepo) UserUpdate(ctx context.Context, u *repo.User) error {
+	_, err := r.db.ExecContext(ctx, `
 		UPDATE users SET balance = $2, withdrawn = $3
 		WHERE id=$1`,
 		u.ID, u.Balance, u.Withdrawal)
 	if err != nil {
-		return err
+		return dbError(err)
 	}
 	return nil
 }
 
-func (r *dbRepo) UserGetByID(id int64) (*repo.User, error) {
+func (r *dbRepo) UserGetByID(ctx context.Context, id int64) (*repo.User, error) {
+	var roles string
 	user := repo.User{}
-	err := r.db.QueryRow(`
-	SELECT id, username, password, balance, withdrawn FROM users
+	err := r.db.QueryRowContext(ctx, `
+	SELECT id, username, password, balance, withdrawn, tier, roles, locked, deleted, created_at FROM users
 	WHERE id=$1`,
 		id).
-		Scan(&user.ID, &user.Username, &user.Password, &user.Balance, &user.Withdrawal)
+		Scan(&user.ID, &user.Username, &user.Password, &user.Balance, &user.Withdrawal, &user.Tier, &roles, &user.Locked,
+			&user.Deleted, &user.CreatedAt)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
+	user.Roles = splitRoles(roles)
 	return &user, nil
 }
-func (r *dbRepo) UserDelete(string) error { return nil }
 
-func (r *dbRepo) OrderCreate(o *repo.Order) (int64, error) {
+// UserDelete anonymises the user keeping the row so that orders,
+// withdrawals and transfers stay accounted for. Sessions, pending
+// password resets, two-factor enrolment and login challenges are
+// dropped.
+func (r *dbRepo) UserDelete(ctx context.Context, username string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
 	var id int64
-	err := r.db.QueryRow(`
-	INSERT INTO orders(number, type, user_id, value, status, uploaded_at) 
-	VALUES ($1, $2, $3, $4, $5, $6) 
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users SET
+			username = 'deleted-' || id || '-' || substr(md5(random()::text), 1, 8),
+			password = '', roles = '', locked = true, deleted = true
+		WHERE username=$1 AND NOT deleted
+		RETURNING id`,
+		username).
+		Scan(&id)
+	if err != nil {
+		return dbError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sessions SET revoked = true, user_agent = '', ip = ''
+		WHERE user_id=$1`,
+		id)
+	if err != nil {
+		return dbError(err)
+	}
+
+	for _, table := range []string{"password_resets", "two_factor", "login_challenges"} {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id=$1`, id)
+		if err != nil {
+			return dbError(err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *dbRepo) UserAccruals(ctx context.Context, since time.Time) (map[int64]float64, error) {
+	accruals := make(map[int64]float64)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.id, coalesce(sum(o.value), 0)
+		FROM users u
+		LEFT JOIN orders o ON o.user_id = u.id
+			AND o.type = $1
+			AND o.status = $2
+			AND o.uploaded_at >= $3
+		GROUP BY u.id`,
+		repo.CREDIT, repo.PROCESSED, since)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int64
+			value float64
+		)
+		err := rows.Scan(&id, &value)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		accruals[id] = value
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return accruals, nil
+}
+
+func (r *dbRepo) UserTierUpdate(ctx context.Context, id int64, tier repo.UserTier) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE users SET tier = $2
+		WHERE id=$1`,
+		id, tier)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) OrderCreate(ctx context.Context, o *repo.Order) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+	INSERT INTO orders(number, type, user_id, value, bonus, status, uploaded_at, request_id) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
+	RETURNING id`,
+		o.Order, string(o.Type), o.UserID, o.Value, o.Bonus, string(o.Status), o.UploadedAt, o.RequestID).
+		Scan(&id)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	return id, nil
+}
+
+// OrderWithdraw records the debit order and takes its value from the
+// balance of the user in one transaction.
+func (r *dbRepo) OrderWithdraw(ctx context.Context, o *repo.Order) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO orders(number, type, user_id, value, bonus, status, uploaded_at, request_id) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
 	RETURNING id`,
-		o.Order, string(o.Type), o.UserID, o.Value, string(o.Status), o.UploadedAt).
+		o.Order, string(o.Type), o.UserID, o.Value, o.Bonus, string(o.Status), o.UploadedAt, o.RequestID).
 		Scan(&id)
 	if err != nil {
+		return 0, dbError(err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET balance = balance - $2, withdrawn = withdrawn + $2
+		WHERE id=$1 AND balance >= $2`,
+		o.UserID, o.Value)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	if err = checkAffected(res); err != nil {
+		if errors.Is(err, repo.ErrNotExists) {
+			return 0, repo.ErrInsufficientFunds
+		}
 		return 0, err
 	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, dbError(err)
+	}
+	o.ID = id
 	return id, nil
 }
 
-func (r *dbRepo) OrderGet(number string) (*repo.Order, error) {
+func (r *dbRepo) OrderGet(ctx context.Context, number string) (*repo.Order, error) {
 	order := repo.Order{}
-	err := r.db.QueryRow(`
-		SELECT id, number, type, user_id, value, status, uploaded_at
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, number, type, user_id, value, bonus, status, uploaded_at
 		FROM orders
 		WHERE number=$1`,
 		number).
 		Scan(&order.ID, &order.Order, &order.Type,
-			&order.UserID, &order.Value, &order.Status,
+			&order.UserID, &order.Value, &order.Bonus, &order.Status,
 			&order.UploadedAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, repo.ErrNotExists
-		}
-		return nil, err
+		return nil, dbError(err)
 	}
 	return &order, nil
 }
-func (r *dbRepo) OrderGetList(uid int64, t repo.OrderType) ([]repo.Order, error) {
+func (r *dbRepo) OrderGetList(ctx context.Context, uid int64, t repo.OrderType) ([]repo.Order, error) {
 	orders := make([]repo.Order, 0)
-	rows, err := r.db.Query(`
-		SELECT id, number, type, value, status, uploaded_at
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, number, type, value, bonus, credited, status, uploaded_at
 		FROM orders
 		WHERE user_id=$1 and type= $2`,
 		uid, t)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		order := repo.Order{}
-		err := rows.Scan(&order.ID, &order.Order, &order.Type, &order.Value, &order.Status, &order.UploadedAt)
+		err := rows.Scan(&order.ID, &order.Order, &order.Type, &order.Value, &order.Bonus, &order.Credited, &order.Status, &order.UploadedAt)
 		if err != nil {
-			return nil, err
+			return nil, dbError(err)
 		}
 		orders = append(orders, order)
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 
 	return orders, nil
 }
-func (r *dbRepo) OrderDelete(string) error { return nil }
+func (r *dbRepo) OrderDelete(context.Context, string) error { return nil }
 
-func (r *dbRepo) OrderToProcess() ([]string, error) {
-	orders := make([]string, 0)
-	rows, err := r.db.Query(`
-		SELECT number 
+func (r *dbRepo) OrderToProcess(ctx context.Context) ([]repo.Order, error) {
+	orders := make([]repo.Order, 0)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT number, request_id, value, bonus, status, uploaded_at
 		FROM orders o 
 		WHERE o.status NOT IN ('PROCESSED', 'INVALID', '');`)
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var number string
-		err := rows.Scan(&number)
+		var order repo.Order
+		err := rows.Scan(&order.Order, &order.RequestID,
+			&order.Value, &order.Bonus, &order.Status, &order.UploadedAt)
 		if err != nil {
-			return nil, err
+			return nil, dbError(err)
 		}
-		orders = append(orders, number)
+		orders = append(orders, order)
 	}
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 
 	return orders, nil
 }
 
-func (r *dbRepo) OrderUpdate(number string, status repo.OrderStatus, accrual float64) error {
-	order, err := r.OrderGet(number)
+// OrderUpdate stores the accrual result and, once the order is
+// PROCESSED, credits the owner with the difference from what was
+// credited before, which it returns. The order row stays locked until
+// the credit is committed, so concurrent updates of one order never
+// credit the same difference twice.
+func (r *dbRepo) OrderUpdate(ctx context.Context, number string, status repo.OrderStatus, accrual, bonus float64) (float64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+
+	var (
+		id, uid  int64
+		credited float64
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, credited
+		FROM orders
+		WHERE number=$1
+		FOR UPDATE`,
+		number).
+		Scan(&id, &uid, &credited)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	// credit only the difference so that re-polled orders are not paid
+	// twice; amounts reported before processing completes stay pending
+	delta := 0.0
+	if status == repo.PROCESSED {
+		delta = accrual + bonus - credited
+		credited = accrual + bonus
 	}
-	order.Value = accrual
-	order.Status = status
-	_, err = r.db.Exec(`
-		UPDATE orders SET value = $2, status = $3
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET value = $2, bonus = $3, status = $4, credited = $5
 		WHERE id=$1`,
-		order.ID, order.Value, order.Status)
+		id, accrual, bonus, status, credited)
 	if err != nil {
-		return err
+		return 0, dbError(err)
 	}
 
-	user, err := r.UserGetByID(order.UserID)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET balance = balance + $2
+		WHERE id=$1`,
+		uid, delta)
 	if err != nil {
-		return err
+		return 0, dbError(err)
 	}
 
-	user.Balance += accrual
+	if err = tx.Commit(); err != nil {
+		return 0, dbError(err)
+	}
+	return delta, nil
+}
 
-	err = r.UserUpdate(user)
+// OrderPending counts the credit orders still awaiting accrual and sums
+// the amount reported for them that is not credited yet.
+func (r *dbRepo) OrderPending(ctx context.Context, uid int64) (int64, float64, error) {
+	var (
+		count int64
+		value float64
+	)
+	err := r.db.QueryRowContext(ctx, `
+		SELECT count(1), coalesce(sum(greatest(value + bonus - credited, 0)), 0)
+		FROM orders
+		WHERE user_id=$1 AND type=$2
+		AND status NOT IN ('PROCESSED', 'INVALID', '');`,
+		uid, repo.CREDIT).
+		Scan(&count, &value)
 	if err != nil {
-		return err
+		return 0, 0, dbError(err)
 	}
+	return count, value, nil
+}
 
-	return nil
+// TransferCreate moves the value between the users in one transaction.
+// A positive daily limit bounds the total the sender moved over the
+// 24 hours before the transfer, checked once the sender is locked.
+func (r *dbRepo) TransferCreate(ctx context.Context, t *repo.Transfer, daily float64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	defer tx.Rollback()
+
+	// lock both accounts in a stable order to avoid deadlocks
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, username, balance FROM users
+		WHERE id IN ($1, $2)
+		ORDER BY id
+		FOR UPDATE`,
+		t.FromUserID, t.ToUserID)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	users := make(map[int64]repo.User)
+	for rows.Next() {
+		user := repo.User{}
+		err := rows.Scan(&user.ID, &user.Username, &user.Balance)
+		if err != nil {
+			rows.Close()
+			return 0, dbError(err)
+		}
+		users[user.ID] = user
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, dbError(err)
+	}
+
+	from, ok := users[t.FromUserID]
+	if !ok {
+		return 0, repo.ErrNotExists
+	}
+	to, ok := users[t.ToUserID]
+	if !ok {
+		return 0, repo.ErrNotExists
+	}
+	if from.Balance < t.Value {
+		return 0, repo.ErrInsufficientFunds
+	}
+	if daily > 0 {
+		var sent float64
+		err = tx.QueryRowContext(ctx, `
+			SELECT coalesce(sum(value), 0)
+			FROM transfers
+			WHERE from_user_id=$1 AND created_at >= $2`,
+			from.ID, t.CreatedAt.Add(-24*time.Hour)).
+			Scan(&sent)
+		if err != nil {
+			return 0, dbError(err)
+		}
+		if sent+t.Value > daily {
+			return 0, repo.ErrTransferLimit
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET balance = balance - $2 WHERE id=$1`, from.ID, t.Value)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET balance = balance + $2 WHERE id=$1`, to.ID, t.Value)
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO transfers(from_user_id, to_user_id, value, memo, created_at) 
+	VALUES ($1, $2, $3, $4, $5) 
+	RETURNING id`,
+		from.ID, to.ID, t.Value, t.Memo, t.CreatedAt).
+		Scan(&id)
+	if err != nil {
+		return 0, dbError(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, dbError(err)
+	}
+
+	t.ID = id
+	t.From = from.Username
+	t.To = to.Username
+	return id, nil
+}
+
+func (r *dbRepo) TransferGetList(ctx context.Context, uid int64) ([]repo.Transfer, error) {
+	transfers := make([]repo.Transfer, 0)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT t.id, t.from_user_id, t.to_user_id, f.username, d.username,
+			t.value, t.memo, t.created_at
+		FROM transfers t
+		JOIN users f ON f.id = t.from_user_id
+		JOIN users d ON d.id = t.to_user_id
+		WHERE t.from_user_id=$1 OR t.to_user_id=$1
+		ORDER BY t.created_at`,
+		uid)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := repo.Transfer{}
+		err := rows.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.From, &t.To,
+			&t.Value, &t.Memo, &t.CreatedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		transfers = append(transfers, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return transfers, nil
+}
+
+func (r *dbRepo) AdjustmentGetList(ctx context.Context, uid int64) ([]repo.Adjustment, error) {
+	adjustments := make([]repo.Adjustment, 0)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, value, reason, created_at
+		FROM adjustments
+		WHERE user_id=$1
+		ORDER BY created_at`,
+		uid)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		adj := repo.Adjustment{}
+		err := rows.Scan(&adj.ID, &adj.UserID, &adj.Value, &adj.Reason, &adj.CreatedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		adjustments = append(adjustments, adj)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return adjustments, nil
+}
+
+// likeEscaper makes a search query match literally inside a LIKE
+// pattern with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *dbRepo) UserSearch(ctx context.Context, query string, limit, offset int) ([]repo.User, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	users := make([]repo.User, 0)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, username, balance, withdrawn, tier, roles, locked, created_at
+		FROM users
+		WHERE username LIKE '%' || $1 || '%' ESCAPE '\'
+		ORDER BY id
+		LIMIT $2 OFFSET $3`,
+		likeEscaper.Replace(query), limit, offset)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roles string
+		user := repo.User{}
+		err := rows.Scan(&user.ID, &user.Username, &user.Balance, &user.Withdrawal,
+			&user.Tier, &roles, &user.Locked, &user.CreatedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		user.Roles = splitRoles(roles)
+		users = append(users, user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return users, nil
+}
+
+func (r *dbRepo) UserRolesUpdate(ctx context.Context, id int64, roles []string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE users SET roles = $2
+		WHERE id=$1`,
+		id, joinRoles(roles))
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) UserLock(ctx context.Context, id int64, locked bool, a *repo.Audit) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET locked = $2
+		WHERE id=$1`,
+		id, locked)
+	if err != nil {
+		return dbError(err)
+	}
+	if err = checkAffected(res); err != nil {
+		return err
+	}
+	if err = auditInsert(ctx, tx, a); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) UserPasswordUpdate(ctx context.Context, id int64, hash string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE users SET password = $2
+		WHERE id=$1`,
+		id, hash)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+// UserAdjust changes the balance and records the adjustment for the
+// balance history in one transaction.
+func (r *dbRepo) UserAdjust(ctx context.Context, adj *repo.Adjustment, a *repo.Audit) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	var balance float64
+	err = tx.QueryRowContext(ctx, `SELECT balance FROM users WHERE id=$1 FOR UPDATE`, adj.UserID).Scan(&balance)
+	if err != nil {
+		return dbError(err)
+	}
+	if balance+adj.Value < 0 {
+		return repo.ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET balance = balance + $2 WHERE id=$1`, adj.UserID, adj.Value)
+	if err != nil {
+		return dbError(err)
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO adjustments(user_id, value, reason, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		adj.UserID, adj.Value, adj.Reason, adj.CreatedAt).
+		Scan(&adj.ID)
+	if err != nil {
+		return dbError(err)
+	}
+	if err = auditInsert(ctx, tx, a); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) OrderSearch(ctx context.Context, f repo.OrderFilter) ([]repo.Order, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if f.Number != "" {
+		args = append(args, f.Number)
+		where = append(where, fmt.Sprintf("number=$%d", len(args)))
+	}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		where = append(where, fmt.Sprintf("status=$%d", len(args)))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("uploaded_at>=$%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("uploaded_at<$%d", len(args)))
+	}
+	query := `SELECT id, number, type, user_id, value, bonus, status, uploaded_at FROM orders`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit, f.Offset)
+	query += fmt.Sprintf(" ORDER BY uploaded_at LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	orders := make([]repo.Order, 0)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order := repo.Order{}
+		err := rows.Scan(&order.ID, &order.Order, &order.Type, &order.UserID,
+			&order.Value, &order.Bonus, &order.Status, &order.UploadedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		orders = append(orders, order)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return orders, nil
+}
+
+func (r *dbRepo) OrderRepoll(ctx context.Context, number string, a *repo.Audit) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE orders SET status = $2
+		WHERE number=$1 AND type=$3`,
+		number, repo.NEW, repo.CREDIT)
+	if err != nil {
+		return dbError(err)
+	}
+	if err = checkAffected(res); err != nil {
+		return err
+	}
+	if err = auditInsert(ctx, tx, a); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) AuditCreate(ctx context.Context, a *repo.Audit) (int64, error) {
+	if err := auditInsert(ctx, r.db, a); err != nil {
+		return 0, err
+	}
+	return a.ID, nil
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// auditInsert records a through q; given a transaction, the record
+// commits or rolls back together with the change it describes. Nil a
+// records nothing.
+func auditInsert(ctx context.Context, q rowQueryer, a *repo.Audit) error {
+	if a == nil {
+		return nil
+	}
+	err := q.QueryRowContext(ctx, `
+	INSERT INTO audit(actor_id, action, target, details, created_at) 
+	VALUES ($1, $2, $3, $4, $5) 
+	RETURNING id`,
+		a.ActorID, a.Action, a.Target, a.Details, a.CreatedAt).
+		Scan(&a.ID)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) AuditGetList(ctx context.Context, limit, offset int) ([]repo.Audit, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	audit := make([]repo.Audit, 0)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, actor_id, action, target, details, created_at
+		FROM audit
+		ORDER BY id
+		LIMIT $1 OFFSET $2`,
+		limit, offset)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := repo.Audit{}
+		err := rows.Scan(&a.ID, &a.ActorID, &a.Action, &a.Target, &a.Details, &a.CreatedAt)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		audit = append(audit, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return audit, nil
+}
+
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+	if n == 0 {
+		return repo.ErrNotExists
+	}
+	return nil
+}
+
+func (r *dbRepo) SessionCreate(ctx context.Context, s *repo.Session) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+	INSERT INTO sessions(user_id, user_agent, ip, created_at, last_used_at, expires_at) 
+	VALUES ($1, $2, $3, $4, $5, $6) 
+	RETURNING id`,
+		s.UserID, s.UserAgent, s.IP, s.CreatedAt, s.LastUsedAt, s.ExpiresAt).
+		Scan(&id)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	return id, nil
+}
+
+func (r *dbRepo) SessionGet(ctx context.Context, id int64) (*repo.Session, error) {
+	s := repo.Session{}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked
+		FROM sessions
+		WHERE id=$1`,
+		id).
+		Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.Revoked)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &s, nil
+}
+
+func (r *dbRepo) SessionGetList(ctx context.Context, uid int64) ([]repo.Session, error) {
+	sessions := make([]repo.Session, 0)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked
+		FROM sessions
+		WHERE user_id=$1 AND NOT revoked
+		ORDER BY id`,
+		uid)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := repo.Session{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.Revoked)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		sessions = append(sessions, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return sessions, nil
+}
+
+func (r *dbRepo) SessionTouch(ctx context.Context, id int64, lastUsed, expires time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE sessions SET last_used_at = $2, expires_at = $3
+		WHERE id=$1`,
+		id, lastUsed, expires)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) SessionRevoke(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked = true
+		WHERE id=$1`,
+		id)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) SessionRevokeAll(ctx context.Context, uid, except int64) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked = true
+		WHERE user_id=$1 AND id<>$2`,
+		uid, except)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) RefreshTokenCreate(ctx context.Context, t *repo.RefreshToken) error {
+	_, err := r.db.ExecContext(ctx, `
+	INSERT INTO refresh_tokens(hash, session_id, used, expires_at) 
+	VALUES ($1, $2, $3, $4)`,
+		t.Hash, t.SessionID, t.Used, t.ExpiresAt)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) RefreshTokenGet(ctx context.Context, hash string) (*repo.RefreshToken, error) {
+	t := repo.RefreshToken{}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT hash, session_id, used, expires_at
+		FROM refresh_tokens
+		WHERE hash=$1`,
+		hash).
+		Scan(&t.Hash, &t.SessionID, &t.Used, &t.ExpiresAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &t, nil
+}
+
+func (r *dbRepo) RefreshTokenUse(ctx context.Context, hash string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET used = true
+		WHERE hash=$1 AND NOT used`,
+		hash)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) PasswordResetCreate(ctx context.Context, t *repo.PasswordReset) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO password_resets(hash, user_id, used, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		t.Hash, t.UserID, t.Used, t.ExpiresAt)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) PasswordResetGet(ctx context.Context, hash string) (*repo.PasswordReset, error) {
+	t := repo.PasswordReset{}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT hash, user_id, used, expires_at
+		FROM password_resets
+		WHERE hash=$1`,
+		hash).
+		Scan(&t.Hash, &t.UserID, &t.Used, &t.ExpiresAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &t, nil
+}
+
+func (r *dbRepo) PasswordResetUse(ctx context.Context, hash string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE password_resets SET used = true
+		WHERE hash=$1 AND NOT used`,
+		hash)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) TwoFactorGet(ctx context.Context, uid int64) (*repo.TwoFactor, error) {
+	t := repo.TwoFactor{}
+	var codes string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT user_id, secret, enabled, last_step, recovery_codes
+		FROM two_factor
+		WHERE user_id=$1`,
+		uid).
+		Scan(&t.UserID, &t.Secret, &t.Enabled, &t.LastStep, &codes)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	t.RecoveryCodes = splitRoles(codes)
+	return &t, nil
+}
+
+func (r *dbRepo) TwoFactorSave(ctx context.Context, t *repo.TwoFactor) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO two_factor(user_id, secret, enabled, last_step, recovery_codes)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret = $2, enabled = $3, last_step = $4, recovery_codes = $5`,
+		t.UserID, t.Secret, t.Enabled, t.LastStep, joinRoles(t.RecoveryCodes))
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) TwoFactorDelete(ctx context.Context, uid int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM two_factor WHERE user_id=$1`, uid)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+// TwoFactorUseStep records step as used. The comparison happens in the
+// update so that concurrent requests cannot replay the same code.
+func (r *dbRepo) TwoFactorUseStep(ctx context.Context, uid, step int64) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE two_factor SET last_step = $2
+		WHERE user_id=$1 AND last_step < $2`,
+		uid, step)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) TwoFactorUseRecoveryCode(ctx context.Context, uid int64, hash string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE two_factor SET recovery_codes = array_to_string(
+			array_remove(string_to_array(recovery_codes, ','), $2), ',')
+		WHERE user_id=$1 AND $2 = ANY(string_to_array(recovery_codes, ','))`,
+		uid, hash)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) LoginChallengeCreate(ctx context.Context, c *repo.LoginChallenge) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO login_challenges(hash, user_id, used, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		c.Hash, c.UserID, c.Used, c.ExpiresAt)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+func (r *dbRepo) LoginChallengeGet(ctx context.Context, hash string) (*repo.LoginChallenge, error) {
+	c := repo.LoginChallenge{}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT hash, user_id, used, expires_at
+		FROM login_challenges
+		WHERE hash=$1`,
+		hash).
+		Scan(&c.Hash, &c.UserID, &c.Used, &c.ExpiresAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &c, nil
+}
+
+func (r *dbRepo) LoginChallengeUse(ctx context.Context, hash string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE login_challenges SET used = true
+		WHERE hash=$1 AND NOT used`,
+		hash)
+	if err != nil {
+		return dbError(err)
+	}
+	return checkAffected(res)
+}
+
+func (r *dbRepo) LoginAttemptGet(ctx context.Context, key string) (*repo.LoginAttempt, error) {
+	a := repo.LoginAttempt{}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT key, failures, last_failure, locked_until
+		FROM login_attempts
+		WHERE key=$1`,
+		key).
+		Scan(&a.Key, &a.Failures, &a.LastFailure, &a.LockedUntil)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &a, nil
+}
+
+// LoginAttemptFail counts a failure of key within window and, once a
+// positive max is reached, locks the key for lockout. Both happen in
+// one transaction so that replicas sharing the database observe the
+// same counters and locks.
+func (r *dbRepo) LoginAttemptFail(ctx context.Context, key string, at time.Time, window time.Duration,
+	max int, lockout time.Duration) (*repo.LoginAttempt, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer tx.Rollback()
+
+	a := repo.LoginAttempt{}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO login_attempts(key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < $3
+				THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure = $2
+		RETURNING key, failures, last_failure, locked_until`,
+		key, at, at.Add(-window)).
+		Scan(&a.Key, &a.Failures, &a.LastFailure, &a.LockedUntil)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	if max > 0 && a.Failures >= max {
+		a.LockedUntil = at.Add(lockout)
+		_, err = tx.ExecContext(ctx, `
+			UPDATE login_attempts SET locked_until = $2
+			WHERE key=$1`,
+			key, a.LockedUntil)
+		if err != nil {
+			return nil, dbError(err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, dbError(err)
+	}
+	return &a, nil
+}
+
+func (r *dbRepo) LoginAttemptReset(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key=$1`, key)
+	if err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+// RateLimitHit counts a request for key in its current window. Windows
+// that ended are restarted in place, and other expired windows are
+// purged every ratePurgeInterval to keep the table small.
+func (r *dbRepo) RateLimitHit(ctx context.Context, key string, at time.Time, window time.Duration) (int, time.Time, error) {
+	var (
+		count int
+		reset time.Time
+	)
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO rate_limits(key, count, reset_at)
+		VALUES ($1, 1, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN rate_limits.reset_at <= $2
+				THEN 1 ELSE rate_limits.count + 1 END,
+			reset_at = CASE WHEN rate_limits.reset_at <= $2
+				THEN $3 ELSE rate_limits.reset_at END
+		RETURNING count, reset_at`,
+		key, at, at.Add(window)).
+		Scan(&count, &reset)
+	if err != nil {
+		return 0, time.Time{}, dbError(err)
+	}
+
+	if r.ratePurgeDue(at) {
+		_, err = r.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE reset_at <= $1`, at)
+		if err != nil {
+			log.Error().AnErr("purge", err).Msg("RateLimitHit")
+		}
+	}
+	return count, reset, nil
+}
+
+// ratePurgeDue reports whether expired rate limit windows should be
+// purged at t, which happens at most once per ratePurgeInterval.
+func (r *dbRepo) ratePurgeDue(t time.Time) bool {
+	r.purgeMu.Lock()
+	defer r.purgeMu.Unlock()
+
+	if t.Before(r.nextRatePurge) {
+		return false
+	}
+	r.nextRatePurge = t.Add(ratePurgeInterval)
+	return true
+}
+
+func (r *dbRepo) Ping(ctx context.Context) error {
+	return dbError(r.db.PingContext(ctx))
+}
+
+func (r *dbRepo) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := r.db.QueryRowContext(ctx, `SELECT version FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	return version, nil
+}
+
+// dbError translates driver errors into the repo error taxonomy.
+func dbError(err error) error {
+	var (
+		pgErr  *pgconn.PgError
+		netErr net.Error
+	)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return repo.ErrNotExists
+	case errors.As(err, &pgErr):
+		switch {
+		case pgErr.Code == "23505":
+			return repo.ErrAlreadyExists
+		// connection exceptions, insufficient resources, server shutdown
+		case strings.HasPrefix(pgErr.Code, "08"),
+			strings.HasPrefix(pgErr.Code, "53"),
+			strings.HasPrefix(pgErr.Code, "57P"):
+			return fmt.Errorf("%w: %v", repo.ErrUnavailable, err)
+		}
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded),
+		pgconn.Timeout(err),
+		errors.As(err, &netErr):
+		return fmt.Errorf("%w: %v", repo.ErrUnavailable, err)
+	}
+	return err
 }
@@ -0,0 +1,32 @@
+package indb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+func Test_checkSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  int
+		wantErr bool
+	}{
+		{name: "new database", stored: 0},
+		{name: "current schema", stored: repo.SchemaVersion},
+		{name: "newer schema", stored: repo.SchemaVersion + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSchemaVersion(tt.stored)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
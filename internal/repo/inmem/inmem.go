@@ -1,24 +1,54 @@
 package inmem
 
 import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/andrei-cloud/gophermart/internal/repo"
 )
 
 type inMemRepo struct {
-	userDB      map[string]repo.User
-	orderDB     map[string]repo.Order
-	nextUserID  int64
-	nextOrderID int64
+	mu             sync.RWMutex
+	userDB         map[string]repo.User
+	orderDB        map[string]repo.Order
+	transferDB     []repo.Transfer
+	adjustmentDB   []repo.Adjustment
+	auditDB        []repo.Audit
+	sessionDB      []repo.Session
+	refreshDB      map[string]repo.RefreshToken
+	resetDB        map[string]repo.PasswordReset
+	twoFactorDB    map[int64]repo.TwoFactor
+	challengeDB    map[string]repo.LoginChallenge
+	attemptDB      map[string]repo.LoginAttempt
+	rateDB         map[string]rateWindow
+	nextUserID     int64
+	nextOrderID    int64
+	nextTransferID int64
 }
 
 func NewInMemRepo() *inMemRepo {
 	return &inMemRepo{
-		userDB:  make(map[string]repo.User),
-		orderDB: make(map[string]repo.Order),
+		userDB:      make(map[string]repo.User),
+		orderDB:     make(map[string]repo.Order),
+		refreshDB:   make(map[string]repo.RefreshToken),
+		resetDB:     make(map[string]repo.PasswordReset),
+		twoFactorDB: make(map[int64]repo.TwoFactor),
+		challengeDB: make(map[string]repo.LoginChallenge),
+		attemptDB:   make(map[string]repo.LoginAttempt),
+		rateDB:      make(map[string]rateWindow),
 	}
 }
 
-func (r *inMemRepo) UserCreate(u *repo.User) (int64, error) {
+func (r *inMemRepo) UserCreate(ctx context.Context, u *repo.User) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if _, ok := r.userDB[u.Username]; ok {
 		return 0, repo.ErrAlreadyExists
 	}
@@ -27,7 +57,10 @@ func (r *inMemRepo) UserCreate(u *repo.User) (int64, error) {
 	return u.ID, nil
 }
 
-func (r *inMemRepo) UserGet(name string) (*repo.User, error) {
+func (r *inMemRepo) UserGet(ctx context.Context, name string) (*repo.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var (
 		user repo.User
 		ok   bool
@@ -39,7 +72,14 @@ func (r *inMemRepo) UserGet(name string) (*repo.User, error) {
 	return &user, nil
 }
 
-func (r *inMemRepo) UserGetByID(id int64) (*repo.User, error) {
+func (r *inMemRepo) UserGetByID(ctx context.Context, id int64) (*repo.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.userByID(id)
+}
+
+func (r *inMemRepo) userByID(id int64) (*repo.User, error) {
 	for _, user := range r.userDB {
 		if user.ID == id {
 			return &user, nil
@@ -49,33 +89,144 @@ func (r *inMemRepo) UserGetByID(id int64) (*repo.User, error) {
 	return nil, repo.ErrNotExists
 }
 
-func (r *inMemRepo) UserDelete(name string) error {
-	var (
-		ok bool
-	)
-	if _, ok = r.userDB[name]; !ok {
+// UserDelete anonymises the user keeping its orders, withdrawals and
+// transfers. Sessions, pending password resets, two-factor enrolment
+// and login challenges are dropped.
+func (r *inMemRepo) UserDelete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.userDB[name]
+	if !ok || user.Deleted {
 		return repo.ErrNotExists
 	}
 
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
 	delete(r.userDB, name)
+	user.Username = fmt.Sprintf("deleted-%d-%x", user.ID, suffix)
+	user.Password = ""
+	user.Roles = nil
+	user.Locked = true
+	user.Deleted = true
+	r.userDB[user.Username] = user
+
+	for i, t := range r.transferDB {
+		if t.FromUserID == user.ID {
+			r.transferDB[i].From = user.Username
+		}
+		if t.ToUserID == user.ID {
+			r.transferDB[i].To = user.Username
+		}
+	}
+	for i, s := range r.sessionDB {
+		if s.UserID == user.ID {
+			r.sessionDB[i].Revoked = true
+			r.sessionDB[i].UserAgent = ""
+			r.sessionDB[i].IP = ""
+		}
+	}
+	for hash, t := range r.resetDB {
+		if t.UserID == user.ID {
+			delete(r.resetDB, hash)
+		}
+	}
+	delete(r.twoFactorDB, user.ID)
+	for hash, c := range r.challengeDB {
+		if c.UserID == user.ID {
+			delete(r.challengeDB, hash)
+		}
+	}
 	return nil
 }
 
-func (r *inMemRepo) UserUpdate(u *repo.User) error {
+func (r *inMemRepo) UserUpdate(ctx context.Context, u *repo.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.userDB[u.Username] = *u
 	return nil
 }
 
-func (r *inMemRepo) OrderCreate(o *repo.Order) (int64, error) {
+func (r *inMemRepo) UserAccruals(ctx context.Context, since time.Time) (map[int64]float64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accruals := make(map[int64]float64)
+	for _, user := range r.userDB {
+		accruals[user.ID] = 0
+	}
+	for _, order := range r.orderDB {
+		if order.Type != repo.CREDIT || order.Status != repo.PROCESSED {
+			continue
+		}
+		if order.UploadedAt.Before(since) {
+			continue
+		}
+		if _, ok := accruals[order.UserID]; ok {
+			accruals[order.UserID] += order.Value
+		}
+	}
+	return accruals, nil
+}
+
+func (r *inMemRepo) UserTierUpdate(ctx context.Context, id int64, tier repo.UserTier) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.userByID(id)
+	if err != nil {
+		return err
+	}
+	user.Tier = tier
+	r.userDB[user.Username] = *user
+	return nil
+}
+
+func (r *inMemRepo) OrderCreate(ctx context.Context, o *repo.Order) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.orderDB[o.Order]; ok {
+		return 0, repo.ErrAlreadyExists
+	}
+	o.ID = r.GetNextOrderID()
+	r.orderDB[o.Order] = *o
+	return o.ID, nil
+}
+
+// OrderWithdraw records the debit order and takes its value from the
+// balance of the user under one lock.
+func (r *inMemRepo) OrderWithdraw(ctx context.Context, o *repo.Order) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.userByID(o.UserID)
+	if err != nil {
+		return 0, err
+	}
 	if _, ok := r.orderDB[o.Order]; ok {
 		return 0, repo.ErrAlreadyExists
 	}
+	if user.Balance < o.Value {
+		return 0, repo.ErrInsufficientFunds
+	}
+
+	user.Balance -= o.Value
+	user.Withdrawal += o.Value
+	r.userDB[user.Username] = *user
+
 	o.ID = r.GetNextOrderID()
 	r.orderDB[o.Order] = *o
 	return o.ID, nil
 }
 
-func (r *inMemRepo) OrderGet(name string) (*repo.Order, error) {
+func (r *inMemRepo) OrderGet(ctx context.Context, name string) (*repo.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var (
 		order repo.Order
 		ok    bool
@@ -87,7 +238,10 @@ func (r *inMemRepo) OrderGet(name string) (*repo.Order, error) {
 	return &order, nil
 }
 
-func (r *inMemRepo) OrderGetList(uid int64, t repo.OrderType) ([]repo.Order, error) {
+func (r *inMemRepo) OrderGetList(ctx context.Context, uid int64, t repo.OrderType) ([]repo.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var (
 		orders []repo.Order
 	)
@@ -101,7 +255,10 @@ func (r *inMemRepo) OrderGetList(uid int64, t repo.OrderType) ([]repo.Order, err
 	return orders, nil
 }
 
-func (r *inMemRepo) OrderDelete(name string) error {
+func (r *inMemRepo) OrderDelete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	var (
 		ok bool
 	)
@@ -120,31 +277,616 @@ func (r *inMemRepo) GetNextUserID() int64 {
 
 func (r *inMemRepo) GetNextOrderID() int64 {
 	r.nextOrderID++
-	return r.nextUserID
+	return r.nextOrderID
 }
 
-func (r *inMemRepo) OrderToProcess() ([]string, error) {
-	orders := make([]string, 0)
+func (r *inMemRepo) OrderToProcess(ctx context.Context) ([]repo.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orders := make([]repo.Order, 0)
 	for _, order := range r.orderDB {
 		if order.Status != "PROCESSED" && order.Status != "INVALID" && order.Status != "" {
-			orders = append(orders, order.Order)
+			orders = append(orders, order)
 		}
 	}
 	return orders, nil
 }
 
-func (r *inMemRepo) OrderUpdate(number string, status repo.OrderStatus, accrual float64) error {
+func (r *inMemRepo) OrderUpdate(ctx context.Context, number string, status repo.OrderStatus, accrual, bonus float64) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	var (
 		ok    bool
 		order repo.Order
 	)
 	if order, ok = r.orderDB[number]; !ok {
-		return repo.ErrNotExists
+		return 0, repo.ErrNotExists
+	}
+
+	// credit only the difference so that re-polled orders are not paid
+	// twice; amounts reported before processing completes stay pending
+	delta := 0.0
+	if status == repo.PROCESSED {
+		delta = accrual + bonus - order.Credited
+		order.Credited = accrual + bonus
 	}
 
 	order.Status = status
 	order.Value = accrual
+	order.Bonus = bonus
 
 	r.orderDB[number] = order
+
+	user, err := r.userByID(order.UserID)
+	if err != nil {
+		return 0, err
+	}
+	user.Balance += delta
+	r.userDB[user.Username] = *user
+
+	return delta, nil
+}
+
+// OrderPending counts the credit orders still awaiting accrual and sums
+// the amount reported for them that is not credited yet.
+func (r *inMemRepo) OrderPending(ctx context.Context, uid int64) (int64, float64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		count int64
+		value float64
+	)
+	for _, order := range r.orderDB {
+		if order.UserID != uid || order.Type != repo.CREDIT {
+			continue
+		}
+		if order.Status != repo.PROCESSED && order.Status != repo.INVALID && order.Status != "" {
+			count++
+			value += math.Max(order.Value+order.Bonus-order.Credited, 0)
+		}
+	}
+	return count, value, nil
+}
+
+// TransferCreate moves the value between the users under one lock.
+// A positive daily limit bounds the total the sender moved over the
+// 24 hours before the transfer.
+func (r *inMemRepo) TransferCreate(ctx context.Context, t *repo.Transfer, daily float64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from, err := r.userByID(t.FromUserID)
+	if err != nil {
+		return 0, err
+	}
+	to, err := r.userByID(t.ToUserID)
+	if err != nil {
+		return 0, err
+	}
+	if from.Balance < t.Value {
+		return 0, repo.ErrInsufficientFunds
+	}
+	if daily > 0 && r.transferSum(from.ID, t.CreatedAt.Add(-24*time.Hour))+t.Value > daily {
+		return 0, repo.ErrTransferLimit
+	}
+
+	from.Balance -= t.Value
+	to.Balance += t.Value
+	r.userDB[from.Username] = *from
+	r.userDB[to.Username] = *to
+
+	r.nextTransferID++
+	t.ID = r.nextTransferID
+	t.From = from.Username
+	t.To = to.Username
+	r.transferDB = append(r.transferDB, *t)
+	return t.ID, nil
+}
+
+func (r *inMemRepo) TransferGetList(ctx context.Context, uid int64) ([]repo.Transfer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transfers := make([]repo.Transfer, 0)
+	for _, t := range r.transferDB {
+		if t.FromUserID == uid || t.ToUserID == uid {
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers, nil
+}
+
+func (r *inMemRepo) transferSum(uid int64, since time.Time) float64 {
+	var sum float64
+	for _, t := range r.transferDB {
+		if t.FromUserID == uid && !t.CreatedAt.Before(since) {
+			sum += t.Value
+		}
+	}
+	return sum
+}
+
+func (r *inMemRepo) UserSearch(ctx context.Context, query string, limit, offset int) ([]repo.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]repo.User, 0)
+	for _, user := range r.userDB {
+		if strings.Contains(user.Username, query) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return paginate(users, limit, offset), nil
+}
+
+func (r *inMemRepo) UserRolesUpdate(ctx context.Context, id int64, roles []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.userByID(id)
+	if err != nil {
+		return err
+	}
+	user.Roles = roles
+	r.userDB[user.Username] = *user
+	return nil
+}
+
+func (r *inMemRepo) UserLock(ctx context.Context, id int64, locked bool, a *repo.Audit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.userByID(id)
+	if err != nil {
+		return err
+	}
+	user.Locked = locked
+	r.userDB[user.Username] = *user
+	r.audit(a)
+	return nil
+}
+
+func (r *inMemRepo) UserPasswordUpdate(ctx context.Context, id int64, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.userByID(id)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	r.userDB[user.Username] = *user
+	return nil
+}
+
+// UserAdjust changes the balance and records the adjustment for the
+// balance history under one lock.
+func (r *inMemRepo) UserAdjust(ctx context.Context, adj *repo.Adjustment, a *repo.Audit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.userByID(adj.UserID)
+	if err != nil {
+		return err
+	}
+	if user.Balance+adj.Value < 0 {
+		return repo.ErrInsufficientFunds
+	}
+	user.Balance += adj.Value
+	r.userDB[user.Username] = *user
+	adj.ID = int64(len(r.adjustmentDB) + 1)
+	r.adjustmentDB = append(r.adjustmentDB, *adj)
+	r.audit(a)
+	return nil
+}
+
+func (r *inMemRepo) AdjustmentGetList(ctx context.Context, uid int64) ([]repo.Adjustment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	adjustments := make([]repo.Adjustment, 0)
+	for _, adj := range r.adjustmentDB {
+		if adj.UserID == uid {
+			adjustments = append(adjustments, adj)
+		}
+	}
+	return adjustments, nil
+}
+
+func (r *inMemRepo) OrderSearch(ctx context.Context, f repo.OrderFilter) ([]repo.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orders := make([]repo.Order, 0)
+	for _, order := range r.orderDB {
+		if f.Number != "" && order.Order != f.Number {
+			continue
+		}
+		if f.Status != "" && order.Status != f.Status {
+			continue
+		}
+		if !f.From.IsZero() && order.UploadedAt.Before(f.From) {
+			continue
+		}
+		if !f.To.IsZero() && !order.UploadedAt.Before(f.To) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].UploadedAt.Before(orders[j].UploadedAt) })
+	return paginate(orders, f.Limit, f.Offset), nil
+}
+
+func (r *inMemRepo) OrderRepoll(ctx context.Context, number string, a *repo.Audit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order, ok := r.orderDB[number]
+	if !ok || order.Type != repo.CREDIT {
+		return repo.ErrNotExists
+	}
+	order.Status = repo.NEW
+	r.orderDB[number] = order
+	r.audit(a)
+	return nil
+}
+
+func (r *inMemRepo) AuditCreate(ctx context.Context, a *repo.Audit) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.audit(a)
+	return a.ID, nil
+}
+
+// audit records a under the lock already held for the change it
+// describes. Nil a records nothing.
+func (r *inMemRepo) audit(a *repo.Audit) {
+	if a == nil {
+		return
+	}
+	a.ID = int64(len(r.auditDB) + 1)
+	r.auditDB = append(r.auditDB, *a)
+}
+
+func (r *inMemRepo) AuditGetList(ctx context.Context, limit, offset int) ([]repo.Audit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	audit := make([]repo.Audit, len(r.auditDB))
+	copy(audit, r.auditDB)
+	return paginate(audit, limit, offset), nil
+}
+
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return items[:0]
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (r *inMemRepo) SessionCreate(ctx context.Context, s *repo.Session) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s.ID = int64(len(r.sessionDB) + 1)
+	r.sessionDB = append(r.sessionDB, *s)
+	return s.ID, nil
+}
+
+func (r *inMemRepo) SessionGet(ctx context.Context, id int64) (*repo.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if id <= 0 || id > int64(len(r.sessionDB)) {
+		return nil, repo.ErrNotExists
+	}
+	s := r.sessionDB[id-1]
+	return &s, nil
+}
+
+func (r *inMemRepo) SessionGetList(ctx context.Context, uid int64) ([]repo.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := make([]repo.Session, 0)
+	for _, s := range r.sessionDB {
+		if s.UserID == uid && !s.Revoked {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (r *inMemRepo) SessionTouch(ctx context.Context, id int64, lastUsed, expires time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id <= 0 || id > int64(len(r.sessionDB)) {
+		return repo.ErrNotExists
+	}
+	r.sessionDB[id-1].LastUsedAt = lastUsed
+	r.sessionDB[id-1].ExpiresAt = expires
+	return nil
+}
+
+func (r *inMemRepo) SessionRevoke(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id <= 0 || id > int64(len(r.sessionDB)) {
+		return repo.ErrNotExists
+	}
+	r.sessionDB[id-1].Revoked = true
+	return nil
+}
+
+func (r *inMemRepo) SessionRevokeAll(ctx context.Context, uid, except int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.sessionDB {
+		if r.sessionDB[i].UserID == uid && r.sessionDB[i].ID != except {
+			r.sessionDB[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (r *inMemRepo) RefreshTokenCreate(ctx context.Context, t *repo.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.refreshDB[t.Hash]; ok {
+		return repo.ErrAlreadyExists
+	}
+	r.refreshDB[t.Hash] = *t
+	return nil
+}
+
+func (r *inMemRepo) RefreshTokenGet(ctx context.Context, hash string) (*repo.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.refreshDB[hash]
+	if !ok {
+		return nil, repo.ErrNotExists
+	}
+	return &t, nil
+}
+
+func (r *inMemRepo) RefreshTokenUse(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.refreshDB[hash]
+	if !ok || t.Used {
+		return repo.ErrNotExists
+	}
+	t.Used = true
+	r.refreshDB[hash] = t
+	return nil
+}
+
+func (r *inMemRepo) PasswordResetCreate(ctx context.Context, t *repo.PasswordReset) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.resetDB[t.Hash]; ok {
+		return repo.ErrAlreadyExists
+	}
+	r.resetDB[t.Hash] = *t
+	return nil
+}
+
+func (r *inMemRepo) PasswordResetGet(ctx context.Context, hash string) (*repo.PasswordReset, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.resetDB[hash]
+	if !ok {
+		return nil, repo.ErrNotExists
+	}
+	return &t, nil
+}
+
+func (r *inMemRepo) PasswordResetUse(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.resetDB[hash]
+	if !ok || t.Used {
+		return repo.ErrNotExists
+	}
+	t.Used = true
+	r.resetDB[hash] = t
 	return nil
 }
+
+func (r *inMemRepo) TwoFactorGet(ctx context.Context, uid int64) (*repo.TwoFactor, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.twoFactorDB[uid]
+	if !ok {
+		return nil, repo.ErrNotExists
+	}
+	t.RecoveryCodes = append([]string(nil), t.RecoveryCodes...)
+	return &t, nil
+}
+
+func (r *inMemRepo) TwoFactorSave(ctx context.Context, t *repo.TwoFactor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := *t
+	saved.RecoveryCodes = append([]string(nil), t.RecoveryCodes...)
+	r.twoFactorDB[t.UserID] = saved
+	return nil
+}
+
+func (r *inMemRepo) TwoFactorDelete(ctx context.Context, uid int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.twoFactorDB[uid]; !ok {
+		return repo.ErrNotExists
+	}
+	delete(r.twoFactorDB, uid)
+	return nil
+}
+
+// TwoFactorUseStep records step as used. Steps not after the last one
+// used are rejected with ErrNotExists.
+func (r *inMemRepo) TwoFactorUseStep(ctx context.Context, uid, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.twoFactorDB[uid]
+	if !ok || step <= t.LastStep {
+		return repo.ErrNotExists
+	}
+	t.LastStep = step
+	r.twoFactorDB[uid] = t
+	return nil
+}
+
+func (r *inMemRepo) TwoFactorUseRecoveryCode(ctx context.Context, uid int64, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.twoFactorDB[uid]
+	if !ok {
+		return repo.ErrNotExists
+	}
+	for i, code := range t.RecoveryCodes {
+		if code == hash {
+			codes := make([]string, 0, len(t.RecoveryCodes)-1)
+			codes = append(codes, t.RecoveryCodes[:i]...)
+			t.RecoveryCodes = append(codes, t.RecoveryCodes[i+1:]...)
+			r.twoFactorDB[uid] = t
+			return nil
+		}
+	}
+	return repo.ErrNotExists
+}
+
+func (r *inMemRepo) LoginChallengeCreate(ctx context.Context, c *repo.LoginChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.challengeDB[c.Hash]; ok {
+		return repo.ErrAlreadyExists
+	}
+	r.challengeDB[c.Hash] = *c
+	return nil
+}
+
+func (r *inMemRepo) LoginChallengeGet(ctx context.Context, hash string) (*repo.LoginChallenge, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.challengeDB[hash]
+	if !ok {
+		return nil, repo.ErrNotExists
+	}
+	return &c, nil
+}
+
+func (r *inMemRepo) LoginChallengeUse(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.challengeDB[hash]
+	if !ok || c.Used {
+		return repo.ErrNotExists
+	}
+	c.Used = true
+	r.challengeDB[hash] = c
+	return nil
+}
+
+func (r *inMemRepo) LoginAttemptGet(ctx context.Context, key string) (*repo.LoginAttempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, ok := r.attemptDB[key]
+	if !ok {
+		return nil, repo.ErrNotExists
+	}
+	return &a, nil
+}
+
+// LoginAttemptFail counts a failure of key within window and, once a
+// positive max is reached, locks the key for lockout.
+func (r *inMemRepo) LoginAttemptFail(ctx context.Context, key string, at time.Time, window time.Duration,
+	max int, lockout time.Duration) (*repo.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, ok := r.attemptDB[key]
+	if !ok || a.LastFailure.Before(at.Add(-window)) {
+		a = repo.LoginAttempt{Key: key, LockedUntil: a.LockedUntil}
+	}
+	a.Failures++
+	a.LastFailure = at
+	if max > 0 && a.Failures >= max {
+		a.LockedUntil = at.Add(lockout)
+	}
+	r.attemptDB[key] = a
+	return &a, nil
+}
+
+func (r *inMemRepo) LoginAttemptReset(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attemptDB, key)
+	return nil
+}
+
+type rateWindow struct {
+	count int
+	reset time.Time
+}
+
+// RateLimitHit counts a request for key in the fixed window starting
+// with the first request and returns the count and the window end.
+func (r *inMemRepo) RateLimitHit(ctx context.Context, key string, at time.Time, window time.Duration) (int, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.rateDB[key]
+	if !ok || !at.Before(w.reset) {
+		w = rateWindow{reset: at.Add(window)}
+	}
+	w.count++
+	r.rateDB[key] = w
+
+	if len(r.rateDB) > 1024 {
+		for k, w := range r.rateDB {
+			if !at.Before(w.reset) {
+				delete(r.rateDB, k)
+			}
+		}
+	}
+	return w.count, w.reset, nil
+}
+
+// Ping always succeeds; the storage lives in the process.
+func (r *inMemRepo) Ping(context.Context) error {
+	return nil
+}
+
+// SchemaVersion reports the expected version as there is nothing to migrate.
+func (r *inMemRepo) SchemaVersion(context.Context) (int, error) {
+	return repo.SchemaVersion, nil
+}
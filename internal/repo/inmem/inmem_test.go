@@ -1,6 +1,8 @@
 package inmem
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,7 +53,7 @@ func TestInMemRepoUser(t *testing.T) {
 
 	for _, tt := range createTests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := r.UserCreate(&tt.user)
+			got, err := r.UserCreate(context.Background(), &tt.user)
 			if tt.wantErr {
 				require.Equal(t, repo.ErrAlreadyExists, err)
 			} else {
@@ -91,7 +93,7 @@ func TestInMemRepoUser(t *testing.T) {
 
 	for _, tt := range getTests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := r.UserGet(tt.username)
+			got, err := r.UserGet(context.Background(), tt.username)
 			if tt.wantErr {
 				require.Equal(t, repo.ErrNotExists, err)
 			} else {
@@ -126,7 +128,7 @@ func TestInMemRepoUser(t *testing.T) {
 
 	for _, tt := range deleteTests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := r.UserDelete(tt.username)
+			err := r.UserDelete(context.Background(), tt.username)
 			if tt.wantErr {
 				require.Equal(t, repo.ErrNotExists, err)
 			} else {
@@ -186,7 +188,7 @@ func TestInMemRepoOrder(t *testing.T) {
 
 	for _, tt := range createTests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := r.OrderCreate(&tt.order)
+			got, err := r.OrderCreate(context.Background(), &tt.order)
 			if tt.wantErr {
 				require.Equal(t, repo.ErrAlreadyExists, err)
 			} else {
@@ -226,7 +228,7 @@ func TestInMemRepoOrder(t *testing.T) {
 
 	for _, tt := range getTests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := r.OrderGet(tt.order)
+			got, err := r.OrderGet(context.Background(), tt.order)
 			if tt.wantErr {
 				require.Equal(t, repo.ErrNotExists, err)
 			} else {
@@ -261,7 +263,7 @@ func TestInMemRepoOrder(t *testing.T) {
 
 	for _, tt := range deleteTests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := r.OrderDelete(tt.order)
+			err := r.OrderDelete(context.Background(), tt.order)
 			if tt.wantErr {
 				require.Equal(t, repo.ErrNotExists, err)
 			} else {
@@ -270,3 +272,142 @@ func TestInMemRepoOrder(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemRepoOrderPending(t *testing.T) {
+	orders := []repo.Order{
+		{Order: "1", Type: repo.CREDIT, UserID: 1, Status: repo.NEW},
+		{Order: "2", Type: repo.CREDIT, UserID: 1, Value: 50, Status: repo.PROCESSING},
+		{Order: "3", Type: repo.CREDIT, UserID: 1, Value: 100, Status: repo.PROCESSED},
+		{Order: "4", Type: repo.CREDIT, UserID: 1, Status: repo.INVALID},
+		{Order: "5", Type: repo.DEBIT, UserID: 1, Value: 10},
+		{Order: "6", Type: repo.CREDIT, UserID: 2, Status: repo.NEW},
+	}
+
+	r := NewInMemRepo()
+	for i := range orders {
+		_, err := r.OrderCreate(context.Background(), &orders[i])
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name      string
+		userID    int64
+		wantCount int64
+		wantValue float64
+	}{
+		{
+			name:      "Pending: user with pending orders",
+			userID:    1,
+			wantCount: 2,
+			wantValue: 50,
+		},
+		{
+			name:      "Pending: another user",
+			userID:    2,
+			wantCount: 1,
+		},
+		{
+			name:      "Pending: user without orders",
+			userID:    3,
+			wantCount: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, value, err := r.OrderPending(context.Background(), tt.userID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+			assert.Equal(t, tt.wantValue, value)
+		})
+	}
+}
+
+func TestInMemRepoOrderUpdate(t *testing.T) {
+	r := NewInMemRepo()
+	uid, err := r.UserCreate(context.Background(), &repo.User{Username: "alice"})
+	require.NoError(t, err)
+	_, err = r.OrderCreate(context.Background(), &repo.Order{Order: "1", Type: repo.CREDIT, UserID: uid, Status: repo.NEW})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		status      repo.OrderStatus
+		accrual     float64
+		wantCredit  float64
+		wantBalance float64
+		wantPending float64
+	}{
+		{
+			name:        "Update: reported while processing stays pending",
+			status:      repo.PROCESSING,
+			accrual:     30,
+			wantPending: 30,
+		},
+		{
+			name:        "Update: processed is credited",
+			status:      repo.PROCESSED,
+			accrual:     30,
+			wantCredit:  30,
+			wantBalance: 30,
+		},
+		{
+			name:        "Update: re-polled order is not credited twice",
+			status:      repo.NEW,
+			wantBalance: 30,
+		},
+		{
+			name:        "Update: re-polled order credits the difference",
+			status:      repo.PROCESSED,
+			accrual:     40,
+			wantCredit:  10,
+			wantBalance: 40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credit, err := r.OrderUpdate(context.Background(), "1", tt.status, tt.accrual, 0)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCredit, credit)
+
+			user, err := r.UserGetByID(context.Background(), uid)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBalance, user.Balance)
+			_, pending, err := r.OrderPending(context.Background(), uid)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantPending, pending)
+		})
+	}
+}
+
+func TestInMemRepoOrderUpdateConcurrent(t *testing.T) {
+	r := NewInMemRepo()
+	uid, err := r.UserCreate(context.Background(), &repo.User{Username: "alice"})
+	require.NoError(t, err)
+	_, err = r.OrderCreate(context.Background(), &repo.Order{Order: "1", Type: repo.CREDIT, UserID: uid, Status: repo.NEW})
+	require.NoError(t, err)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		credited float64
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delta, err := r.OrderUpdate(context.Background(), "1", repo.PROCESSED, 100, 10)
+			assert.NoError(t, err)
+			mu.Lock()
+			credited += delta
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	user, err := r.UserGetByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.Equal(t, float64(110), user.Balance, "the difference is credited once")
+	assert.Equal(t, float64(110), credited, "reported credits match the balance")
+}
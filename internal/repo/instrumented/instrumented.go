@@ -0,0 +1,292 @@
+// Package instrumented wraps a repository to time every call.
+package instrumented
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+// Observer receives the name and latency of each repository call.
+type Observer func(method string, d time.Duration)
+
+type instrumented struct {
+	next    repo.Repository
+	observe Observer
+}
+
+var _ repo.Repository = (*instrumented)(nil)
+
+func New(next repo.Repository, observe Observer) repo.Repository {
+	return &instrumented{next: next, observe: observe}
+}
+
+func (i *instrumented) since(method string, start time.Time) {
+	i.observe(method, time.Since(start))
+}
+
+func (i *instrumented) UserCreate(ctx context.Context, a *repo.User) (int64, error) {
+	defer i.since("UserCreate", time.Now())
+	return i.next.UserCreate(ctx, a)
+}
+
+func (i *instrumented) UserGet(ctx context.Context, a string) (*repo.User, error) {
+	defer i.since("UserGet", time.Now())
+	return i.next.UserGet(ctx, a)
+}
+
+func (i *instrumented) UserGetByID(ctx context.Context, a int64) (*repo.User, error) {
+	defer i.since("UserGetByID", time.Now())
+	return i.next.UserGetByID(ctx, a)
+}
+
+func (i *instrumented) UserDelete(ctx context.Context, a string) error {
+	defer i.since("UserDelete", time.Now())
+	return i.next.UserDelete(ctx, a)
+}
+
+func (i *instrumented) UserUpdate(ctx context.Context, a *repo.User) error {
+	defer i.since("UserUpdate", time.Now())
+	return i.next.UserUpdate(ctx, a)
+}
+
+func (i *instrumented) UserAccruals(ctx context.Context, a time.Time) (map[int64]float64, error) {
+	defer i.since("UserAccruals", time.Now())
+	return i.next.UserAccruals(ctx, a)
+}
+
+func (i *instrumented) UserTierUpdate(ctx context.Context, a0 int64, a1 repo.UserTier) error {
+	defer i.since("UserTierUpdate", time.Now())
+	return i.next.UserTierUpdate(ctx, a0, a1)
+}
+
+func (i *instrumented) UserSearch(ctx context.Context, a0 string, a1 int, a2 int) ([]repo.User, error) {
+	defer i.since("UserSearch", time.Now())
+	return i.next.UserSearch(ctx, a0, a1, a2)
+}
+
+func (i *instrumented) UserRolesUpdate(ctx context.Context, a0 int64, a1 []string) error {
+	defer i.since("UserRolesUpdate", time.Now())
+	return i.next.UserRolesUpdate(ctx, a0, a1)
+}
+
+func (i *instrumented) UserLock(ctx context.Context, a0 int64, a1 bool, a2 *repo.Audit) error {
+	defer i.since("UserLock", time.Now())
+	return i.next.UserLock(ctx, a0, a1, a2)
+}
+
+func (i *instrumented) UserAdjust(ctx context.Context, a0 *repo.Adjustment, a1 *repo.Audit) error {
+	defer i.since("UserAdjust", time.Now())
+	return i.next.UserAdjust(ctx, a0, a1)
+}
+
+func (i *instrumented) AdjustmentGetList(ctx context.Context, a int64) ([]repo.Adjustment, error) {
+	defer i.since("AdjustmentGetList", time.Now())
+	return i.next.AdjustmentGetList(ctx, a)
+}
+
+func (i *instrumented) UserPasswordUpdate(ctx context.Context, a0 int64, a1 string) error {
+	defer i.since("UserPasswordUpdate", time.Now())
+	return i.next.UserPasswordUpdate(ctx, a0, a1)
+}
+
+func (i *instrumented) OrderCreate(ctx context.Context, a *repo.Order) (int64, error) {
+	defer i.since("OrderCreate", time.Now())
+	return i.next.OrderCreate(ctx, a)
+}
+
+func (i *instrumented) OrderWithdraw(ctx context.Context, a *repo.Order) (int64, error) {
+	defer i.since("OrderWithdraw", time.Now())
+	return i.next.OrderWithdraw(ctx, a)
+}
+
+func (i *instrumented) OrderGet(ctx context.Context, a string) (*repo.Order, error) {
+	defer i.since("OrderGet", time.Now())
+	return i.next.OrderGet(ctx, a)
+}
+
+func (i *instrumented) OrderGetList(ctx context.Context, a0 int64, a1 repo.OrderType) ([]repo.Order, error) {
+	defer i.since("OrderGetList", time.Now())
+	return i.next.OrderGetList(ctx, a0, a1)
+}
+
+func (i *instrumented) OrderDelete(ctx context.Context, a string) error {
+	defer i.since("OrderDelete", time.Now())
+	return i.next.OrderDelete(ctx, a)
+}
+
+func (i *instrumented) OrderToProcess(ctx context.Context) ([]repo.Order, error) {
+	defer i.since("OrderToProcess", time.Now())
+	return i.next.OrderToProcess(ctx)
+}
+
+func (i *instrumented) OrderUpdate(ctx context.Context, a0 string, a1 repo.OrderStatus, a2 float64, a3 float64) (float64, error) {
+	defer i.since("OrderUpdate", time.Now())
+	return i.next.OrderUpdate(ctx, a0, a1, a2, a3)
+}
+
+func (i *instrumented) OrderPending(ctx context.Context, a int64) (int64, float64, error) {
+	defer i.since("OrderPending", time.Now())
+	return i.next.OrderPending(ctx, a)
+}
+
+func (i *instrumented) OrderSearch(ctx context.Context, a repo.OrderFilter) ([]repo.Order, error) {
+	defer i.since("OrderSearch", time.Now())
+	return i.next.OrderSearch(ctx, a)
+}
+
+func (i *instrumented) OrderRepoll(ctx context.Context, a0 string, a1 *repo.Audit) error {
+	defer i.since("OrderRepoll", time.Now())
+	return i.next.OrderRepoll(ctx, a0, a1)
+}
+
+func (i *instrumented) TransferCreate(ctx context.Context, a0 *repo.Transfer, a1 float64) (int64, error) {
+	defer i.since("TransferCreate", time.Now())
+	return i.next.TransferCreate(ctx, a0, a1)
+}
+
+func (i *instrumented) TransferGetList(ctx context.Context, a int64) ([]repo.Transfer, error) {
+	defer i.since("TransferGetList", time.Now())
+	return i.next.TransferGetList(ctx, a)
+}
+
+func (i *instrumented) AuditCreate(ctx context.Context, a *repo.Audit) (int64, error) {
+	defer i.since("AuditCreate", time.Now())
+	return i.next.AuditCreate(ctx, a)
+}
+
+func (i *instrumented) AuditGetList(ctx context.Context, a0 int, a1 int) ([]repo.Audit, error) {
+	defer i.since("AuditGetList", time.Now())
+	return i.next.AuditGetList(ctx, a0, a1)
+}
+
+func (i *instrumented) SessionCreate(ctx context.Context, a *repo.Session) (int64, error) {
+	defer i.since("SessionCreate", time.Now())
+	return i.next.SessionCreate(ctx, a)
+}
+
+func (i *instrumented) SessionGet(ctx context.Context, a int64) (*repo.Session, error) {
+	defer i.since("SessionGet", time.Now())
+	return i.next.SessionGet(ctx, a)
+}
+
+func (i *instrumented) SessionGetList(ctx context.Context, a int64) ([]repo.Session, error) {
+	defer i.since("SessionGetList", time.Now())
+	return i.next.SessionGetList(ctx, a)
+}
+
+func (i *instrumented) SessionTouch(ctx context.Context, a0 int64, a1 time.Time, a2 time.Time) error {
+	defer i.since("SessionTouch", time.Now())
+	return i.next.SessionTouch(ctx, a0, a1, a2)
+}
+
+func (i *instrumented) SessionRevoke(ctx context.Context, a int64) error {
+	defer i.since("SessionRevoke", time.Now())
+	return i.next.SessionRevoke(ctx, a)
+}
+
+func (i *instrumented) SessionRevokeAll(ctx context.Context, a0 int64, a1 int64) error {
+	defer i.since("SessionRevokeAll", time.Now())
+	return i.next.SessionRevokeAll(ctx, a0, a1)
+}
+
+func (i *instrumented) RefreshTokenCreate(ctx context.Context, a *repo.RefreshToken) error {
+	defer i.since("RefreshTokenCreate", time.Now())
+	return i.next.RefreshTokenCreate(ctx, a)
+}
+
+func (i *instrumented) RefreshTokenGet(ctx context.Context, a string) (*repo.RefreshToken, error) {
+	defer i.since("RefreshTokenGet", time.Now())
+	return i.next.RefreshTokenGet(ctx, a)
+}
+
+func (i *instrumented) RefreshTokenUse(ctx context.Context, a string) error {
+	defer i.since("RefreshTokenUse", time.Now())
+	return i.next.RefreshTokenUse(ctx, a)
+}
+
+func (i *instrumented) PasswordResetCreate(ctx context.Context, a *repo.PasswordReset) error {
+	defer i.since("PasswordResetCreate", time.Now())
+	return i.next.PasswordResetCreate(ctx, a)
+}
+
+func (i *instrumented) PasswordResetGet(ctx context.Context, a string) (*repo.PasswordReset, error) {
+	defer i.since("PasswordResetGet", time.Now())
+	return i.next.PasswordResetGet(ctx, a)
+}
+
+func (i *instrumented) PasswordResetUse(ctx context.Context, a string) error {
+	defer i.since("PasswordResetUse", time.Now())
+	return i.next.PasswordResetUse(ctx, a)
+}
+
+func (i *instrumented) TwoFactorGet(ctx context.Context, a int64) (*repo.TwoFactor, error) {
+	defer i.since("TwoFactorGet", time.Now())
+	return i.next.TwoFactorGet(ctx, a)
+}
+
+func (i *instrumented) TwoFactorSave(ctx context.Context, a *repo.TwoFactor) error {
+	defer i.since("TwoFactorSave", time.Now())
+	return i.next.TwoFactorSave(ctx, a)
+}
+
+func (i *instrumented) TwoFactorDelete(ctx context.Context, a int64) error {
+	defer i.since("TwoFactorDelete", time.Now())
+	return i.next.TwoFactorDelete(ctx, a)
+}
+
+func (i *instrumented) TwoFactorUseStep(ctx context.Context, a0 int64, a1 int64) error {
+	defer i.since("TwoFactorUseStep", time.Now())
+	return i.next.TwoFactorUseStep(ctx, a0, a1)
+}
+
+func (i *instrumented) TwoFactorUseRecoveryCode(ctx context.Context, a0 int64, a1 string) error {
+	defer i.since("TwoFactorUseRecoveryCode", time.Now())
+	return i.next.TwoFactorUseRecoveryCode(ctx, a0, a1)
+}
+
+func (i *instrumented) LoginChallengeCreate(ctx context.Context, a *repo.LoginChallenge) error {
+	defer i.since("LoginChallengeCreate", time.Now())
+	return i.next.LoginChallengeCreate(ctx, a)
+}
+
+func (i *instrumented) LoginChallengeGet(ctx context.Context, a string) (*repo.LoginChallenge, error) {
+	defer i.since("LoginChallengeGet", time.Now())
+	return i.next.LoginChallengeGet(ctx, a)
+}
+
+func (i *instrumented) LoginChallengeUse(ctx context.Context, a string) error {
+	defer i.since("LoginChallengeUse", time.Now())
+	return i.next.LoginChallengeUse(ctx, a)
+}
+
+func (i *instrumented) LoginAttemptGet(ctx context.Context, a string) (*repo.LoginAttempt, error) {
+	defer i.since("LoginAttemptGet", time.Now())
+	return i.next.LoginAttemptGet(ctx, a)
+}
+
+func (i *instrumented) LoginAttemptFail(ctx context.Context, a0 string, a1 time.Time, a2 time.Duration, a3 int, a4 time.Duration) (*repo.LoginAttempt, error) {
+	defer i.since("LoginAttemptFail", time.Now())
+	return i.next.LoginAttemptFail(ctx, a0, a1, a2, a3, a4)
+}
+
+func (i *instrumented) LoginAttemptReset(ctx context.Context, a string) error {
+	defer i.since("LoginAttemptReset", time.Now())
+	return i.next.LoginAttemptReset(ctx, a)
+}
+
+func (i *instrumented) RateLimitHit(ctx context.Context, a0 string, a1 time.Time, a2 time.Duration) (int, time.Time, error) {
+	defer i.since("RateLimitHit", time.Now())
+	return i.next.RateLimitHit(ctx, a0, a1, a2)
+}
+
+func (i *instrumented) Ping(ctx context.Context) error {
+	defer i.since("Ping", time.Now())
+	return i.next.Ping(ctx)
+}
+
+func (i *instrumented) SchemaVersion(ctx context.Context) (int, error) {
+	defer i.since("SchemaVersion", time.Now())
+	return i.next.SchemaVersion(ctx)
+}
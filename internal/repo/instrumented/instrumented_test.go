@@ -0,0 +1,29 @@
+package instrumented
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumented(t *testing.T) {
+	var calls []string
+	r := New(inmem.NewInMemRepo(), func(method string, d time.Duration) {
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		calls = append(calls, method)
+	})
+
+	id, err := r.UserCreate(context.Background(), &repo.User{Username: "alice"})
+	require.NoError(t, err)
+	_, err = r.UserGetByID(context.Background(), id)
+	require.NoError(t, err)
+	_, err = r.UserGet(context.Background(), "bob")
+	assert.ErrorIs(t, err, repo.ErrNotExists, "errors pass through unchanged")
+
+	assert.Equal(t, []string{"UserCreate", "UserGetByID", "UserGet"}, calls)
+}
@@ -1,15 +1,24 @@
 package repo
 
 import (
-	"errors"
+	"context"
 	"time"
 )
 
 var (
-	ErrAlreadyExists = errors.New("item already exists")
-	ErrNotExists     = errors.New("item not exists")
+	ErrAlreadyExists     = NewError(KindConflict, "already_exists", "item already exists")
+	ErrNotExists         = NewError(KindNotFound, "not_found", "item not exists")
+	ErrInsufficientFunds = NewError(KindInsufficientFunds, "insufficient_funds", "insufficient funds")
+	ErrUnavailable       = NewError(KindUnavailable, "unavailable", "storage unavailable")
+	ErrTransferLimit     = NewError(KindUnprocessable, "transfer_limit_exceeded", "transfer limit exceeded")
 )
 
+const RoleAdmin = "admin"
+
+// SchemaVersion is the storage schema this build expects. Bump it with
+// every change to the database schema.
+const SchemaVersion = 1
+
 type OrderType string
 
 const (
@@ -26,36 +35,212 @@ const (
 	PROCESSED  OrderStatus = "PROCESSED"
 )
 
+type UserTier string
+
+const (
+	BASIC    UserTier = "BASIC"
+	SILVER   UserTier = "SILVER"
+	GOLD     UserTier = "GOLD"
+	PLATINUM UserTier = "PLATINUM"
+)
+
 type User struct {
 	ID         int64
 	Username   string
 	Password   string
 	Balance    float64
 	Withdrawal float64
+	Tier       UserTier
+	Roles      []string
+	Locked     bool
+	Deleted    bool
 	CreatedAt  time.Time
 }
 
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 type Order struct {
 	ID         int64
 	Order      string
 	Type       OrderType
 	UserID     int64
 	Value      float64
+	Bonus      float64
 	Status     OrderStatus
 	UploadedAt time.Time
+	// Credited is the part of Value and Bonus already added to the
+	// owner's balance. The rest is pending until the order is PROCESSED.
+	Credited float64
+	// RequestID identifies the upload request in worker logs and
+	// accrual calls.
+	RequestID string
+}
+
+type Transfer struct {
+	ID         int64
+	FromUserID int64
+	ToUserID   int64
+	From       string
+	To         string
+	Value      float64
+	Memo       string
+	CreatedAt  time.Time
+}
+
+// Adjustment is a balance change made by an operator.
+type Adjustment struct {
+	ID        int64
+	UserID    int64
+	Value     float64
+	Reason    string
+	CreatedAt time.Time
+}
+
+type OrderFilter struct {
+	Number string
+	Status OrderStatus
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
 }
 
+type Audit struct {
+	ID        int64
+	ActorID   int64
+	Action    string
+	Target    string
+	Details   string
+	CreatedAt time.Time
+}
+
+type Session struct {
+	ID         int64
+	UserID     int64
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	Revoked    bool
+}
+
+type RefreshToken struct {
+	Hash      string
+	SessionID int64
+	Used      bool
+	ExpiresAt time.Time
+}
+
+// PasswordReset is a single-use password reset token stored by hash.
+type PasswordReset struct {
+	Hash      string
+	UserID    int64
+	Used      bool
+	ExpiresAt time.Time
+}
+
+// LoginAttempt tracks failed logins for a key such as a username or
+// a client address.
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// TwoFactor is the TOTP enrolment of a user. LastStep is the last time
+// step accepted so that codes cannot be replayed, and recovery codes
+// are stored by hash.
+type TwoFactor struct {
+	UserID        int64
+	Secret        string
+	Enabled       bool
+	LastStep      int64
+	RecoveryCodes []string
+}
+
+// LoginChallenge is a single-use token issued once the password of a
+// user with two-factor authentication is verified.
+type LoginChallenge struct {
+	Hash      string
+	UserID    int64
+	Used      bool
+	ExpiresAt time.Time
+}
+
+// Repository is the storage of the service. Every method takes the
+// context of the request or job it serves so that deadlines,
+// cancellation and trace spans reach the storage. Methods taking an
+// *Audit record it together with the change when it is not nil.
 type Repository interface {
-	UserCreate(*User) (int64, error)
-	UserGet(string) (*User, error)
-	UserGetByID(int64) (*User, error)
-	UserDelete(string) error
-	UserUpdate(*User) error
-
-	OrderCreate(*Order) (int64, error)
-	OrderGet(string) (*Order, error)
-	OrderGetList(int64, OrderType) ([]Order, error)
-	OrderDelete(string) error
-	OrderToProcess() ([]string, error)
-	OrderUpdate(string, OrderStatus, float64) error
+	UserCreate(context.Context, *User) (int64, error)
+	UserGet(context.Context, string) (*User, error)
+	UserGetByID(context.Context, int64) (*User, error)
+	UserDelete(context.Context, string) error
+	UserUpdate(context.Context, *User) error
+	UserAccruals(context.Context, time.Time) (map[int64]float64, error)
+	UserTierUpdate(context.Context, int64, UserTier) error
+	UserSearch(context.Context, string, int, int) ([]User, error)
+	UserRolesUpdate(context.Context, int64, []string) error
+	UserLock(context.Context, int64, bool, *Audit) error
+	UserAdjust(context.Context, *Adjustment, *Audit) error
+	AdjustmentGetList(context.Context, int64) ([]Adjustment, error)
+	UserPasswordUpdate(context.Context, int64, string) error
+
+	OrderCreate(context.Context, *Order) (int64, error)
+	OrderWithdraw(context.Context, *Order) (int64, error)
+	OrderGet(context.Context, string) (*Order, error)
+	OrderGetList(context.Context, int64, OrderType) ([]Order, error)
+	OrderDelete(context.Context, string) error
+	OrderToProcess(context.Context) ([]Order, error)
+	OrderUpdate(context.Context, string, OrderStatus, float64, float64) (float64, error)
+	OrderPending(context.Context, int64) (int64, float64, error)
+	OrderSearch(context.Context, OrderFilter) ([]Order, error)
+	OrderRepoll(context.Context, string, *Audit) error
+
+	TransferCreate(context.Context, *Transfer, float64) (int64, error)
+	TransferGetList(context.Context, int64) ([]Transfer, error)
+
+	AuditCreate(context.Context, *Audit) (int64, error)
+	AuditGetList(context.Context, int, int) ([]Audit, error)
+
+	SessionCreate(context.Context, *Session) (int64, error)
+	SessionGet(context.Context, int64) (*Session, error)
+	SessionGetList(context.Context, int64) ([]Session, error)
+	SessionTouch(context.Context, int64, time.Time, time.Time) error
+	SessionRevoke(context.Context, int64) error
+	SessionRevokeAll(context.Context, int64, int64) error
+	RefreshTokenCreate(context.Context, *RefreshToken) error
+	RefreshTokenGet(context.Context, string) (*RefreshToken, error)
+	RefreshTokenUse(context.Context, string) error
+
+	PasswordResetCreate(context.Context, *PasswordReset) error
+	PasswordResetGet(context.Context, string) (*PasswordReset, error)
+	PasswordResetUse(context.Context, string) error
+
+	TwoFactorGet(context.Context, int64) (*TwoFactor, error)
+	TwoFactorSave(context.Context, *TwoFactor) error
+	TwoFactorDelete(context.Context, int64) error
+	TwoFactorUseStep(context.Context, int64, int64) error
+	TwoFactorUseRecoveryCode(context.Context, int64, string) error
+	LoginChallengeCreate(context.Context, *LoginChallenge) error
+	LoginChallengeGet(context.Context, string) (*LoginChallenge, error)
+	LoginChallengeUse(context.Context, string) error
+
+	LoginAttemptGet(context.Context, string) (*LoginAttempt, error)
+	LoginAttemptFail(context.Context, string, time.Time, time.Duration, int, time.Duration) (*LoginAttempt, error)
+	LoginAttemptReset(context.Context, string) error
+
+	RateLimitHit(context.Context, string, time.Time, time.Duration) (int, time.Time, error)
+
+	Ping(context.Context) error
+	SchemaVersion(context.Context) (int, error)
 }
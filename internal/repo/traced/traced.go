@@ -0,0 +1,404 @@
+// Package traced wraps a repository so that every call is recorded as a
+// span under the context it is given.
+package traced
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type traced struct {
+	next repo.Repository
+}
+
+var _ repo.Repository = (*traced)(nil)
+
+// New wraps next once for the whole process; every call is recorded
+// under the context it is given.
+func New(next repo.Repository) repo.Repository {
+	return &traced{next: next}
+}
+
+// start opens the span of a call and returns the context carrying it,
+// which is handed to next so that its work nests under the span.
+func (t *traced) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "repo."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.operation", method)))
+}
+
+func (t *traced) UserCreate(ctx context.Context, a *repo.User) (int64, error) {
+	ctx, span := t.start(ctx, "UserCreate")
+	v, err := t.next.UserCreate(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) UserGet(ctx context.Context, a string) (*repo.User, error) {
+	ctx, span := t.start(ctx, "UserGet")
+	v, err := t.next.UserGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) UserGetByID(ctx context.Context, a int64) (*repo.User, error) {
+	ctx, span := t.start(ctx, "UserGetByID")
+	v, err := t.next.UserGetByID(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) UserDelete(ctx context.Context, a string) error {
+	ctx, span := t.start(ctx, "UserDelete")
+	err := t.next.UserDelete(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) UserUpdate(ctx context.Context, a *repo.User) error {
+	ctx, span := t.start(ctx, "UserUpdate")
+	err := t.next.UserUpdate(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) UserAccruals(ctx context.Context, a time.Time) (map[int64]float64, error) {
+	ctx, span := t.start(ctx, "UserAccruals")
+	v, err := t.next.UserAccruals(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) UserTierUpdate(ctx context.Context, a0 int64, a1 repo.UserTier) error {
+	ctx, span := t.start(ctx, "UserTierUpdate")
+	err := t.next.UserTierUpdate(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) UserSearch(ctx context.Context, a0 string, a1 int, a2 int) ([]repo.User, error) {
+	ctx, span := t.start(ctx, "UserSearch")
+	v, err := t.next.UserSearch(ctx, a0, a1, a2)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) UserRolesUpdate(ctx context.Context, a0 int64, a1 []string) error {
+	ctx, span := t.start(ctx, "UserRolesUpdate")
+	err := t.next.UserRolesUpdate(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) UserLock(ctx context.Context, a0 int64, a1 bool, a2 *repo.Audit) error {
+	ctx, span := t.start(ctx, "UserLock")
+	err := t.next.UserLock(ctx, a0, a1, a2)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) UserAdjust(ctx context.Context, a0 *repo.Adjustment, a1 *repo.Audit) error {
+	ctx, span := t.start(ctx, "UserAdjust")
+	err := t.next.UserAdjust(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) AdjustmentGetList(ctx context.Context, a int64) ([]repo.Adjustment, error) {
+	ctx, span := t.start(ctx, "AdjustmentGetList")
+	v, err := t.next.AdjustmentGetList(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) UserPasswordUpdate(ctx context.Context, a0 int64, a1 string) error {
+	ctx, span := t.start(ctx, "UserPasswordUpdate")
+	err := t.next.UserPasswordUpdate(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) OrderCreate(ctx context.Context, a *repo.Order) (int64, error) {
+	ctx, span := t.start(ctx, "OrderCreate")
+	v, err := t.next.OrderCreate(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderWithdraw(ctx context.Context, a *repo.Order) (int64, error) {
+	ctx, span := t.start(ctx, "OrderWithdraw")
+	v, err := t.next.OrderWithdraw(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderGet(ctx context.Context, a string) (*repo.Order, error) {
+	ctx, span := t.start(ctx, "OrderGet")
+	v, err := t.next.OrderGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderGetList(ctx context.Context, a0 int64, a1 repo.OrderType) ([]repo.Order, error) {
+	ctx, span := t.start(ctx, "OrderGetList")
+	v, err := t.next.OrderGetList(ctx, a0, a1)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderDelete(ctx context.Context, a string) error {
+	ctx, span := t.start(ctx, "OrderDelete")
+	err := t.next.OrderDelete(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) OrderToProcess(ctx context.Context) ([]repo.Order, error) {
+	ctx, span := t.start(ctx, "OrderToProcess")
+	v, err := t.next.OrderToProcess(ctx)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderUpdate(ctx context.Context, a0 string, a1 repo.OrderStatus, a2 float64, a3 float64) (float64, error) {
+	ctx, span := t.start(ctx, "OrderUpdate")
+	v, err := t.next.OrderUpdate(ctx, a0, a1, a2, a3)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderPending(ctx context.Context, a int64) (int64, float64, error) {
+	ctx, span := t.start(ctx, "OrderPending")
+	v0, v1, err := t.next.OrderPending(ctx, a)
+	tracing.End(span, err)
+	return v0, v1, err
+}
+
+func (t *traced) OrderSearch(ctx context.Context, a repo.OrderFilter) ([]repo.Order, error) {
+	ctx, span := t.start(ctx, "OrderSearch")
+	v, err := t.next.OrderSearch(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) OrderRepoll(ctx context.Context, a0 string, a1 *repo.Audit) error {
+	ctx, span := t.start(ctx, "OrderRepoll")
+	err := t.next.OrderRepoll(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) TransferCreate(ctx context.Context, a0 *repo.Transfer, a1 float64) (int64, error) {
+	ctx, span := t.start(ctx, "TransferCreate")
+	v, err := t.next.TransferCreate(ctx, a0, a1)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) TransferGetList(ctx context.Context, a int64) ([]repo.Transfer, error) {
+	ctx, span := t.start(ctx, "TransferGetList")
+	v, err := t.next.TransferGetList(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) AuditCreate(ctx context.Context, a *repo.Audit) (int64, error) {
+	ctx, span := t.start(ctx, "AuditCreate")
+	v, err := t.next.AuditCreate(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) AuditGetList(ctx context.Context, a0 int, a1 int) ([]repo.Audit, error) {
+	ctx, span := t.start(ctx, "AuditGetList")
+	v, err := t.next.AuditGetList(ctx, a0, a1)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) SessionCreate(ctx context.Context, a *repo.Session) (int64, error) {
+	ctx, span := t.start(ctx, "SessionCreate")
+	v, err := t.next.SessionCreate(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) SessionGet(ctx context.Context, a int64) (*repo.Session, error) {
+	ctx, span := t.start(ctx, "SessionGet")
+	v, err := t.next.SessionGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) SessionGetList(ctx context.Context, a int64) ([]repo.Session, error) {
+	ctx, span := t.start(ctx, "SessionGetList")
+	v, err := t.next.SessionGetList(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) SessionTouch(ctx context.Context, a0 int64, a1 time.Time, a2 time.Time) error {
+	ctx, span := t.start(ctx, "SessionTouch")
+	err := t.next.SessionTouch(ctx, a0, a1, a2)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) SessionRevoke(ctx context.Context, a int64) error {
+	ctx, span := t.start(ctx, "SessionRevoke")
+	err := t.next.SessionRevoke(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) SessionRevokeAll(ctx context.Context, a0 int64, a1 int64) error {
+	ctx, span := t.start(ctx, "SessionRevokeAll")
+	err := t.next.SessionRevokeAll(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) RefreshTokenCreate(ctx context.Context, a *repo.RefreshToken) error {
+	ctx, span := t.start(ctx, "RefreshTokenCreate")
+	err := t.next.RefreshTokenCreate(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) RefreshTokenGet(ctx context.Context, a string) (*repo.RefreshToken, error) {
+	ctx, span := t.start(ctx, "RefreshTokenGet")
+	v, err := t.next.RefreshTokenGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) RefreshTokenUse(ctx context.Context, a string) error {
+	ctx, span := t.start(ctx, "RefreshTokenUse")
+	err := t.next.RefreshTokenUse(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) PasswordResetCreate(ctx context.Context, a *repo.PasswordReset) error {
+	ctx, span := t.start(ctx, "PasswordResetCreate")
+	err := t.next.PasswordResetCreate(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) PasswordResetGet(ctx context.Context, a string) (*repo.PasswordReset, error) {
+	ctx, span := t.start(ctx, "PasswordResetGet")
+	v, err := t.next.PasswordResetGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) PasswordResetUse(ctx context.Context, a string) error {
+	ctx, span := t.start(ctx, "PasswordResetUse")
+	err := t.next.PasswordResetUse(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) TwoFactorGet(ctx context.Context, a int64) (*repo.TwoFactor, error) {
+	ctx, span := t.start(ctx, "TwoFactorGet")
+	v, err := t.next.TwoFactorGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) TwoFactorSave(ctx context.Context, a *repo.TwoFactor) error {
+	ctx, span := t.start(ctx, "TwoFactorSave")
+	err := t.next.TwoFactorSave(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) TwoFactorDelete(ctx context.Context, a int64) error {
+	ctx, span := t.start(ctx, "TwoFactorDelete")
+	err := t.next.TwoFactorDelete(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) TwoFactorUseStep(ctx context.Context, a0 int64, a1 int64) error {
+	ctx, span := t.start(ctx, "TwoFactorUseStep")
+	err := t.next.TwoFactorUseStep(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) TwoFactorUseRecoveryCode(ctx context.Context, a0 int64, a1 string) error {
+	ctx, span := t.start(ctx, "TwoFactorUseRecoveryCode")
+	err := t.next.TwoFactorUseRecoveryCode(ctx, a0, a1)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) LoginChallengeCreate(ctx context.Context, a *repo.LoginChallenge) error {
+	ctx, span := t.start(ctx, "LoginChallengeCreate")
+	err := t.next.LoginChallengeCreate(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) LoginChallengeGet(ctx context.Context, a string) (*repo.LoginChallenge, error) {
+	ctx, span := t.start(ctx, "LoginChallengeGet")
+	v, err := t.next.LoginChallengeGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) LoginChallengeUse(ctx context.Context, a string) error {
+	ctx, span := t.start(ctx, "LoginChallengeUse")
+	err := t.next.LoginChallengeUse(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) LoginAttemptGet(ctx context.Context, a string) (*repo.LoginAttempt, error) {
+	ctx, span := t.start(ctx, "LoginAttemptGet")
+	v, err := t.next.LoginAttemptGet(ctx, a)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) LoginAttemptFail(ctx context.Context, a0 string, a1 time.Time, a2 time.Duration, a3 int, a4 time.Duration) (*repo.LoginAttempt, error) {
+	ctx, span := t.start(ctx, "LoginAttemptFail")
+	v, err := t.next.LoginAttemptFail(ctx, a0, a1, a2, a3, a4)
+	tracing.End(span, err)
+	return v, err
+}
+
+func (t *traced) LoginAttemptReset(ctx context.Context, a string) error {
+	ctx, span := t.start(ctx, "LoginAttemptReset")
+	err := t.next.LoginAttemptReset(ctx, a)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) RateLimitHit(ctx context.Context, a0 string, a1 time.Time, a2 time.Duration) (int, time.Time, error) {
+	ctx, span := t.start(ctx, "RateLimitHit")
+	v0, v1, err := t.next.RateLimitHit(ctx, a0, a1, a2)
+	tracing.End(span, err)
+	return v0, v1, err
+}
+
+func (t *traced) Ping(ctx context.Context) error {
+	ctx, span := t.start(ctx, "Ping")
+	err := t.next.Ping(ctx)
+	tracing.End(span, err)
+	return err
+}
+
+func (t *traced) SchemaVersion(ctx context.Context) (int, error) {
+	ctx, span := t.start(ctx, "SchemaVersion")
+	v, err := t.next.SchemaVersion(ctx)
+	tracing.End(span, err)
+	return v, err
+}
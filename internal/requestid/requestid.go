@@ -0,0 +1,40 @@
+// Package requestid generates and sanitises the IDs correlating an
+// HTTP request with the log lines and accrual calls it causes.
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header carries the request ID on incoming requests, responses and
+// requests to the accrual system.
+const Header = "X-Request-ID"
+
+const maxLength = 128
+
+// New returns a random request ID.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Sanitize returns id when it is safe to log and forward, or a new
+// random ID otherwise.
+func Sanitize(id string) string {
+	if id == "" || len(id) > maxLength {
+		return New()
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return New()
+		}
+	}
+	return id
+}
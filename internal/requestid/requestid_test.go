@@ -0,0 +1,16 @@
+package requestid
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "abc-123_x.y:z", Sanitize("abc-123_x.y:z"))
+	assert.Len(t, Sanitize(""), 32)
+	assert.Len(t, Sanitize("bad id\n"), 32)
+	assert.Len(t, Sanitize(strings.Repeat("a", 129)), 32)
+	assert.NotEqual(t, New(), New())
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_Account(t *testing.T) {
+	ts := newTestServer(t, nil)
+	alice, bob := ts.register("alice"), ts.register("bob")
+	require.NoError(t, ts.db.UserAdjust(context.Background(), &repo.Adjustment{UserID: 1, Value: 100, Reason: "opening balance", CreatedAt: time.Now()}, nil))
+	res := ts.do("POST", "/api/user/balance/transfer", `{"login":"bob","sum":40}`, alice)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res = ts.upload("12345678903", alice)
+	res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	t.Run("balance", func(t *testing.T) {
+		res := ts.do("GET", "/api/user/balance", "", alice)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var balance map[string]interface{}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&balance))
+		assert.Equal(t, 60.0, balance["current"])
+		assert.Equal(t, 0.0, balance["withdrawn"])
+		assert.Equal(t, 0.0, balance["pending"])
+		assert.Equal(t, 1.0, balance["pending_orders"])
+	})
+
+	t.Run("export", func(t *testing.T) {
+		res := ts.do("GET", "/api/user/export", "", alice)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Contains(t, res.Header.Get("Content-Disposition"), "attachment")
+
+		var export domain.UserExport
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&export))
+		assert.Equal(t, "alice", export.Profile.Login)
+		assert.Equal(t, 60.0, export.Balance.Current)
+		require.Len(t, export.Transfers, 1)
+		require.Len(t, export.BalanceHistory, 2)
+		assert.Equal(t, domain.LedgerAdjustment, export.BalanceHistory[0].Type)
+		assert.Equal(t, 100.0, export.BalanceHistory[0].Amount)
+		assert.Equal(t, domain.LedgerTransferOut, export.BalanceHistory[1].Type)
+		assert.Equal(t, -40.0, export.BalanceHistory[1].Amount)
+
+		var sum float64
+		for _, e := range export.BalanceHistory {
+			sum += e.Amount
+		}
+		assert.Equal(t, export.Balance.Current, sum, "the history sums to the balance")
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		cookies []*http.Cookie
+		status  int
+	}{
+		{
+			name:    "delete requires password",
+			method:  "DELETE",
+			path:    "/api/user",
+			body:    `{"password":"wrong"}`,
+			cookies: alice,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "delete anonymises account",
+			method:  "DELETE",
+			path:    "/api/user",
+			body:    `{"password":"1234"}`,
+			cookies: alice,
+			status:  http.StatusNoContent,
+		},
+		{
+			name:    "deleted user is logged out",
+			method:  "GET",
+			path:    "/api/user/balance",
+			cookies: alice,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:   "deleted user cannot log in",
+			method: "POST",
+			path:   "/api/user/login",
+			body:   `{"login":"alice","password":"1234"}`,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "login can be registered again",
+			method: "POST",
+			path:   "/api/user/register",
+			body:   `{"login":"alice","password":"1234"}`,
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(tt.method, tt.path, tt.body, tt.cookies)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+
+	t.Run("ledger is preserved", func(t *testing.T) {
+		res := ts.do("GET", "/api/user/transfers", "", bob)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var transfers []domain.TransferModel
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&transfers))
+		require.Len(t, transfers, 1)
+		assert.Equal(t, 40.0, transfers[0].Value)
+		assert.True(t, strings.HasPrefix(transfers[0].Login, "deleted-"))
+	})
+}
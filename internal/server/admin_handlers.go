@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	repo "github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func adminFromContext(w http.ResponseWriter, r *http.Request) (*domain.AdminModel, bool) {
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		writeError(w, r, errUnauthenticated)
+		return nil, false
+	}
+	return &domain.AdminModel{ID: principal.UserID}, true
+}
+
+func pagination(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	limit, offset := defaultPageSize, 0
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			writeError(w, r, errBadParameter)
+			return 0, 0, false
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeError(w, r, errBadParameter)
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+func userIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, r, errBadParameter)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}, caller string) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		requestLog(r).Error().AnErr("encoding response", err).Msg(caller)
+		writeError(w, r, err)
+	}
+}
+
+func (s *server) adminUserSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+		limit, offset, ok := pagination(w, r)
+		if !ok {
+			return
+		}
+
+		list, err := admin.SearchUsers(r.Context(), s.db, r.URL.Query().Get("q"), limit, offset)
+		if err != nil {
+			requestLog(r).Error().AnErr("search users", err).Msg("adminUserSearch")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, &list, "adminUserSearch")
+	}
+}
+
+func (s *server) adminUserDetail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+		id, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		info, err := admin.UserDetail(r.Context(), s.db, id)
+		if err != nil {
+			requestLog(r).Error().AnErr("user detail", err).Msg("adminUserDetail")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, info, "adminUserDetail")
+	}
+}
+
+func (s *server) adminOrderSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+		limit, offset, ok := pagination(w, r)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		filter := repo.OrderFilter{
+			Number: q.Get("number"),
+			Status: repo.OrderStatus(q.Get("status")),
+			Limit:  limit,
+			Offset: offset,
+		}
+		var err error
+		if v := q.Get("from"); v != "" {
+			if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+				writeError(w, r, errBadParameter)
+				return
+			}
+		}
+		if v := q.Get("to"); v != "" {
+			if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+				writeError(w, r, errBadParameter)
+				return
+			}
+		}
+
+		list, err := admin.SearchOrders(r.Context(), s.db, filter)
+		if err != nil {
+			requestLog(r).Error().AnErr("search orders", err).Msg("adminOrderSearch")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, &list, "adminOrderSearch")
+	}
+}
+
+func (s *server) adminBalanceAdjust() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+		id, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		request := struct {
+			Value  float64 `json:"sum" validate:"required"`
+			Reason string  `json:"reason" validate:"required,max=512"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		err := admin.AdjustBalance(r.Context(), s.db, id, request.Value, request.Reason)
+		if err != nil {
+			requestLog(r).Error().AnErr("adjust balance", err).Msg("adminBalanceAdjust")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) adminOrderRepoll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+
+		err := admin.RepollOrder(r.Context(), s.db, chi.URLParam(r, "number"))
+		if err != nil {
+			requestLog(r).Error().AnErr("repoll order", err).Msg("adminOrderRepoll")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *server) adminUserLock(locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+		id, ok := userIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		err := admin.LockUser(r.Context(), s.db, id, locked)
+		if err != nil {
+			requestLog(r).Error().AnErr("lock user", err).Msg("adminUserLock")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) adminAuditList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := adminFromContext(w, r)
+		if !ok {
+			return
+		}
+		limit, offset, ok := pagination(w, r)
+		if !ok {
+			return
+		}
+
+		list, err := admin.AuditList(r.Context(), s.db, limit, offset)
+		if err != nil {
+			requestLog(r).Error().AnErr("audit list", err).Msg("adminAuditList")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, &list, "adminAuditList")
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_Admin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	db := ts.db
+	_, err := db.UserCreate(context.Background(), &repo.User{Username: "admin"})
+	require.NoError(t, err)
+	_, err = db.UserCreate(context.Background(), &repo.User{Username: "user"})
+	require.NoError(t, err)
+	_, err = db.OrderCreate(context.Background(), &repo.Order{Order: "12345678903", Type: repo.CREDIT, UserID: 2, Status: repo.PROCESSED, UploadedAt: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, domain.GrantRole(context.Background(), db, "admin", repo.RoleAdmin))
+
+	adminSession := domain.SessionModel{UserID: 1}
+	_, err = adminSession.Start(context.Background(), db, time.Hour)
+	require.NoError(t, err)
+	userSession := domain.SessionModel{UserID: 2}
+	_, err = userSession.Start(context.Background(), db, time.Hour)
+	require.NoError(t, err)
+
+	_, adminToken, err := ts.auth.Encode("1", map[string]interface{}{"sid": adminSession.ID, "roles": []string{repo.RoleAdmin}}, time.Hour)
+	require.NoError(t, err)
+	_, userToken, err := ts.auth.Encode("2", map[string]interface{}{"sid": userSession.ID}, time.Hour)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{
+			name:   "admin unauthorized",
+			method: "GET",
+			path:   "/api/admin/users",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "admin forbidden for regular user",
+			method: "GET",
+			path:   "/api/admin/users",
+			token:  userToken,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "admin user search",
+			method: "GET",
+			path:   "/api/admin/users?q=us",
+			token:  adminToken,
+			status: http.StatusOK,
+		},
+		{
+			name:   "admin user search page too large",
+			method: "GET",
+			path:   "/api/admin/users?limit=501",
+			token:  adminToken,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "admin user detail not found",
+			method: "GET",
+			path:   "/api/admin/users/42",
+			token:  adminToken,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "admin balance adjust without reason",
+			method: "POST",
+			path:   "/api/admin/users/2/balance",
+			token:  adminToken,
+			body:   `{"sum":10}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "admin balance adjust",
+			method: "POST",
+			path:   "/api/admin/users/2/balance",
+			token:  adminToken,
+			body:   `{"sum":10,"reason":"support ticket"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "admin order search",
+			method: "GET",
+			path:   "/api/admin/orders?status=PROCESSED",
+			token:  adminToken,
+			status: http.StatusOK,
+		},
+		{
+			name:   "admin order repoll",
+			method: "POST",
+			path:   "/api/admin/orders/12345678903/repoll",
+			token:  adminToken,
+			status: http.StatusAccepted,
+		},
+		{
+			name:   "admin order repoll not found",
+			method: "POST",
+			path:   "/api/admin/orders/2377225624/repoll",
+			token:  adminToken,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "admin lock user",
+			method: "POST",
+			path:   "/api/admin/users/2/lock",
+			token:  adminToken,
+			status: http.StatusOK,
+		},
+		{
+			name:   "locked user is rejected",
+			method: "GET",
+			path:   "/api/user/balance",
+			token:  userToken,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "admin unlock user",
+			method: "POST",
+			path:   "/api/admin/users/2/unlock",
+			token:  adminToken,
+			status: http.StatusOK,
+		},
+		{
+			name:   "unlocked user is accepted",
+			method: "GET",
+			path:   "/api/user/balance",
+			token:  userToken,
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cookies []*http.Cookie
+			if tt.token != "" {
+				cookies = []*http.Cookie{{Name: "jwt", Value: tt.token}}
+			}
+			res := ts.do(tt.method, tt.path, tt.body, cookies)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+
+	audit, err := db.AuditGetList(context.Background(), 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, audit, 7, "failed changes are not audited")
+}
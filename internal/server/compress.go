@@ -0,0 +1,314 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize is the smallest response worth compressing; the
+// framing overhead eats the gain below it.
+const minCompressSize = 1024
+
+// zstdMaxWindow is the largest zstd window a request body may use, the
+// limit RFC 9659 sets for the zstd content coding. Decoded bytes are
+// capped separately by maxBodyBytes.
+const zstdMaxWindow = 8 << 20
+
+var compressibleContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/x-javascript",
+	"application/json",
+	"application/problem+json",
+	"application/atom+xml",
+	"application/rss+xml",
+	"image/svg+xml",
+}
+
+// encoder is implemented by the writers of every supported codec so
+// that they can be pooled.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+type codec struct {
+	name      string
+	pool      sync.Pool
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (c *codec) encoder(w io.Writer) encoder {
+	enc := c.pool.Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+// codecs in order of preference when the client rates them equally.
+var codecs = []*codec{
+	{
+		name: "br",
+		pool: sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, brotli.BestSpeed) }},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+	{
+		name: "zstd",
+		pool: sync.Pool{New: func() interface{} {
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+			return enc
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+	{
+		name: "gzip",
+		pool: sync.Pool{New: func() interface{} {
+			enc, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+			return enc
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		// HTTP deflate is the zlib format
+		name: "deflate",
+		pool: sync.Pool{New: func() interface{} {
+			enc, _ := zlib.NewWriterLevel(nil, zlib.BestSpeed)
+			return enc
+		}},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	},
+}
+
+func codecFor(name string) *codec {
+	for _, c := range codecs {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// negotiate picks the codec with the highest quality in an
+// Accept-Encoding header, or nil when none is acceptable.
+func negotiate(accept string) *codec {
+	if accept == "" {
+		return nil
+	}
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(params[len("q="):], 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		quality[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var (
+		best  *codec
+		bestQ float64
+	)
+	for _, c := range codecs {
+		q, ok := quality[c.name]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best
+}
+
+// compressor decodes compressed request bodies, limiting their decoded
+// size to maxBodyBytes, and compresses responses with the codec the
+// client prefers.
+func (s *server) compressor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+			c := codecFor(strings.ToLower(strings.TrimSpace(encoding)))
+			if c == nil {
+				writeError(w, r, errBadEncoding)
+				return
+			}
+			body, err := c.newReader(r.Body)
+			if err != nil {
+				writeError(w, r, errBadEncoding)
+				return
+			}
+			defer body.Close()
+			r.Body = limitBody(w, body, s.maxBodyBytes)
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		c := negotiate(r.Header.Get("Accept-Encoding"))
+		if c == nil || r.Method == http.MethodHead || r.Method == http.MethodOptions || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, codec: c}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds the start of the body back until it knows
+// whether compressing the response pays off: the status allows a body,
+// the content type is compressible and at least minCompressSize bytes
+// are written, or the handler flushes.
+type compressWriter struct {
+	http.ResponseWriter
+	codec *codec
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+	w.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < minCompressSize {
+			return len(b), nil
+		}
+		if err := w.start(w.compressible()); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far; a flushing handler streams,
+// so its response is compressed whatever its size.
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if err := w.start(w.compressible()); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, v := range compressibleContentTypes {
+		if mediaType == v {
+			return true
+		}
+	}
+	return false
+}
+
+// start writes the header and the held back body, compressed or not.
+func (w *compressWriter) start(compress bool) error {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", w.codec.name)
+		h.Del("Content-Length")
+		w.enc = w.codec.encoder(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close finishes the response once the handler returns.
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			// nothing was written; let net/http send its default response
+			return
+		}
+		if err := w.start(false); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		w.codec.pool.Put(w.enc)
+		w.enc = nil
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_negotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "identity", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "gzip, deflate, br, zstd", want: "br"},
+		{accept: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{accept: "br;q=0, gzip;q=0.1", want: "gzip"},
+		{accept: "*", want: "br"},
+		{accept: "*;q=0.5, br;q=0, zstd;q=0", want: "gzip"},
+		{accept: "GZIP", want: "gzip"},
+		{accept: "gzip;q=oops, deflate", want: "deflate"},
+		{accept: "gzip;q=0", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got := ""
+			if c := negotiate(tt.accept); c != nil {
+				got = c.name
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func encode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		enc, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		w = enc
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decode(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	c := codecFor(encoding)
+	require.NotNil(t, c, encoding)
+	r, err := c.newReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return out
+}
+
+func Test_server_compressor_Response(t *testing.T) {
+	large := strings.Repeat(`{"number":"12345678903","status":"PROCESSED"}`, 100)
+	s := &server{maxBodyBytes: 1 << 20}
+
+	tests := []struct {
+		name        string
+		accept      string
+		method      string
+		contentType string
+		status      int
+		body        string
+		encoding    string
+	}{
+		{name: "gzip", accept: "gzip", contentType: "application/json", body: large, encoding: "gzip"},
+		{name: "deflate", accept: "deflate", contentType: "application/json", body: large, encoding: "deflate"},
+		{name: "brotli", accept: "br", contentType: "application/json", body: large, encoding: "br"},
+		{name: "zstd", accept: "zstd", contentType: "application/json", body: large, encoding: "zstd"},
+		{name: "sniffed text", accept: "gzip", body: large, encoding: "gzip"},
+		{name: "no accept encoding", contentType: "application/json", body: large},
+		{name: "small body", accept: "gzip", contentType: "application/json", body: `{"balance":1}`},
+		{name: "not compressible", accept: "gzip", contentType: "image/png", body: large},
+		{name: "no content", accept: "gzip", status: http.StatusNoContent},
+		{name: "head", accept: "gzip", method: http.MethodHead, contentType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := s.compressor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.Header().Set("Content-Length", fmt.Sprint(len(tt.body)))
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// several writes exercise the buffering threshold
+				for i := 0; i < len(tt.body); i += 100 {
+					end := i + 100
+					if end > len(tt.body) {
+						end = len(tt.body)
+					}
+					io.WriteString(w, tt.body[i:end])
+				}
+			}))
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/api/user/orders", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"))
+			if tt.encoding == "" {
+				assert.Equal(t, tt.body, w.Body.String())
+				return
+			}
+			assert.Empty(t, w.Header().Get("Content-Length"))
+			assert.Less(t, w.Body.Len(), len(tt.body))
+			assert.Equal(t, tt.body, string(decode(t, tt.encoding, w.Body.Bytes())))
+		})
+	}
+}
+
+func Test_server_compressor_Flush(t *testing.T) {
+	s := &server{maxBodyBytes: 1 << 20}
+	h := s.compressor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "second")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "firstsecond", string(decode(t, "gzip", w.Body.Bytes())))
+}
+
+func Test_server_compressor_Request(t *testing.T) {
+	s := &server{maxBodyBytes: 1 << 10}
+	echo := s.compressor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := s.decodeText(w, r); ok {
+			io.WriteString(w, body)
+		}
+	}))
+
+	post := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		echo.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			w := post(encoding, encode(t, encoding, []byte("12345678903")))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "12345678903", w.Body.String())
+
+			// a small payload that inflates past the limit
+			bomb := encode(t, encoding, bytes.Repeat([]byte{'0'}, 64<<10))
+			assert.Less(t, len(bomb), 1<<10)
+			w = post(encoding, bomb)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		})
+	}
+
+	t.Run("identity", func(t *testing.T) {
+		w := post("identity", []byte("12345678903"))
+		assert.Equal(t, "12345678903", w.Body.String())
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		w := post("compress", []byte("12345678903"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_encoding")
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		w := post("gzip", []byte("12345678903"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// Test_server_compressor_Concurrent guards against request bodies
+// leaking between concurrent requests; run it with -race.
+func Test_server_compressor_Concurrent(t *testing.T) {
+	s := &server{maxBodyBytes: 1 << 20}
+	echo := s.compressor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.Copy(w, r.Body)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := strings.Repeat(fmt.Sprintf("order %d;", i), 200)
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encode(t, "gzip", []byte(want))))
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			echo.ServeHTTP(w, req)
+			assert.Equal(t, want, string(decode(t, "gzip", w.Body.Bytes())))
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/andrei-cloud/gophermart/internal/health"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+// WithReadinessChecks adds checks of other components to /readyz.
+func (s *server) WithReadinessChecks(checks ...health.Check) *server {
+	s.health.Add(checks...)
+	return s
+}
+
+// Drain fails /readyz from now on so that traffic moves away before
+// the server shuts down.
+func (s *server) Drain() {
+	s.health.Drain()
+}
+
+func (s *server) repositoryChecks() []health.Check {
+	return []health.Check{
+		{Name: "database", Critical: true, Run: func(ctx context.Context) (map[string]string, error) {
+			return nil, s.db.Ping(ctx)
+		}},
+		{Name: "migrations", Critical: true, Run: func(ctx context.Context) (map[string]string, error) {
+			version, err := s.db.SchemaVersion(ctx)
+			if err != nil {
+				return nil, err
+			}
+			details := map[string]string{
+				"version":  fmt.Sprint(version),
+				"expected": fmt.Sprint(repo.SchemaVersion),
+			}
+			if version != repo.SchemaVersion {
+				return details, fmt.Errorf("schema version %d, expected %d", version, repo.SchemaVersion)
+			}
+			return details, nil
+		}},
+	}
+}
+
+// healthz answers as long as the process serves requests.
+func (s *server) healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, r, struct {
+			Status string `json:"status"`
+		}{health.StatusOK}, "healthz")
+	}
+}
+
+func (s *server) readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := s.health.Run(r.Context())
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		err := json.NewEncoder(w).Encode(report)
+		if err != nil {
+			requestLog(r).Error().AnErr("encoding response", err).Msg("readyz")
+		}
+	}
+}
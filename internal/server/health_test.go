@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/health"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newerSchema is a database migrated by a later build.
+type newerSchema struct{ repo.Repository }
+
+func (newerSchema) SchemaVersion(context.Context) (int, error) { return repo.SchemaVersion + 1, nil }
+
+// hungDB never answers a ping before the caller gives up.
+type hungDB struct{ repo.Repository }
+
+func (hungDB) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func Test_server_readyz(t *testing.T) {
+	ready := func(s *server) (int, health.Report) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		var report health.Report
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		return w.Code, report
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		s := NewServer(config.GetConfig())
+		s.WithDB(inmem.NewInMemRepo()).SetupRoutes()
+
+		code, report := ready(s)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusOK, report.Status)
+		assert.Equal(t, health.StatusOK, report.Checks["database"].Status)
+		assert.Equal(t, health.StatusOK, report.Checks["migrations"].Status)
+	})
+
+	t.Run("schema is behind", func(t *testing.T) {
+		s := NewServer(config.GetConfig())
+		s.WithDB(newerSchema{inmem.NewInMemRepo()}).SetupRoutes()
+
+		code, report := ready(s)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusFail, report.Status)
+		assert.Equal(t, health.StatusFail, report.Checks["migrations"].Status)
+		assert.NotEmpty(t, report.Checks["migrations"].Details["expected"])
+	})
+
+	t.Run("database hangs", func(t *testing.T) {
+		cfg := *config.GetConfig()
+		cfg.ReadinessCheckTimeout = 50 * time.Millisecond
+		s := NewServer(&cfg)
+		s.WithDB(hungDB{inmem.NewInMemRepo()}).SetupRoutes()
+
+		start := time.Now()
+		code, report := ready(s)
+		assert.Less(t, time.Since(start), 5*time.Second, "the check timeout bounds the ping")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusFail, report.Checks["database"].Status)
+	})
+
+	t.Run("degraded", func(t *testing.T) {
+		s := NewServer(config.GetConfig())
+		s.WithDB(inmem.NewInMemRepo()).WithReadinessChecks(health.Check{
+			Name: "accrual",
+			Run: func(context.Context) (map[string]string, error) {
+				return nil, errors.New("connection refused")
+			},
+		}).SetupRoutes()
+
+		code, report := ready(s)
+		assert.Equal(t, http.StatusOK, code, "non-critical checks keep the instance in rotation")
+		assert.Equal(t, health.StatusDegraded, report.Status)
+		assert.Equal(t, "connection refused", report.Checks["accrual"].Error)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		s := NewServer(config.GetConfig())
+		s.WithDB(inmem.NewInMemRepo()).SetupRoutes()
+		s.Drain()
+
+		code, report := ready(s)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusDraining, report.Status)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "still alive")
+	})
+}
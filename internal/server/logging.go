@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/requestid"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestLogger assigns every request an ID, honouring a well-formed
+// incoming X-Request-ID, and stores a logger carrying it in the request
+// context. The ID is echoed in the response and an access log line with
+// status and latency is written once the handler returns.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestid.Sanitize(r.Header.Get(requestid.Header))
+		w.Header().Set(requestid.Header, id)
+
+		logger := log.With().Str("request_id", id).Logger()
+		ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+		r = r.WithContext(logger.WithContext(ctx))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			event := requestLog(r).Info()
+			if status >= http.StatusInternalServerError {
+				event = requestLog(r).Error()
+			}
+			event.
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Int("status", status).
+				Int("bytes", ww.BytesWritten()).
+				Dur("latency", time.Since(start)).
+				Str("ip", clientIP(r)).
+				Msg("request")
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// requestLog returns the logger of the request with the matched route,
+// falling back to the global logger outside RequestLogger.
+func requestLog(r *http.Request) *zerolog.Logger {
+	logger := zerolog.Ctx(r.Context())
+	if logger.GetLevel() == zerolog.Disabled {
+		logger = &log.Logger
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			withRoute := logger.With().Str("route", route).Logger()
+			return &withRoute
+		}
+	}
+	return logger
+}
+
+// logUser adds the authenticated user to the request logger so that
+// later lines, including the access log, carry it.
+func logUser(r *http.Request, p *Principal) {
+	zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Int64("user_id", p.UserID)
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	repo "github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/andrei-cloud/gophermart/internal/requestid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_RequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	global := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = global }()
+
+	db := repo.NewInMemRepo()
+	s := NewServer(config.GetConfig())
+	s.WithDB(db).SetupRoutes()
+
+	do := func(method, path, contentType, body, id string, cookies []*http.Cookie) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if id != "" {
+			req.Header.Set(requestid.Header, id)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Result()
+	}
+	accessLog := func(id string) map[string]interface{} {
+		scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+		for scanner.Scan() {
+			var line map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			if line["request_id"] == id && line["message"] == "request" {
+				return line
+			}
+		}
+		t.Fatalf("no access log for %s in %s", id, buf.String())
+		return nil
+	}
+
+	res := do("POST", "/api/user/register", "application/json", `{"login":"alice","password":"1234"}`, "", nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	cookies := res.Cookies()
+	assert.Len(t, res.Header.Get(requestid.Header), 32, "generated when missing")
+
+	t.Run("incoming ID is honoured", func(t *testing.T) {
+		res := do("POST", "/api/user/orders", "text/plain", "12345678903", "upload-1", cookies)
+		res.Body.Close()
+		require.Equal(t, http.StatusAccepted, res.StatusCode)
+		assert.Equal(t, "upload-1", res.Header.Get(requestid.Header))
+
+		line := accessLog("upload-1")
+		assert.Equal(t, float64(1), line["user_id"])
+		assert.Equal(t, "/api/user/orders", line["route"])
+		assert.Equal(t, float64(http.StatusAccepted), line["status"])
+		assert.Contains(t, line, "latency")
+
+		orders, err := db.OrderToProcess(context.Background())
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		assert.Equal(t, "upload-1", orders[0].RequestID, "worker picks the ID up")
+	})
+
+	t.Run("malformed ID is replaced", func(t *testing.T) {
+		res := do("GET", "/api/user/balance", "", "", "bad id", cookies)
+		res.Body.Close()
+		id := res.Header.Get(requestid.Header)
+		assert.Len(t, id, 32)
+		assert.Equal(t, "/api/user/balance", accessLog(id)["route"])
+	})
+
+	t.Run("problem carries the ID", func(t *testing.T) {
+		res := do("GET", "/api/user/balance", "", "", "anon-1", nil)
+		defer res.Body.Close()
+		var p problem
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+		assert.Equal(t, "anon-1", p.RequestID)
+		assert.NotContains(t, accessLog("anon-1"), "user_id")
+	})
+}
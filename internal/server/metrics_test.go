@@ -0,0 +1,43 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	repo "github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_Metrics(t *testing.T) {
+	s := NewServer(config.GetConfig())
+	s.WithDB(repo.NewInMemRepo()).SetupRoutes()
+
+	do := func(method, path, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	do("POST", "/api/user/register", `{"login":"alice","password":"1234"}`).Body.Close()
+	do("GET", "/api/admin/users/7", "").Body.Close()
+	do("GET", "/no/such/path", "").Body.Close()
+
+	res := do("GET", "/metrics", "")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	body := string(b)
+
+	assert.Contains(t, body, `gophermart_http_requests_total{method="POST",route="/api/user/register",status="200"} 1`)
+	assert.Contains(t, body, `gophermart_http_requests_total{method="GET",route="/api/admin/*",status="401"} 1`, "labelled by pattern, not path")
+	assert.Contains(t, body, `gophermart_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `gophermart_http_request_duration_seconds_count{method="POST",route="/api/user/register",status="200"} 1`)
+}
@@ -1,89 +1,37 @@
 package server
 
 import (
-	"bytes"
-	"compress/gzip"
-	"io"
+	"errors"
 	"net/http"
-	"strings"
-)
-
-var compressibleContentTypes = []string{
-	"text/html",
-	"text/css",
-	"text/plain",
-	"text/javascript",
-	"application/javascript",
-	"application/x-javascript",
-	"application/json",
-	"application/atom+xml",
-	"application/rss+xml",
-	"image/svg+xml",
-}
-
-type gzipWriter struct {
-	http.ResponseWriter
-	Writer io.Writer
-}
 
-func (w gzipWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
 
-func Compressor(next http.Handler) http.Handler {
-	var b bytes.Buffer
+// activeUser rejects requests of users locked by an operator and of
+// users that no longer exist.
+func (s *server) activeUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
-			if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch || isComressible(r) {
-				var err error
-
-				gzr, err := gzip.NewReader(r.Body)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				defer gzr.Close()
-
-				_, err = b.ReadFrom(gzr)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				r.Body = io.NopCloser(&b)
-			}
+		p, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
 		}
 
-		if !shouldCompress(r) {
-			next.ServeHTTP(w, r)
+		user, err := s.db.UserGetByID(r.Context(), p.UserID)
+		if errors.Is(err, repo.ErrNotExists) || (err == nil && user.Deleted) {
+			writeError(w, r, errUnauthenticated)
 			return
 		}
-
-		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 		if err != nil {
-			io.WriteString(w, err.Error())
+			requestLog(r).Error().AnErr("get user", err).Msg("activeUser")
+			writeError(w, r, err)
 			return
 		}
-		defer gz.Close()
-
-		w.Header().Set("Content-Encoding", "gzip")
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
-	})
-}
-
-func shouldCompress(r *http.Request) bool {
-	return r.Method != http.MethodHead &&
-		r.Method != http.MethodOptions &&
-		r.Header.Get("Upgrade") == "" &&
-		strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
-}
-
-func isComressible(r *http.Request) (result bool) {
-	result = false
-	for _, v := range compressibleContentTypes {
-		if result = strings.Contains(r.Header.Get("Content-Type"), v); result {
-			break
+		if user.Locked {
+			writeError(w, r, domain.ErrUserLocked)
+			return
 		}
-	}
-	return result
+		next.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyUserRepo fails to load users while fail is set.
+type flakyUserRepo struct {
+	repo.Repository
+	fail bool
+}
+
+func (r *flakyUserRepo) UserGetByID(ctx context.Context, id int64) (*repo.User, error) {
+	if r.fail {
+		return nil, repo.ErrUnavailable
+	}
+	return r.Repository.UserGetByID(ctx, id)
+}
+
+func Test_server_activeUser(t *testing.T) {
+	db := &flakyUserRepo{Repository: inmem.NewInMemRepo()}
+	ts := &testServer{server: NewServer(config.GetConfig()), db: db, t: t}
+	ts.WithDB(db).SetupRoutes()
+	alice := ts.register("alice")
+
+	tests := []struct {
+		name   string
+		fail   bool
+		status int
+	}{
+		{
+			name:   "active user",
+			status: http.StatusOK,
+		},
+		{
+			name:   "storage failure is not an authentication failure",
+			fail:   true,
+			status: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.fail = tt.fail
+			res := ts.do("GET", "/api/user/balance", "", alice)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIDocument describes every route of SetupRoutes. The contract
+// tests fail when handlers drift from it.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+func (s *server) openAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPIDocument)
+	}
+}
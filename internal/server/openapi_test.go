@@ -0,0 +1,424 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	repoModel "github.com/andrei-cloud/gophermart/internal/repo"
+	repo "github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/andrei-cloud/gophermart/pkg/totp"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The contract tests parse just the parts of OpenAPI 3 the document
+// uses: paths, response codes, media types and JSON schemas.
+type openAPISpec struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*jsonSchema     `json:"schemas"`
+		Responses map[string]openAPIResponse `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *jsonSchema `json:"schema"`
+	} `json:"content"`
+}
+
+type jsonSchema struct {
+	Ref        string                 `json:"$ref"`
+	Type       string                 `json:"type"`
+	Format     string                 `json:"format"`
+	Enum       []string               `json:"enum"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+
+	AdditionalProperties *jsonSchema `json:"additionalProperties"`
+}
+
+var pathParam = regexp.MustCompile(`\{[^/]+\}`)
+
+type contract struct {
+	spec     openAPISpec
+	patterns map[string]*regexp.Regexp
+	covered  map[string]bool
+}
+
+func newContract(t *testing.T, doc []byte) *contract {
+	c := &contract{patterns: make(map[string]*regexp.Regexp), covered: make(map[string]bool)}
+	require.NoError(t, json.Unmarshal(doc, &c.spec))
+	for path := range c.spec.Paths {
+		c.patterns[path] = regexp.MustCompile("^" + pathParam.ReplaceAllString(path, "[^/]+") + "$")
+	}
+	return c
+}
+
+func (c *contract) operation(method, path string) (string, *openAPIOperation) {
+	for template, re := range c.patterns {
+		if !re.MatchString(path) {
+			continue
+		}
+		if op, ok := c.spec.Paths[template][strings.ToLower(method)]; ok {
+			return template, &op
+		}
+	}
+	return "", nil
+}
+
+// check fails the test when the response status, media type or body
+// are not described for the operation handling method and path.
+func (c *contract) check(t *testing.T, method, path string, status int, header http.Header, body []byte) {
+	t.Helper()
+	path = strings.SplitN(path, "?", 2)[0]
+	template, op := c.operation(method, path)
+	if !assert.NotNil(t, op, "%s %s is not documented", method, path) {
+		return
+	}
+	c.covered[strings.ToUpper(method)+" "+template] = true
+
+	res, ok := op.Responses[fmt.Sprint(status)]
+	if !assert.True(t, ok, "%s %s: status %d is not documented", method, template, status) {
+		return
+	}
+	if res.Ref != "" {
+		res = c.spec.Components.Responses[strings.TrimPrefix(res.Ref, "#/components/responses/")]
+	}
+
+	if len(res.Content) == 0 {
+		assert.Empty(t, body, "%s %s: %d has no documented body", method, template, status)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	media, ok := res.Content[mediaType]
+	if !assert.True(t, ok, "%s %s: %d media type %q is not documented", method, template, status, mediaType) {
+		return
+	}
+	if !strings.HasSuffix(mediaType, "json") {
+		assert.NotEmpty(t, body, "%s %s: %d body", method, template, status)
+		return
+	}
+	var v interface{}
+	if !assert.NoError(t, json.Unmarshal(body, &v), "%s %s: %d body", method, template, status) {
+		return
+	}
+	assert.NoError(t, c.validate(media.Schema, v, "body"), "%s %s: %d", method, template, status)
+}
+
+// validate checks v against s. Objects with declared properties must
+// not carry undocumented ones.
+func (c *contract) validate(s *jsonSchema, v interface{}, at string) error {
+	if s.Ref != "" {
+		return c.validate(c.spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], v, at)
+	}
+
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want object, got %T", at, v)
+		}
+		for _, name := range s.Required {
+			if _, ok := m[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", at, name)
+			}
+		}
+		if s.AdditionalProperties != nil {
+			for name, value := range m {
+				if err := c.validate(s.AdditionalProperties, value, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+		if len(s.Properties) == 0 {
+			return nil
+		}
+		for name, value := range m {
+			prop, ok := s.Properties[name]
+			if !ok {
+				return fmt.Errorf("%s: undocumented property %q", at, name)
+			}
+			if err := c.validate(prop, value, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: want array, got %T", at, v)
+		}
+		for i, item := range list {
+			if err := c.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: want string, got %T", at, v)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, str)
+			}
+		}
+		if len(s.Enum) > 0 {
+			for _, e := range s.Enum {
+				if e == str {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: %q is not one of %v", at, str, s.Enum)
+		}
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("%s: want %s, got %T", at, s.Type, v)
+		}
+		if s.Type == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s: %v is not an integer", at, n)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: want boolean, got %T", at, v)
+		}
+	}
+	return nil
+}
+
+func Test_server_OpenAPIRoutes(t *testing.T) {
+	s := NewServer(config.GetConfig())
+	s.WithDB(repo.NewInMemRepo()).SetupRoutes()
+	c := newContract(t, openAPIDocument)
+
+	routes := make(map[string]bool)
+	err := chi.Walk(s.router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes[method+" "+strings.TrimSuffix(route, "/")] = true
+		return nil
+	})
+	require.NoError(t, err)
+
+	for route := range routes {
+		parts := strings.SplitN(route, " ", 2)
+		_, ok := c.spec.Paths[parts[1]][strings.ToLower(parts[0])]
+		assert.True(t, ok, "route %s is not documented", route)
+	}
+	for path, ops := range c.spec.Paths {
+		for method := range ops {
+			assert.True(t, routes[strings.ToUpper(method)+" "+path], "documented %s %s is not routed", method, path)
+		}
+	}
+}
+
+func Test_server_OpenAPIContract(t *testing.T) {
+	db := repo.NewInMemRepo()
+	s := NewServer(config.GetConfig())
+	s.rateLimits = rateLimits{}
+	s.loginGuard = domain.LoginGuard{ChallengeTTL: time.Minute}
+	s.twoFactor = domain.TwoFactor{Issuer: "Gophermart", WithdrawalThreshold: 100}
+	s.WithDB(db).SetupRoutes()
+
+	res := httptest.NewRecorder()
+	s.ServeHTTP(res, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	c := newContract(t, res.Body.Bytes())
+	c.check(t, "GET", "/api/openapi.json", res.Code, res.Header(), res.Body.Bytes())
+
+	for _, path := range []string{"/metrics", "/healthz", "/readyz"} {
+		res = httptest.NewRecorder()
+		s.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		c.check(t, "GET", path, res.Code, res.Header(), res.Body.Bytes())
+	}
+
+	type response struct {
+		status  int
+		body    []byte
+		cookies []*http.Cookie
+	}
+	call := func(method, path, contentType, body string, cookies []*http.Cookie) response {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		c.check(t, method, path, res.StatusCode, res.Header, b)
+		return response{res.StatusCode, b, res.Cookies()}
+	}
+	do := func(method, path, body string, cookies []*http.Cookie, want int) response {
+		t.Helper()
+		contentType := ""
+		if body != "" {
+			contentType = "application/json"
+		}
+		res := call(method, path, contentType, body, cookies)
+		require.Equal(t, want, res.status, "%s %s: %s", method, path, res.body)
+		return res
+	}
+	cookie := func(cookies []*http.Cookie, name string) []*http.Cookie {
+		for _, c := range cookies {
+			if c.Name == name {
+				return []*http.Cookie{c}
+			}
+		}
+		return nil
+	}
+
+	alice := do("POST", "/api/user/register", `{"login":"alice","password":"1234"}`, nil, http.StatusOK).cookies
+	bob := do("POST", "/api/user/register", `{"login":"bob","password":"1234"}`, nil, http.StatusOK).cookies
+	do("POST", "/api/user/register", `{"login":"alice","password":"1234"}`, nil, http.StatusConflict)
+	do("POST", "/api/user/register", `{"login":`, nil, http.StatusBadRequest)
+	do("POST", "/api/user/register", `{"login":"carol"}`, nil, http.StatusBadRequest)
+	do("POST", "/api/user/register", `{"login":"admin","password":"1234"}`, nil, http.StatusOK)
+	require.NoError(t, domain.GrantRole(context.Background(), db, "admin", repoModel.RoleAdmin))
+	admin := do("POST", "/api/user/login", `{"login":"admin","password":"1234"}`, nil, http.StatusOK).cookies
+	do("POST", "/api/user/login", `{"login":"alice","password":"wrong"}`, nil, http.StatusUnauthorized)
+
+	t.Run("orders", func(t *testing.T) {
+		order := func(number string, cookies []*http.Cookie, want int) {
+			res := call("POST", "/api/user/orders", "text/plain", number, cookies)
+			require.Equal(t, want, res.status, string(res.body))
+		}
+		order("12345678903", alice, http.StatusAccepted)
+		order("12345678903", alice, http.StatusOK)
+		order("12345678903", bob, http.StatusConflict)
+		order("12345678904", alice, http.StatusUnprocessableEntity)
+		order("12345678903", nil, http.StatusUnauthorized)
+		do("GET", "/api/user/orders", "", alice, http.StatusOK)
+		do("GET", "/api/user/orders", "", bob, http.StatusNoContent)
+	})
+
+	t.Run("balance", func(t *testing.T) {
+		do("GET", "/api/user/balance", "", alice, http.StatusOK)
+		do("POST", "/api/user/balance/withdraw", `{"order":"2377225624","sum":50}`, alice, http.StatusPaymentRequired)
+		require.NoError(t, db.UserAdjust(context.Background(), &repoModel.Adjustment{UserID: 1, Value: 500, Reason: "opening balance", CreatedAt: time.Now()}, nil))
+		do("POST", "/api/user/balance/withdraw", `{"order":"2377225624","sum":50}`, alice, http.StatusOK)
+		do("POST", "/api/user/balance/withdraw", `{"order":"2377225624","sum":50}`, alice, http.StatusUnprocessableEntity)
+		do("POST", "/api/user/balance/withdraw", `{"order":"2377225624","sum":-1}`, alice, http.StatusBadRequest)
+		do("GET", "/api/user/withdrawals", "", alice, http.StatusOK)
+		do("GET", "/api/user/withdrawals", "", bob, http.StatusNoContent)
+
+		do("POST", "/api/user/balance/transfer", `{"login":"bob","sum":10,"memo":"lunch"}`, alice, http.StatusOK)
+		do("POST", "/api/user/balance/transfer", `{"login":"nobody","sum":10}`, alice, http.StatusNotFound)
+		do("POST", "/api/user/balance/transfer", `{"login":"bob","sum":100000}`, alice, http.StatusUnprocessableEntity)
+		do("GET", "/api/user/transfers", "", alice, http.StatusOK)
+		do("GET", "/api/user/transfers", "", admin, http.StatusNoContent)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		second := do("POST", "/api/user/login", `{"login":"alice","password":"1234"}`, nil, http.StatusOK).cookies
+		var sessions []domain.SessionModel
+		require.NoError(t, json.Unmarshal(do("GET", "/api/user/sessions", "", alice, http.StatusOK).body, &sessions))
+		require.Len(t, sessions, 2)
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+		do("DELETE", fmt.Sprintf("/api/user/sessions/%d", sessions[1].ID), "", alice, http.StatusOK)
+		do("GET", "/api/user/balance", "", second, http.StatusUnauthorized)
+		do("DELETE", "/api/user/sessions/x", "", alice, http.StatusBadRequest)
+		do("DELETE", "/api/user/sessions/999", "", alice, http.StatusNotFound)
+		do("DELETE", "/api/user/sessions", "", alice, http.StatusOK)
+
+		alice = do("POST", "/api/user/refresh", "", cookie(alice, "refresh"), http.StatusOK).cookies
+		do("POST", "/api/user/refresh", "", nil, http.StatusUnauthorized)
+	})
+
+	t.Run("account", func(t *testing.T) {
+		do("GET", "/api/user/export", "", alice, http.StatusOK)
+		do("POST", "/api/user/password/reset", `{"login":"alice"}`, nil, http.StatusAccepted)
+		do("POST", "/api/user/password/reset/confirm", `{"token":"x","new_password":"5678"}`, nil, http.StatusBadRequest)
+		do("PUT", "/api/user/password", `{"current_password":"wrong","new_password":"5678"}`, alice, http.StatusForbidden)
+		do("PUT", "/api/user/password", `{"current_password":"1234","new_password":"5678"}`, alice, http.StatusOK)
+	})
+
+	t.Run("two-factor", func(t *testing.T) {
+		do("GET", "/api/user/2fa", "", alice, http.StatusOK)
+		var setup domain.TwoFactorSetup
+		require.NoError(t, json.Unmarshal(do("POST", "/api/user/2fa/setup", "", alice, http.StatusOK).body, &setup))
+		do("POST", "/api/user/2fa/confirm", `{"code":"000000x"}`, alice, http.StatusForbidden)
+		code, err := totp.Code(setup.Secret, totp.Step(time.Now()))
+		require.NoError(t, err)
+		var confirm struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		require.NoError(t, json.Unmarshal(do("POST", "/api/user/2fa/confirm", `{"code":"`+code+`"}`, alice, http.StatusOK).body, &confirm))
+		do("POST", "/api/user/2fa/setup", "", alice, http.StatusConflict)
+
+		var challenge struct {
+			Challenge string `json:"challenge"`
+		}
+		require.NoError(t, json.Unmarshal(do("POST", "/api/user/login", `{"login":"alice","password":"5678"}`, nil, http.StatusAccepted).body, &challenge))
+		do("POST", "/api/user/login/2fa", `{"challenge":"`+challenge.Challenge+`","code":"`+confirm.RecoveryCodes[0]+`"}`, nil, http.StatusOK)
+		do("POST", "/api/user/login/2fa", `{"challenge":"`+challenge.Challenge+`","code":"`+confirm.RecoveryCodes[1]+`"}`, nil, http.StatusUnauthorized)
+		do("POST", "/api/user/login/2fa", `{"challenge":"x"}`, nil, http.StatusBadRequest)
+
+		do("POST", "/api/user/balance/withdraw", `{"order":"49927398716","sum":200}`, alice, http.StatusForbidden)
+		do("POST", "/api/user/2fa/disable", `{"password":"5678","code":"`+confirm.RecoveryCodes[1]+`"}`, alice, http.StatusNoContent)
+		do("POST", "/api/user/2fa/disable", `{"password":"5678","code":"`+confirm.RecoveryCodes[2]+`"}`, alice, http.StatusConflict)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		do("GET", "/api/admin/users", "", alice, http.StatusForbidden)
+		do("GET", "/api/admin/users?q=a", "", admin, http.StatusOK)
+		do("GET", "/api/admin/users?limit=x", "", admin, http.StatusBadRequest)
+		do("GET", "/api/admin/users/1", "", admin, http.StatusOK)
+		do("GET", "/api/admin/users/999", "", admin, http.StatusNotFound)
+		do("POST", "/api/admin/users/2/balance", `{"sum":10,"reason":"bonus"}`, admin, http.StatusOK)
+		do("POST", "/api/admin/users/2/balance", `{"sum":-100000,"reason":"fix"}`, admin, http.StatusPaymentRequired)
+		do("POST", "/api/admin/users/2/balance", `{"sum":10}`, admin, http.StatusBadRequest)
+		do("POST", "/api/admin/users/2/lock", "", admin, http.StatusOK)
+		do("POST", "/api/admin/users/2/unlock", "", admin, http.StatusOK)
+		do("POST", "/api/admin/users/999/lock", "", admin, http.StatusNotFound)
+		do("GET", "/api/admin/orders?status=NEW", "", admin, http.StatusOK)
+		do("GET", "/api/admin/orders?from=yesterday", "", admin, http.StatusBadRequest)
+		do("POST", "/api/admin/orders/12345678903/repoll", "", admin, http.StatusAccepted)
+		do("POST", "/api/admin/orders/0/repoll", "", admin, http.StatusNotFound)
+		do("GET", "/api/admin/audit", "", admin, http.StatusOK)
+	})
+
+	t.Run("logout and delete", func(t *testing.T) {
+		do("POST", "/api/user/logout", "", alice, http.StatusOK)
+		do("DELETE", "/api/user", `{"password":"wrong"}`, bob, http.StatusForbidden)
+		do("DELETE", "/api/user", `{"password":"1234"}`, bob, http.StatusNoContent)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		do("GET", "/readyz", "", nil, http.StatusOK)
+		s.Drain()
+		do("GET", "/readyz", "", nil, http.StatusServiceUnavailable)
+		do("GET", "/healthz", "", nil, http.StatusOK)
+	})
+
+	for path, ops := range c.spec.Paths {
+		for method := range ops {
+			assert.True(t, c.covered[strings.ToUpper(method)+" "+path], "%s %s is not exercised", method, path)
+		}
+	}
+}
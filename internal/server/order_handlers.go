@@ -3,66 +3,48 @@ package server
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 
 	"github.com/andrei-cloud/gophermart/internal/domain"
 	repo "github.com/andrei-cloud/gophermart/internal/repo"
-	"github.com/andrei-cloud/gophermart/pkg/utils"
-	"github.com/go-chi/jwtauth/v5"
-	"github.com/rs/zerolog/log"
+	"github.com/go-chi/chi/middleware"
 )
 
 func (s *server) userAddOrder() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !isValidType(w, r, "text/plain") {
-			return
-		}
-
-		_, claims, err := jwtauth.FromContext(r.Context())
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
 			return
 		}
 
-		userID, ok := claims["userId"].(float64)
+		number, ok := s.decodeText(w, r)
 		if !ok {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		order := domain.OrderModel{}
-		b, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			log.Error().AnErr("reading body", err).Msg("userAddOrder")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		request := struct {
+			Number string `json:"number" validate:"required,luhn"`
+		}{Number: number}
+		if !s.validate(w, r, &request) {
 			return
 		}
-		defer r.Body.Close()
 
-		order.Number = string(b)
-		if !utils.IsValidLuhn(order.Number) {
-			log.Error().AnErr("isvalid luhn number", fmt.Errorf("invalid luhn")).Msg("userAddOrder")
-			http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
-			return
+		order := domain.OrderModel{
+			UserID:    principal.UserID,
+			Number:    request.Number,
+			RequestID: middleware.GetReqID(r.Context()),
 		}
 
-		order.UserID = int64(userID)
-
-		err = order.Register(s.db)
+		err := order.Register(r.Context(), s.db)
 		if err != nil {
-			log.Error().AnErr("register", err).Msg("userAddOrder")
-			if errors.Is(err, domain.ErrDontMatch) {
-				http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
-				return
-			} else if errors.Is(err, repo.ErrAlreadyExists) {
-				http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
-				return
-			} else {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			if errors.Is(err, repo.ErrAlreadyExists) {
+				w.WriteHeader(http.StatusOK)
 				return
 			}
+			requestLog(r).Error().AnErr("register", err).Msg("userAddOrder")
+			writeError(w, r, err)
+			return
 		}
 
 		w.WriteHeader(http.StatusAccepted)
@@ -71,24 +53,18 @@ func (s *server) userAddOrder() http.HandlerFunc {
 
 func (s *server) userOrderList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, claims, err := jwtauth.FromContext(r.Context())
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-
-		userID, ok := claims["userId"].(float64)
+		principal, ok := PrincipalFromContext(r.Context())
 		if !ok {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, r, errUnauthenticated)
 			return
 		}
 		order := domain.OrderModel{
-			UserID: int64(userID),
+			UserID: principal.UserID,
 		}
-		list, err := order.CreditList(s.db)
+		list, err := order.CreditList(r.Context(), s.db)
 		if err != nil {
-			log.Error().AnErr("credit list", err).Msg("userOrderList")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("credit list", err).Msg("userOrderList")
+			writeError(w, r, err)
 			return
 		}
 		if len(list) == 0 {
@@ -97,8 +73,8 @@ func (s *server) userOrderList() http.HandlerFunc {
 			w.Header().Set("Content-Type", "application/json")
 			err = json.NewEncoder(w).Encode(&list)
 			if err != nil {
-				log.Error().AnErr("encoding response", err).Msg("userOrderList")
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				requestLog(r).Error().AnErr("encoding response", err).Msg("userOrderList")
+				writeError(w, r, err)
 				return
 			}
 		}
@@ -106,65 +82,42 @@ func (s *server) userOrderList() http.HandlerFunc {
 }
 
 func (s *server) userWithdraw() http.HandlerFunc {
-	request := struct {
-		userID int64
-		Order  string  `json:"order"`
-		Value  float64 `json:"sum"`
-	}{}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var err error
-		if !isValidType(w, r, "application/json") {
-			return
-		}
-
-		_, claims, err := jwtauth.FromContext(r.Context())
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-
-		userID, ok := claims["userId"].(float64)
+		principal, ok := PrincipalFromContext(r.Context())
 		if !ok {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, r, errUnauthenticated)
 			return
 		}
 
-		request.userID = int64(userID)
-
-		err = json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
-			log.Error().AnErr("decoding request body", err).Msg("userWithdraw")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		request := struct {
+			Order string  `json:"order" validate:"required,luhn"`
+			Value float64 `json:"sum" validate:"gt=0"`
+			OTP   string  `json:"otp"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
 			return
 		}
-		defer r.Body.Close()
 
-		if !utils.IsValidLuhn(request.Order) {
-			log.Error().AnErr("isvalid luhn number", fmt.Errorf("invalid luhn")).Msg("userWithdraw")
-			http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		err := s.loginGuard.CheckWithdrawal(r.Context(), s.db, &s.twoFactor, principal.UserID, request.Value, request.OTP, clientIP(r))
+		if err != nil {
+			requestLog(r).Error().AnErr("check one-time code", err).Msg("userWithdraw")
+			writeError(w, r, err)
 			return
 		}
 
 		order := domain.OrderModel{
-			UserID: request.userID,
+			UserID: principal.UserID,
 			Number: request.Order,
 			Value:  request.Value,
 		}
 
-		err = order.Withdraw(s.db)
+		err = order.Withdraw(r.Context(), s.db)
 		if err != nil {
-			log.Error().AnErr("withdraw", err).Msg("userWithdraw")
-			if errors.Is(err, domain.ErrIsufficientFunds) {
-				http.Error(w, http.StatusText(http.StatusPaymentRequired), http.StatusPaymentRequired)
-				return
-			} else if errors.Is(err, repo.ErrAlreadyExists) {
-				http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
-				return
-			} else {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
+			requestLog(r).Error().AnErr("withdraw", err).Msg("userWithdraw")
+			writeError(w, r, err)
+			return
 		}
+		s.metrics.AddWithdrawn(request.Value)
 
 		w.WriteHeader(http.StatusOK)
 	}
@@ -172,26 +125,20 @@ func (s *server) userWithdraw() http.HandlerFunc {
 
 func (s *server) userWithdrawalList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, claims, err := jwtauth.FromContext(r.Context())
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-
-		userID, ok := claims["userId"].(float64)
+		principal, ok := PrincipalFromContext(r.Context())
 		if !ok {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, r, errUnauthenticated)
 			return
 		}
 
 		order := domain.OrderModel{
-			UserID: int64(userID),
+			UserID: principal.UserID,
 		}
 
-		list, err := order.DebitList(s.db)
+		list, err := order.DebitList(r.Context(), s.db)
 		if err != nil {
-			log.Error().AnErr("debit list", err).Msg("userWithdrawalList")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("debit list", err).Msg("userWithdrawalList")
+			writeError(w, r, err)
 			return
 		}
 		if len(list) == 0 {
@@ -200,8 +147,8 @@ func (s *server) userWithdrawalList() http.HandlerFunc {
 			w.Header().Set("Content-Type", "application/json")
 			err = json.NewEncoder(w).Encode(&list)
 			if err != nil {
-				log.Error().AnErr("encoding response", err).Msg("userWithdrawalList")
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				requestLog(r).Error().AnErr("encoding response", err).Msg("userWithdrawalList")
+				writeError(w, r, err)
 				return
 			}
 		}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+)
+
+func (s *server) userPasswordChange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		request := struct {
+			Current string `json:"current_password" validate:"required"`
+			New     string `json:"new_password" validate:"required,maxbytes=72"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		user := domain.UserModel{ID: principal.UserID}
+		err := s.loginGuard.ChangePassword(r.Context(), s.db, s.passwords, &user, request.Current, request.New,
+			principal.SessionID, clientIP(r))
+		if err != nil {
+			requestLog(r).Error().AnErr("change password", err).Msg("userPasswordChange")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) userPasswordResetRequest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			Login string `json:"login" validate:"required,max=64"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		err := s.passwordReset.Request(r.Context(), s.db, request.Login)
+		if err != nil {
+			requestLog(r).Error().AnErr("request reset", err).Msg("userPasswordResetRequest")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *server) userPasswordResetConfirm() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			Token string `json:"token" validate:"required"`
+			New   string `json:"new_password" validate:"required,maxbytes=72"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		err := s.passwordReset.Confirm(r.Context(), s.db, s.passwords, request.Token, request.New)
+		if err != nil {
+			requestLog(r).Error().AnErr("confirm reset", err).Msg("userPasswordResetConfirm")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureNotifier struct {
+	messages []notify.Message
+}
+
+func (n *captureNotifier) Notify(m notify.Message) error {
+	n.messages = append(n.messages, m)
+	return nil
+}
+
+func Test_server_Password(t *testing.T) {
+	notifier := &captureNotifier{}
+	ts := newTestServer(t, nil, func(s *server) { s.passwordReset.Notifier = notifier })
+
+	res := ts.do("POST", "/api/user/register", `{"login":"user","password":"first"}`, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	current := res.Cookies()
+	res = ts.do("POST", "/api/user/login", `{"login":"user","password":"first"}`, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	other := res.Cookies()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		cookies []*http.Cookie
+		status  int
+	}{
+		{
+			name:    "change with wrong current password",
+			method:  "PUT",
+			path:    "/api/user/password",
+			body:    `{"current_password":"wrong","new_password":"second"}`,
+			cookies: current,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "change",
+			method:  "PUT",
+			path:    "/api/user/password",
+			body:    `{"current_password":"first","new_password":"second"}`,
+			cookies: current,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "current session is kept",
+			method:  "GET",
+			path:    "/api/user/balance",
+			cookies: current,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "other sessions are revoked",
+			method:  "GET",
+			path:    "/api/user/balance",
+			cookies: other,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:   "login with new password",
+			method: "POST",
+			path:   "/api/user/login",
+			body:   `{"login":"user","password":"second"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "reset for unknown login",
+			method: "POST",
+			path:   "/api/user/password/reset",
+			body:   `{"login":"nobody"}`,
+			status: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(tt.method, tt.path, tt.body, tt.cookies)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+	assert.Empty(t, notifier.messages)
+
+	t.Run("reset", func(t *testing.T) {
+		res := ts.do("POST", "/api/user/password/reset", `{"login":"user"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+		require.Len(t, notifier.messages, 1)
+		assert.Equal(t, "user", notifier.messages[0].To)
+		token := regexp.MustCompile(`token (\S+)`).FindStringSubmatch(notifier.messages[0].Body)
+		require.Len(t, token, 2)
+
+		confirm := `{"token":"` + token[1] + `","new_password":"third"}`
+		res = ts.do("POST", "/api/user/password/reset/confirm", confirm, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/password/reset/confirm", confirm, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, "token is single use")
+
+		res = ts.do("GET", "/api/user/balance", "", current)
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "reset revokes every session")
+
+		res = ts.do("POST", "/api/user/login", `{"login":"user","password":"third"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
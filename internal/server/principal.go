@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+type principalCtxKey struct{}
+
+// Principal is the authenticated caller resolved from the request token.
+type Principal struct {
+	UserID    int64
+	SessionID int64
+	Roles     []string
+	Scopes    []string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalCtxKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+func principalFromToken(token jwt.Token) (*Principal, bool) {
+	userID, err := strconv.ParseInt(token.Subject(), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	p := &Principal{UserID: userID}
+	claims := token.PrivateClaims()
+	switch sid := claims["sid"].(type) {
+	case float64:
+		p.SessionID = int64(sid)
+	case int64:
+		p.SessionID = sid
+	default:
+		return nil, false
+	}
+	switch roles := claims["roles"].(type) {
+	case []string:
+		p.Roles = roles
+	case []interface{}:
+		for _, role := range roles {
+			if r, ok := role.(string); ok {
+				p.Roles = append(p.Roles, r)
+			}
+		}
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	}
+	return p, true
+}
+
+// authenticator verifies the token sent as a bearer token or in
+// the jwt cookie and stores the resolved Principal in the request context.
+func (s *server) authenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := jwtauth.TokenFromHeader(r)
+		if tokenString == "" {
+			tokenString = jwtauth.TokenFromCookie(r)
+		}
+		if tokenString == "" {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		token, err := s.auth.Decode(tokenString)
+		if err != nil {
+			requestLog(r).Debug().AnErr("decode token", err).Msg("authenticator")
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		p, ok := principalFromToken(token)
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		session := domain.SessionModel{ID: p.SessionID, UserID: p.UserID}
+		err = session.Active(r.Context(), s.db)
+		if err != nil {
+			requestLog(r).Debug().AnErr("session", err).Msg("authenticator")
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		logUser(r, p)
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// RequireRole allows only principals having at least one of roles to proceed.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				writeError(w, r, errUnauthenticated)
+				return
+			}
+			for _, role := range roles {
+				if p.HasRole(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeError(w, r, errForbidden)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/validate"
+	"github.com/go-chi/chi/middleware"
+)
+
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 error response. Code is stable and meant
+// for clients to branch on; Detail is for humans only.
+type problem struct {
+	Type      string          `json:"type"`
+	Title     string          `json:"title"`
+	Status    int             `json:"status"`
+	Detail    string          `json:"detail,omitempty"`
+	Code      string          `json:"code"`
+	RequestID string          `json:"request_id,omitempty"`
+	Errors    validate.Errors `json:"errors,omitempty"`
+}
+
+var kindStatus = map[repo.Kind]int{
+	repo.KindNotFound:          http.StatusNotFound,
+	repo.KindConflict:          http.StatusConflict,
+	repo.KindInsufficientFunds: http.StatusPaymentRequired,
+	repo.KindValidation:        http.StatusBadRequest,
+	repo.KindUnprocessable:     http.StatusUnprocessableEntity,
+	repo.KindUnauthorized:      http.StatusUnauthorized,
+	repo.KindForbidden:         http.StatusForbidden,
+	repo.KindTooManyRequests:   http.StatusTooManyRequests,
+	repo.KindUnavailable:       http.StatusServiceUnavailable,
+}
+
+var (
+	errUnauthenticated = repo.NewError(repo.KindUnauthorized, "unauthenticated", "authentication required")
+	errForbidden       = repo.NewError(repo.KindForbidden, "forbidden", "not allowed")
+	errBadParameter    = repo.NewError(repo.KindValidation, "invalid_parameter", "invalid request parameter")
+	errBadEncoding     = repo.NewError(repo.KindValidation, "invalid_encoding", "request body cannot be decoded")
+	errTooLarge        = repo.NewError(repo.KindValidation, "body_too_large", "request body too large")
+)
+
+// setRetryAfter sets the Retry-After header rounding d up to seconds.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.RequestID = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// writeError maps err onto its problem response. Unclassified errors
+// are reported as internal without exposing their text.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var verrs validate.Errors
+	if errors.As(err, &verrs) {
+		writeValidationError(w, r, verrs)
+		return
+	}
+
+	e, ok := repo.AsError(err)
+	if !ok {
+		writeProblem(w, r, &problem{
+			Status: http.StatusInternalServerError,
+			Code:   "internal",
+		})
+		return
+	}
+
+	status, ok := kindStatus[e.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	var retry interface{ RetryAfter() time.Duration }
+	if errors.As(err, &retry) {
+		setRetryAfter(w, retry.RetryAfter())
+	}
+	writeProblem(w, r, &problem{
+		Status: status,
+		Code:   e.Code,
+		Detail: e.Message,
+	})
+}
+
+// writeValidationError reports every invalid field. An invalid order
+// number alone is reported as 422 as the API specification requires.
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs validate.Errors) {
+	p := &problem{
+		Status: http.StatusBadRequest,
+		Code:   "validation_failed",
+		Detail: "request is invalid",
+		Errors: errs,
+	}
+	if errs.Only(validate.RuleLuhn) {
+		p.Status = http.StatusUnprocessableEntity
+		p.Code = "invalid_order_number"
+	}
+	writeProblem(w, r, p)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/validate"
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{
+			name:   "not found",
+			err:    fmt.Errorf("get user failed: %w", repo.ErrNotExists),
+			status: http.StatusNotFound,
+			code:   "not_found",
+		},
+		{
+			name:   "insufficient funds",
+			err:    domain.ErrIsufficientFunds,
+			status: http.StatusPaymentRequired,
+			code:   "insufficient_funds",
+		},
+		{
+			name:   "unavailable",
+			err:    fmt.Errorf("%w: connection refused", repo.ErrUnavailable),
+			status: http.StatusServiceUnavailable,
+			code:   "unavailable",
+		},
+		{
+			name:   "invalid order number",
+			err:    validate.Errors{{Field: "number", Rule: validate.RuleLuhn}},
+			status: http.StatusUnprocessableEntity,
+			code:   "invalid_order_number",
+		},
+		{
+			name:   "unclassified",
+			err:    errors.New("secret details"),
+			status: http.StatusInternalServerError,
+			code:   "internal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p problem
+			handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, tt.err)
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.status, res.StatusCode)
+			assert.Equal(t, problemContentType, res.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&p))
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, tt.status, p.Status)
+			assert.NotEmpty(t, p.RequestID)
+			assert.NotContains(t, p.Detail, "secret")
+		})
+	}
+}
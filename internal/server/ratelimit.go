@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+)
+
+var errRateLimited = repo.NewError(repo.KindTooManyRequests, "rate_limited", "too many requests")
+
+// RateLimitStore counts requests per key in fixed windows.
+// repo.Repository implementations satisfy it.
+type RateLimitStore interface {
+	RateLimitHit(ctx context.Context, key string, at time.Time, window time.Duration) (int, time.Time, error)
+}
+
+// memRateStore is the default RateLimitStore, counting requests of
+// this process only.
+type memRateStore struct {
+	mu        sync.Mutex
+	windows   map[string]rateWindow
+	nextPurge time.Time
+}
+
+type rateWindow struct {
+	count int
+	reset time.Time
+}
+
+// memRatePurgeInterval is how often expired windows are purged.
+const memRatePurgeInterval = time.Minute
+
+func newMemRateStore() *memRateStore {
+	return &memRateStore{windows: make(map[string]rateWindow)}
+}
+
+// RateLimitHit counts a request for key in the fixed window starting
+// with the first request and returns the count and the window end.
+// Expired windows are purged every memRatePurgeInterval.
+func (m *memRateStore) RateLimitHit(ctx context.Context, key string, at time.Time, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[key]
+	if !ok || !at.Before(w.reset) {
+		w = rateWindow{reset: at.Add(window)}
+	}
+	w.count++
+	m.windows[key] = w
+
+	if !at.Before(m.nextPurge) {
+		m.nextPurge = at.Add(memRatePurgeInterval)
+		for k, w := range m.windows {
+			if !at.Before(w.reset) {
+				delete(m.windows, k)
+			}
+		}
+	}
+	return w.count, w.reset, nil
+}
+
+// rateLimitPolicy allows Limit requests per Window to every caller.
+// Zero Limit disables the policy.
+type rateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+type rateLimits struct {
+	Auth   rateLimitPolicy
+	Upload rateLimitPolicy
+	Read   rateLimitPolicy
+	Write  rateLimitPolicy
+}
+
+func newRateLimits(cfg *config.Config) rateLimits {
+	return rateLimits{
+		Auth:   rateLimitPolicy{Name: "auth", Limit: cfg.RateLimitAuth, Window: cfg.RateLimitWindow},
+		Upload: rateLimitPolicy{Name: "upload", Limit: cfg.RateLimitUpload, Window: cfg.RateLimitWindow},
+		Read:   rateLimitPolicy{Name: "read", Limit: cfg.RateLimitRead, Window: cfg.RateLimitWindow},
+		Write:  rateLimitPolicy{Name: "write", Limit: cfg.RateLimitWrite, Window: cfg.RateLimitWindow},
+	}
+}
+
+// Selectors passed to rateLimit.
+func (l rateLimits) auth() rateLimitPolicy   { return l.Auth }
+func (l rateLimits) upload() rateLimitPolicy { return l.Upload }
+func (l rateLimits) read() rateLimitPolicy   { return l.Read }
+func (l rateLimits) write() rateLimitPolicy  { return l.Write }
+
+type rateLimitedError struct {
+	reset time.Time
+}
+
+func (e *rateLimitedError) Error() string {
+	return errRateLimited.Error()
+}
+
+func (e *rateLimitedError) Unwrap() error {
+	return errRateLimited
+}
+
+func (e *rateLimitedError) RetryAfter() time.Duration {
+	return time.Until(e.reset)
+}
+
+func (s *server) WithRateLimitStore(store RateLimitStore) *server {
+	s.rateStore = store
+	return s
+}
+
+func (s *server) setRateLimits(l rateLimits) {
+	s.limitsMu.Lock()
+	s.rateLimits = l
+	s.limitsMu.Unlock()
+}
+
+// rateLimit enforces the policy picked from the current limits per
+// authenticated user, or per client address for anonymous requests.
+// Store failures are logged and counted, and let requests through.
+func (s *server) rateLimit(pick func(rateLimits) rateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.limitsMu.RLock()
+			policy := pick(s.rateLimits)
+			s.limitsMu.RUnlock()
+			if policy.Limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := policy.Name + ":ip:" + clientIP(r)
+			if p, ok := PrincipalFromContext(r.Context()); ok {
+				key = policy.Name + ":user:" + strconv.FormatInt(p.UserID, 10)
+			}
+
+			count, reset, err := s.rateStore.RateLimitHit(r.Context(), key, time.Now(), policy.Window)
+			if err != nil {
+				requestLog(r).Error().AnErr("hit", err).Str("policy", policy.Name).Msg("rateLimit")
+				s.metrics.AddRateLimitError(policy.Name)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			remaining := policy.Limit - count
+			if remaining < 0 {
+				remaining = 0
+			}
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			h.Set("RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(time.Until(reset).Seconds())), 10))
+
+			if count > policy.Limit {
+				writeError(w, r, &rateLimitedError{reset: reset})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
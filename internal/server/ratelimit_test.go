@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	repo "github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_server_RateLimit(t *testing.T) {
+	db := repo.NewInMemRepo()
+	s := NewServer(config.GetConfig())
+	s.rateLimits.Auth = rateLimitPolicy{Name: "auth", Limit: 2, Window: time.Minute}
+	s.loginGuard = domain.LoginGuard{}
+	s.WithDB(db).SetupRoutes()
+
+	login := func(addr string) *http.Response {
+		req := httptest.NewRequest("POST", "/api/user/login", strings.NewReader(`{"login":"user","password":"1234"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	for i := 0; i < 2; i++ {
+		res := login("192.0.2.10:1234")
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.Equal(t, "2", res.Header.Get("RateLimit-Limit"))
+	}
+
+	res := login("192.0.2.10:1234")
+	res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "0", res.Header.Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+	assert.Equal(t, problemContentType, res.Header.Get("Content-Type"))
+
+	res = login("192.0.2.11:1234")
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "other addresses are not limited")
+}
+
+func Test_server_Reconfigure(t *testing.T) {
+	cfg := *config.GetConfig()
+	cfg.RateLimitAuth = 1
+	s := NewServer(&cfg)
+	s.loginGuard = domain.LoginGuard{}
+	s.WithDB(repo.NewInMemRepo()).SetupRoutes()
+
+	login := func() int {
+		req := httptest.NewRequest("POST", "/api/user/login", strings.NewReader(`{"login":"user","password":"1234"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login())
+	assert.Equal(t, http.StatusTooManyRequests, login())
+
+	cfg.RateLimitAuth = 3
+	assert.NoError(t, s.Reconfigure(&cfg))
+	assert.Equal(t, http.StatusUnauthorized, login(), "raised limit applies to the running router")
+
+	cfg.RateLimitAuth = 0
+	cfg.JWTVerifyKeys = []string{"old=/nonexistent.pem"}
+	assert.Error(t, s.Reconfigure(&cfg))
+	assert.Equal(t, http.StatusTooManyRequests, login(), "rejected settings are not applied")
+}
+
+type failingRateStore struct{}
+
+func (failingRateStore) RateLimitHit(context.Context, string, time.Time, time.Duration) (int, time.Time, error) {
+	return 0, time.Time{}, errors.New("store down")
+}
+
+func Test_server_RateLimitStoreError(t *testing.T) {
+	s := NewServer(config.GetConfig())
+	s.rateLimits.Auth = rateLimitPolicy{Name: "auth", Limit: 1, Window: time.Minute}
+	s.loginGuard = domain.LoginGuard{}
+	s.WithDB(repo.NewInMemRepo()).WithRateLimitStore(failingRateStore{}).SetupRoutes()
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/user/login", strings.NewReader(`{"login":"user","password":"1234"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "store failures let requests through")
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `gophermart_rate_limit_errors_total{policy="auth"} 2`)
+}
+
+func Test_memRateStore_Purge(t *testing.T) {
+	m := newMemRateStore()
+	start := time.Now()
+
+	_, _, err := m.RateLimitHit(context.Background(), "a", start, time.Second)
+	assert.NoError(t, err)
+	_, _, err = m.RateLimitHit(context.Background(), "b", start.Add(2*time.Second), time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, m.windows, 2, "expired windows are kept until the purge interval")
+
+	count, _, err := m.RateLimitHit(context.Background(), "b", start.Add(memRatePurgeInterval), time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, m.windows, 1, "expired windows are purged once per interval")
+}
@@ -1,39 +1,76 @@
 package server
 
 import (
+	"github.com/andrei-cloud/gophermart/internal/repo"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/jwtauth/v5"
 )
 
-var TokenAuth *jwtauth.JWTAuth
-
-const password string = "my_secret"
-
-func init() {
-	TokenAuth = jwtauth.New("HS256", []byte(password), nil)
-}
-
 func (s *server) SetupRoutes() {
 	s.router = chi.NewRouter()
 
-	s.router.Use(Compressor)
+	s.router.Use(RequestLogger)
+	s.router.Use(traceRequest)
+	s.router.Use(s.instrument)
+	s.router.Use(s.compressor)
+	s.router.Get("/api/openapi.json", s.openAPI())
+	s.router.Method("GET", "/metrics", s.metrics.Handler())
+	s.router.Get("/healthz", s.healthz())
+	s.router.Get("/readyz", s.readyz())
 	//Public routes
 	s.router.Group(func(r chi.Router) {
+		r.Use(s.rateLimit(rateLimits.auth))
 		r.Post("/api/user/register", s.userRegister())
 		r.Post("/api/user/login", s.userLogin())
-		//authentication required handlers
+		r.Post("/api/user/login/2fa", s.userLoginTwoFactor())
+		r.Post("/api/user/refresh", s.userRefresh())
+		r.Post("/api/user/password/reset", s.userPasswordResetRequest())
+		r.Post("/api/user/password/reset/confirm", s.userPasswordResetConfirm())
+	})
+	//authentication required handlers
+	s.router.Group(func(r chi.Router) {
+		r.Use(s.authenticator)
+		r.Use(s.activeUser)
+		r.With(s.rateLimit(rateLimits.upload)).Post("/api/user/orders", s.userAddOrder())
 		r.Group(func(r chi.Router) {
-			r.Use(jwtauth.Verifier(TokenAuth))
-			r.Use(jwtauth.Authenticator)
-			r.Post("/api/user/orders", s.userAddOrder())
+			r.Use(s.rateLimit(rateLimits.read))
 			r.Get("/api/user/orders", s.userOrderList())
 			r.Get("/api/user/balance", s.userBalance())
-			r.Post("/api/user/balance/withdraw", s.userWithdraw())
 			r.Get("/api/user/withdrawals", s.userWithdrawalList())
+			r.Get("/api/user/transfers", s.userTransferList())
+			r.Get("/api/user/sessions", s.userSessionList())
+			r.Get("/api/user/export", s.userExport())
+			r.Get("/api/user/2fa", s.userTwoFactorStatus())
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(s.rateLimit(rateLimits.write))
+			r.Post("/api/user/balance/withdraw", s.userWithdraw())
+			r.Post("/api/user/balance/transfer", s.userTransfer())
+			r.Post("/api/user/logout", s.userLogout())
+			r.Put("/api/user/password", s.userPasswordChange())
+			r.Delete("/api/user", s.userDelete())
+			r.Delete("/api/user/sessions", s.userSessionRevokeOthers())
+			r.Delete("/api/user/sessions/{id}", s.userSessionRevoke())
+			r.Post("/api/user/2fa/setup", s.userTwoFactorSetup())
+			r.Post("/api/user/2fa/confirm", s.userTwoFactorConfirm())
+			r.Post("/api/user/2fa/disable", s.userTwoFactorDisable())
 		})
 	})
 
 	//private routes
+	s.router.Route("/api/admin", func(r chi.Router) {
+		r.Use(s.requireClientCert)
+		r.Use(s.authenticator)
+		r.Use(RequireRole(repo.RoleAdmin))
+		r.Use(s.activeUser)
+		r.Get("/users", s.adminUserSearch())
+		r.Get("/users/{id}", s.adminUserDetail())
+		r.Post("/users/{id}/balance", s.adminBalanceAdjust())
+		r.Post("/users/{id}/lock", s.adminUserLock(true))
+		r.Post("/users/{id}/unlock", s.adminUserLock(false))
+		r.Get("/orders", s.adminOrderSearch())
+		r.Post("/orders/{number}/repoll", s.adminOrderRepoll())
+		r.Get("/audit", s.adminAuditList())
+	})
 
 	s.Server.Handler = s.router
 }
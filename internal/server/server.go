@@ -2,31 +2,186 @@ package server
 
 import (
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/andrei-cloud/gophermart/internal/auth"
 	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/health"
+	"github.com/andrei-cloud/gophermart/internal/metrics"
+	"github.com/andrei-cloud/gophermart/internal/notify"
 	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/password"
 	"github.com/go-chi/chi"
+	"github.com/rs/zerolog/log"
 )
 
 type server struct {
 	http.Server
 
-	db     repo.Repository
-	router *chi.Mux
+	db             repo.Repository
+	router         *chi.Mux
+	auth           *auth.JWTAuth
+	passwords      *password.Hasher
+	accessTTL      time.Duration
+	refreshTTL     time.Duration
+	cookies        cookieConfig
+	maxBodyBytes   int64
+	transferLimits domain.TransferLimits
+	loginGuard     domain.LoginGuard
+	passwordReset  domain.PasswordReset
+	twoFactor      domain.TwoFactor
+	limitsMu       sync.RWMutex
+	rateLimits     rateLimits
+	rateStore      RateLimitStore
+	// adminClientCert requires a verified client certificate on the
+	// admin routes.
+	adminClientCert bool
+	metrics         *metrics.Metrics
+	health          *health.Checker
 }
 
 func NewServer(cfg *config.Config) *server {
-	return &server{
+	tokenAuth, err := auth.New(auth.Config{
+		Algorithm:  cfg.JWTAlgorithm,
+		Secret:     cfg.JWTSecret,
+		KeyFile:    cfg.JWTKeyFile,
+		KeyID:      cfg.JWTKeyID,
+		VerifyKeys: cfg.JWTVerifyKeys,
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		Ephemeral:  cfg.DevMode,
+	})
+	if err != nil {
+		log.Fatal().AnErr("auth.New", err).Msg("NewServer")
+	}
+
+	passwords, err := password.New(password.Params{
+		Algorithm:  cfg.PasswordAlgorithm,
+		BcryptCost: cfg.PasswordBcryptCost,
+		Time:       uint32(cfg.PasswordArgon2Time),
+		Memory:     uint32(cfg.PasswordArgon2Memory),
+		Threads:    uint8(cfg.PasswordArgon2Threads),
+		KeyLength:  uint32(cfg.PasswordArgon2KeyLength),
+		Pepper:     cfg.PasswordPepper,
+	})
+	if err != nil {
+		log.Fatal().AnErr("password.New", err).Msg("NewServer")
+	}
+
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	s := &server{
 		Server: http.Server{
-			Addr:           ":8080",
-			ReadTimeout:    60 * time.Second,
-			WriteTimeout:   60 * time.Second,
-			IdleTimeout:    30 * time.Second,
-			MaxHeaderBytes: 1 << 20,
+			Addr:           cfg.Address,
+			ReadTimeout:    cfg.ServerReadTimeout,
+			WriteTimeout:   cfg.ServerWriteTimeout,
+			IdleTimeout:    cfg.ServerIdleTimeout,
+			MaxHeaderBytes: cfg.ServerMaxHeaderBytes,
+		},
+		db:         nil,
+		auth:       tokenAuth,
+		passwords:  passwords,
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		cookies: cookieConfig{
+			Domain:   cfg.CookieDomain,
+			Secure:   cfg.CookieSecure || cfg.TLSEnabled(),
+			SameSite: parseSameSite(cfg.CookieSameSite),
+			Lifetime: cfg.CookieLifetime,
+		},
+		maxBodyBytes: cfg.MaxBodyBytes,
+		transferLimits: domain.TransferLimits{
+			Min:   cfg.TransferMin,
+			Max:   cfg.TransferMax,
+			Daily: cfg.TransferDaily,
 		},
-		db: nil,
+		loginGuard: domain.LoginGuard{
+			MaxFailures:     cfg.LoginMaxFailures,
+			MaxAddrFailures: cfg.LoginMaxAddrFailures,
+			Window:          cfg.LoginFailureWindow,
+			Lockout:         cfg.LoginLockout,
+			BaseDelay:       cfg.LoginBaseDelay,
+			MaxDelay:        cfg.LoginMaxDelay,
+			ChallengeTTL:    cfg.TwoFactorChallengeTTL,
+		},
+		passwordReset: domain.PasswordReset{
+			TTL:      cfg.PasswordResetTTL,
+			Notifier: notify.New(cfg.NotifyFile),
+		},
+		twoFactor: domain.TwoFactor{
+			Issuer:              cfg.TwoFactorIssuer,
+			WithdrawalThreshold: cfg.TwoFactorWithdrawalThreshold,
+		},
+		rateLimits:      newRateLimits(cfg),
+		rateStore:       newMemRateStore(),
+		adminClientCert: cfg.TLSClientCAFile != "",
+		metrics:         metrics.New(),
 	}
+	s.health = health.New(s.repositoryChecks()...).WithTimeout(cfg.ReadinessCheckTimeout)
+	return s
+}
+
+// cookieConfig describes the attributes of the authentication cookies.
+// Lifetime applies to the access cookie only; zero makes it expire
+// together with its token. The refresh cookie always follows its token.
+type cookieConfig struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	Lifetime time.Duration
+}
+
+func parseSameSite(v string) http.SameSite {
+	switch strings.ToLower(v) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// accessLifetime returns how long the access cookie holding a token
+// valid for ttl lives.
+func (c cookieConfig) accessLifetime(ttl time.Duration) time.Duration {
+	if c.Lifetime > 0 {
+		return c.Lifetime
+	}
+	return ttl
+}
+
+// cookie builds an authentication cookie that expires after lifetime.
+func (c cookieConfig) cookie(name, value, path string, lifetime time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.Domain,
+		Expires:  time.Now().Add(lifetime),
+		MaxAge:   int(lifetime.Seconds()),
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+}
+
+// Reconfigure applies the settings of cfg that may change while the
+// server runs: rate limits and JWT verification keys. Nothing changes
+// when the keys cannot be loaded.
+func (s *server) Reconfigure(cfg *config.Config) error {
+	if err := s.auth.SetVerifyKeys(cfg.JWTVerifyKeys); err != nil {
+		return err
+	}
+	s.setRateLimits(newRateLimits(cfg))
+	return nil
 }
 
 func (s *server) WithDB(r repo.Repository) *server {
@@ -34,6 +189,13 @@ func (s *server) WithDB(r repo.Repository) *server {
 	return s
 }
 
+// WithMetrics shares m with the rest of the process so that /metrics
+// reports the worker and repository as well as the HTTP layer.
+func (s *server) WithMetrics(m *metrics.Metrics) *server {
+	s.metrics = m
+	return s
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
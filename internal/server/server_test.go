@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain configures a JWT secret, which config.GetConfig requires
+// outside dev mode.
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv("JWT_SECRET"); !ok {
+		os.Setenv("JWT_SECRET", "test-secret")
+	}
+	os.Exit(m.Run())
+}
+
+// testServer is a server with routes on a fresh in-memory repository.
+type testServer struct {
+	*server
+	db repo.Repository
+	t  *testing.T
+}
+
+// newTestServer builds a test server from cfg, or from the global
+// configuration when cfg is nil. setup, when given, adjusts the server
+// before its routes are set up.
+func newTestServer(t *testing.T, cfg *config.Config, setup ...func(*server)) *testServer {
+	if cfg == nil {
+		cfg = config.GetConfig()
+	}
+	ts := &testServer{server: NewServer(cfg), db: inmem.NewInMemRepo(), t: t}
+	for _, f := range setup {
+		f(ts.server)
+	}
+	ts.WithDB(ts.db).SetupRoutes()
+	return ts
+}
+
+// serve runs req through the router and returns the response.
+func (ts *testServer) serve(req *http.Request) *http.Response {
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, req)
+	return w.Result()
+}
+
+// do sends a JSON request carrying cookies.
+func (ts *testServer) do(method, path, body string, cookies []*http.Cookie) *http.Response {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return ts.serve(req)
+}
+
+// register creates a user with password 1234 and returns its cookies.
+func (ts *testServer) register(login string) []*http.Cookie {
+	res := ts.do("POST", "/api/user/register", `{"login":"`+login+`","password":"1234"}`, nil)
+	res.Body.Close()
+	require.Equal(ts.t, http.StatusOK, res.StatusCode)
+	return res.Cookies()
+}
+
+// upload sends an order number as the order upload endpoint expects it.
+func (ts *testServer) upload(number string, cookies []*http.Cookie) *http.Response {
+	req := httptest.NewRequest("POST", "/api/user/orders", strings.NewReader(number))
+	req.Header.Set("Content-Type", "text/plain")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return ts.serve(req)
+}
+
+// cookieJar keeps the latest cookie of each name, as a browser does.
+type cookieJar map[string]*http.Cookie
+
+func (j cookieJar) save(res *http.Response) {
+	for _, c := range res.Cookies() {
+		j[c.Name] = c
+	}
+}
+
+func (j cookieJar) cookies() []*http.Cookie {
+	cookies := make([]*http.Cookie, 0, len(j))
+	for _, c := range j {
+		cookies = append(cookies, c)
+	}
+	return cookies
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	repo "github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/go-chi/chi"
+)
+
+func (s *server) userRefresh() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// clients that keep the tokens themselves send the refresh
+		// token in the body, browsers in the cookie
+		var token string
+		if r.ContentLength != 0 {
+			request := struct {
+				RefreshToken string `json:"refresh_token" validate:"required"`
+			}{}
+			if !s.decodeJSON(w, r, &request) {
+				return
+			}
+			token = request.RefreshToken
+		} else if cookie, err := r.Cookie("refresh"); err == nil {
+			token = cookie.Value
+		}
+		if token == "" {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		session := domain.SessionModel{}
+		refresh, err := session.Refresh(r.Context(), s.db, token, s.refreshTTL)
+		if err != nil {
+			requestLog(r).Error().AnErr("refresh", err).Msg("userRefresh")
+			if repo.KindOf(err) == repo.KindUnauthorized {
+				s.clearTokens(w)
+			}
+			writeError(w, r, err)
+			return
+		}
+
+		user, err := s.db.UserGetByID(r.Context(), session.UserID)
+		if err != nil {
+			requestLog(r).Error().AnErr("get user", err).Msg("userRefresh")
+			writeError(w, r, err)
+			return
+		}
+		if user.Locked {
+			writeError(w, r, domain.ErrUserLocked)
+			return
+		}
+
+		tokens, err := s.setTokens(w, session.ID, user.ID, user.Roles, refresh)
+		if err != nil {
+			requestLog(r).Error().AnErr("encode token", err).Msg("userRefresh")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, tokens, "userRefresh")
+	}
+}
+
+func (s *server) userLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		err := s.db.SessionRevoke(r.Context(), principal.SessionID)
+		if err != nil {
+			requestLog(r).Error().AnErr("revoke session", err).Msg("userLogout")
+			writeError(w, r, err)
+			return
+		}
+
+		s.clearTokens(w)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) userSessionList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		session := domain.SessionModel{ID: principal.SessionID, UserID: principal.UserID}
+		list, err := session.List(r.Context(), s.db)
+		if err != nil {
+			requestLog(r).Error().AnErr("session list", err).Msg("userSessionList")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, &list, "userSessionList")
+	}
+}
+
+func (s *server) userSessionRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			writeError(w, r, errBadParameter)
+			return
+		}
+
+		session := domain.SessionModel{ID: principal.SessionID, UserID: principal.UserID}
+		err = session.Revoke(r.Context(), s.db, id)
+		if err != nil {
+			requestLog(r).Error().AnErr("revoke session", err).Msg("userSessionRevoke")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) userSessionRevokeOthers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		session := domain.SessionModel{ID: principal.SessionID, UserID: principal.UserID}
+		err := session.RevokeOthers(r.Context(), s.db)
+		if err != nil {
+			requestLog(r).Error().AnErr("revoke sessions", err).Msg("userSessionRevokeOthers")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
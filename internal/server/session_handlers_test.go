@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_Session(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	jar := cookieJar{}
+	res := ts.do("POST", "/api/user/register", `{"login":"user","password":"1234"}`, nil)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	jar.save(res)
+	require.NotNil(t, jar["refresh"])
+
+	stolen := cookieJar{"refresh": jar["refresh"]}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		jar    cookieJar
+		status int
+	}{
+		{
+			name:   "session list",
+			method: "GET",
+			path:   "/api/user/sessions",
+			jar:    jar,
+			status: http.StatusOK,
+		},
+		{
+			name:   "refresh rotates token",
+			method: "POST",
+			path:   "/api/user/refresh",
+			jar:    jar,
+			status: http.StatusOK,
+		},
+		{
+			name:   "reused refresh token is rejected",
+			method: "POST",
+			path:   "/api/user/refresh",
+			jar:    stolen,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "session family is revoked after reuse",
+			method: "GET",
+			path:   "/api/user/balance",
+			jar:    jar,
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(tt.method, tt.path, "", tt.jar.cookies())
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+			if res.StatusCode == http.StatusOK {
+				tt.jar.save(res)
+			}
+		})
+	}
+
+	t.Run("logout revokes access token", func(t *testing.T) {
+		jar := cookieJar{}
+		res := ts.do("POST", "/api/user/login", `{"login":"user","password":"1234"}`, nil)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		jar.save(res)
+		access := jar["jwt"]
+
+		res = ts.do("POST", "/api/user/logout", "", jar.cookies())
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		res = ts.do("GET", "/api/user/balance", "", []*http.Cookie{access})
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("bearer client refreshes without cookies", func(t *testing.T) {
+		tokens := func(res *http.Response) tokenPair {
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			var pair tokenPair
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&pair))
+			require.NotEmpty(t, pair.AccessToken)
+			require.NotEmpty(t, pair.RefreshToken)
+			return pair
+		}
+		balance := func(access string) int {
+			req := httptest.NewRequest("GET", "/api/user/balance", nil)
+			req.Header.Set("Authorization", "Bearer "+access)
+			res := ts.serve(req)
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		first := tokens(ts.do("POST", "/api/user/login", `{"login":"user","password":"1234"}`, nil))
+		assert.Equal(t, http.StatusOK, balance(first.AccessToken))
+
+		second := tokens(ts.do("POST", "/api/user/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`, nil))
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		assert.Equal(t, http.StatusOK, balance(second.AccessToken))
+
+		res := ts.do("POST", "/api/user/refresh", `{"refresh_token":"`+first.RefreshToken+`"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "reused refresh token is rejected")
+		assert.Equal(t, http.StatusUnauthorized, balance(second.AccessToken), "session family is revoked")
+
+		res = ts.do("POST", "/api/user/refresh", `{}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/tlsconfig"
+)
+
+var errClientCertificate = repo.NewError(repo.KindForbidden, "client_certificate_required", "a verified client certificate is required")
+
+// requireClientCert guards the admin routes when a client CA is
+// configured. The TLS handshake verifies certificates that are given;
+// this rejects connections that gave none.
+func (s *server) requireClientCert(next http.Handler) http.Handler {
+	if !s.adminClientCert {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tlsconfig.VerifiedClient(r.TLS) {
+			writeError(w, r, errClientCertificate)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewRedirectServer answers plain HTTP on cfg.TLSRedirectAddress with
+// permanent redirects to the HTTPS listener on cfg.Address.
+func NewRedirectServer(cfg *config.Config) *http.Server {
+	_, port, _ := net.SplitHostPort(cfg.Address)
+	return &http.Server{
+		Addr:           cfg.TLSRedirectAddress,
+		Handler:        redirectToHTTPS(port),
+		ReadTimeout:    cfg.ServerReadTimeout,
+		WriteTimeout:   cfg.ServerWriteTimeout,
+		IdleTimeout:    cfg.ServerIdleTimeout,
+		MaxHeaderBytes: cfg.ServerMaxHeaderBytes,
+	}
+}
+
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_AdminClientCert(t *testing.T) {
+	db := inmem.NewInMemRepo()
+	_, err := db.UserCreate(context.Background(), &repo.User{Username: "admin"})
+	require.NoError(t, err)
+	require.NoError(t, domain.GrantRole(context.Background(), db, "admin", repo.RoleAdmin))
+
+	cfg := *config.GetConfig()
+	cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile = "tls.crt", "tls.key", "ca.crt"
+	s := NewServer(&cfg)
+	s.WithDB(db).SetupRoutes()
+
+	session := domain.SessionModel{UserID: 1}
+	_, err = session.Start(context.Background(), db, time.Hour)
+	require.NoError(t, err)
+	_, token, err := s.auth.Encode("1", map[string]interface{}{"sid": session.ID, "roles": []string{repo.RoleAdmin}}, time.Hour)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		state  *tls.ConnectionState
+		status int
+	}{
+		{name: "plain http", status: http.StatusForbidden},
+		{name: "no client certificate", state: &tls.ConnectionState{}, status: http.StatusForbidden},
+		{
+			name:   "verified client certificate",
+			state:  &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}},
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/admin/users", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.TLS = tt.state
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), "client_certificate_required")
+			}
+		})
+	}
+
+	req := httptest.NewRequest("POST", "/api/user/register", strings.NewReader(`{"login":"alice","password":"1234"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, c := range w.Result().Cookies() {
+		assert.True(t, c.Secure, "cookies are secure when serving TLS")
+	}
+}
+
+func Test_redirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   string
+		host   string
+		target string
+	}{
+		{name: "default port", port: "443", host: "example.com:80", target: "https://example.com/api/user/orders?page=2"},
+		{name: "custom port", port: "8443", host: "example.com", target: "https://example.com:8443/api/user/orders?page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/user/orders?page=2", nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			redirectToHTTPS(tt.port).ServeHTTP(w, req)
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tt.target, w.Header().Get("Location"))
+		})
+	}
+}
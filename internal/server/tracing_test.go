@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andrei-cloud/gophermart/internal/config"
+	repo "github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/andrei-cloud/gophermart/internal/repo/traced"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_server_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	}()
+
+	s := NewServer(config.GetConfig())
+	s.WithDB(traced.New(repo.NewInMemRepo())).SetupRoutes()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/api/user/register", strings.NewReader(`{"login":"alice","password":"1234"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String(), "%s continues the caller's trace", span.Name())
+		spans[span.Name()] = span
+	}
+
+	server, ok := spans["POST /api/user/register"]
+	require.True(t, ok, "server span is named after the route")
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+
+	register, ok := spans["user.Register"]
+	require.True(t, ok)
+	assert.Equal(t, server.SpanContext().SpanID(), register.Parent().SpanID())
+
+	create, ok := spans["repo.UserCreate"]
+	require.True(t, ok)
+	assert.Equal(t, register.SpanContext().SpanID(), create.Parent().SpanID(), "queries nest under the domain call")
+
+	start, ok := spans["session.Start"]
+	require.True(t, ok)
+	assert.Equal(t, server.SpanContext().SpanID(), start.Parent().SpanID())
+}
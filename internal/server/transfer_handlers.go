@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+)
+
+func (s *server) userTransfer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		transfer := domain.TransferModel{}
+		if !s.decodeJSON(w, r, &transfer) {
+			return
+		}
+
+		transfer.UserID = principal.UserID
+
+		err := transfer.Transfer(r.Context(), s.db, s.transferLimits)
+		if err != nil {
+			requestLog(r).Error().AnErr("transfer", err).Msg("userTransfer")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *server) userTransferList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		transfer := domain.TransferModel{
+			UserID: principal.UserID,
+		}
+
+		list, err := transfer.List(r.Context(), s.db)
+		if err != nil {
+			requestLog(r).Error().AnErr("transfer list", err).Msg("userTransferList")
+			writeError(w, r, err)
+			return
+		}
+		if len(list) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(&list)
+			if err != nil {
+				requestLog(r).Error().AnErr("encoding response", err).Msg("userTransferList")
+				writeError(w, r, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import "net/http"
+
+func (s *server) userLoginTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			Challenge string `json:"challenge" validate:"required"`
+			Code      string `json:"code" validate:"required,max=32"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		user, err := s.loginGuard.CompleteLogin(r.Context(), s.db, request.Challenge, request.Code, clientIP(r))
+		if err != nil {
+			requestLog(r).Error().AnErr("complete login", err).Msg("userLoginTwoFactor")
+			writeError(w, r, err)
+			return
+		}
+
+		tokens, err := s.generateToken(w, r, user.ID, user.Roles)
+		if err != nil {
+			requestLog(r).Error().AnErr("encode token", err).Msg("userLoginTwoFactor")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, tokens, "userLoginTwoFactor")
+	}
+}
+
+func (s *server) userTwoFactorStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		status, err := s.twoFactor.Status(r.Context(), s.db, principal.UserID)
+		if err != nil {
+			requestLog(r).Error().AnErr("two-factor status", err).Msg("userTwoFactorStatus")
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, status, "userTwoFactorStatus")
+	}
+}
+
+func (s *server) userTwoFactorSetup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		setup, err := s.twoFactor.Setup(r.Context(), s.db, principal.UserID)
+		if err != nil {
+			requestLog(r).Error().AnErr("two-factor setup", err).Msg("userTwoFactorSetup")
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, r, setup, "userTwoFactorSetup")
+	}
+}
+
+func (s *server) userTwoFactorConfirm() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		request := struct {
+			Code string `json:"code" validate:"required,max=32"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		codes, err := s.twoFactor.Confirm(r.Context(), s.db, principal.UserID, request.Code)
+		if err != nil {
+			requestLog(r).Error().AnErr("two-factor confirm", err).Msg("userTwoFactorConfirm")
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, r, struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}{codes}, "userTwoFactorConfirm")
+	}
+}
+
+func (s *server) userTwoFactorDisable() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		request := struct {
+			Password string `json:"password" validate:"required"`
+			Code     string `json:"code" validate:"required,max=32"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
+
+		err := s.loginGuard.DisableTwoFactor(r.Context(), s.db, s.passwords, &s.twoFactor,
+			principal.UserID, request.Password, request.Code, clientIP(r))
+		if err != nil {
+			requestLog(r).Error().AnErr("two-factor disable", err).Msg("userTwoFactorDisable")
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
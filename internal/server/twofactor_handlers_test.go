@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/pkg/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server_TwoFactor(t *testing.T) {
+	ts := newTestServer(t, nil, func(s *server) {
+		s.twoFactor = domain.TwoFactor{Issuer: "Gophermart", WithdrawalThreshold: 100}
+	})
+	code := func(secret string, at time.Time) string {
+		c, err := totp.Code(secret, totp.Step(at))
+		require.NoError(t, err)
+		return c
+	}
+
+	cookies := ts.register("alice")
+	require.NoError(t, ts.db.UserAdjust(context.Background(), &repo.Adjustment{UserID: 1, Value: 1000, Reason: "opening balance", CreatedAt: time.Now()}, nil))
+
+	var (
+		secret   string
+		recovery []string
+	)
+
+	t.Run("setup and confirm", func(t *testing.T) {
+		res := ts.do("POST", "/api/user/2fa/setup", "", cookies)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var setup domain.TwoFactorSetup
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&setup))
+		assert.Contains(t, setup.URL, "otpauth://totp/Gophermart:alice")
+		secret = setup.Secret
+
+		res = ts.do("POST", "/api/user/2fa/confirm", `{"code":"000000x"}`, cookies)
+		res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/2fa/confirm", `{"code":"`+code(secret, time.Now())+`"}`, cookies)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var body struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		require.Len(t, body.RecoveryCodes, 10)
+		recovery = body.RecoveryCodes
+	})
+
+	t.Run("login requires second step", func(t *testing.T) {
+		challenge := func() string {
+			res := ts.do("POST", "/api/user/login", `{"login":"alice","password":"1234"}`, nil)
+			defer res.Body.Close()
+			require.Equal(t, http.StatusAccepted, res.StatusCode)
+			assert.Empty(t, res.Cookies(), "no token before the second step")
+			var body struct {
+				Challenge string `json:"challenge"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			return body.Challenge
+		}
+
+		first := challenge()
+		res := ts.do("POST", "/api/user/login/2fa", `{"challenge":"`+first+`","code":"123"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/login/2fa", `{"challenge":"`+first+`","code":"`+recovery[0]+`"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "challenge is single use")
+
+		res = ts.do("POST", "/api/user/login/2fa", `{"challenge":"`+challenge()+`","code":"`+recovery[0]+`"}`, nil)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.NotEmpty(t, res.Cookies())
+
+		res = ts.do("POST", "/api/user/login/2fa", `{"challenge":"`+challenge()+`","code":"`+recovery[0]+`"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "recovery code is single use")
+	})
+
+	t.Run("withdrawal above threshold", func(t *testing.T) {
+		res := ts.do("POST", "/api/user/balance/withdraw", `{"order":"2377225624","sum":50}`, cookies)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/balance/withdraw", `{"order":"49927398716","sum":200}`, cookies)
+		res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		otp := code(secret, time.Now().Add(totp.Period))
+		res = ts.do("POST", "/api/user/balance/withdraw", `{"order":"49927398716","sum":200,"otp":"`+otp+`"}`, cookies)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/balance/withdraw", `{"order":"79927398713","sum":200,"otp":"`+otp+`"}`, cookies)
+		res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode, "code cannot be replayed")
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		res := ts.do("POST", "/api/user/2fa/disable", `{"password":"wrong","code":"`+recovery[1]+`"}`, cookies)
+		res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/2fa/disable", `{"password":"1234","code":"`+recovery[1]+`"}`, cookies)
+		res.Body.Close()
+		require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+		res = ts.do("POST", "/api/user/login", `{"login":"alice","password":"1234"}`, nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+}
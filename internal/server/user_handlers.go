@@ -2,158 +2,203 @@ package server
 
 import (
 	"encoding/json"
-	"errors"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/andrei-cloud/gophermart/internal/domain"
-	repo "github.com/andrei-cloud/gophermart/internal/repo"
-	"github.com/go-chi/jwtauth/v5"
-	"github.com/rs/zerolog/log"
 )
 
-func isValidType(w http.ResponseWriter, r *http.Request, expectedType string) bool {
-	ct := r.Header.Get("Content-Type")
-	if ct != expectedType {
-		log.Debug().Msg("isValidType: invalid content type")
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return false
-	}
-	return true
+// tokenPair is the body of a successful authentication. It repeats the
+// cookies for clients that keep the tokens themselves and send the
+// access token as a bearer header.
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
-func generateToken(w http.ResponseWriter, userID int64) error {
-	claims := map[string]interface{}{"userId": userID}
+func (s *server) generateToken(w http.ResponseWriter, r *http.Request, userID int64, roles []string) (*tokenPair, error) {
+	session := domain.SessionModel{
+		UserID:    userID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	refresh, err := session.Start(r.Context(), s.db, s.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.setTokens(w, session.ID, userID, roles, refresh)
+}
 
-	jwtauth.SetIssuedAt(claims, time.Now())
-	jwtauth.SetExpiryIn(claims, 24*time.Hour)
+func (s *server) setTokens(w http.ResponseWriter, sessionID, userID int64, roles []string, refresh string) (*tokenPair, error) {
+	claims := map[string]interface{}{"sid": sessionID}
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	}
 
-	token, tokenString, err := TokenAuth.Encode(claims)
+	_, tokenString, err := s.auth.Encode(strconv.FormatInt(userID, 10), claims, s.accessTTL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cookie := http.Cookie{
-		Name:     "jwt",
-		Value:    tokenString,
-		Path:     "/",
-		Domain:   "localhost",
-		Expires:  token.Expiration(),
-		Secure:   false,
-		HttpOnly: true,
+	w.Header().Set("Authorization", "Bearer "+tokenString)
+	http.SetCookie(w, s.cookies.cookie("jwt", tokenString, "/", s.cookies.accessLifetime(s.accessTTL)))
+	// the refresh cookie lives exactly as long as the token stored for it
+	http.SetCookie(w, s.cookies.cookie("refresh", refresh, "/api/user", s.refreshTTL))
+
+	return &tokenPair{AccessToken: tokenString, RefreshToken: refresh}, nil
+}
+
+func (s *server) clearTokens(w http.ResponseWriter) {
+	for name, path := range map[string]string{"jwt": "/", "refresh": "/api/user"} {
+		cookie := s.cookies.cookie(name, "", path, 0)
+		cookie.Expires = time.Time{}
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
 	}
-	http.SetCookie(w, &cookie)
+}
 
-	return nil
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 func (s *server) userLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !isValidType(w, r, "application/json") {
+		user := domain.UserModel{}
+		if !s.decodeJSON(w, r, &user) {
 			return
 		}
 
-		user := domain.UserModel{}
-		err := json.NewDecoder(r.Body).Decode(&user)
+		userID, err := s.loginGuard.Login(r.Context(), s.db, s.passwords, &user, clientIP(r))
 		if err != nil {
-			log.Error().AnErr("decode request", err).Msg("userLogin")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("login", err).Msg("userLogin")
+			writeError(w, r, err)
 			return
 		}
 
-		userID, err := user.Login(s.db)
-		if err != nil {
-			log.Error().AnErr("login", err).Msg("userLogin")
-			switch errors.Is(err, repo.ErrNotExists) {
-			case true:
-				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-				return
-			default:
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
+		if user.Challenge != "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			writeJSON(w, r, struct {
+				Challenge string `json:"challenge"`
+			}{user.Challenge}, "userLogin")
+			return
 		}
 
-		err = generateToken(w, userID)
+		tokens, err := s.generateToken(w, r, userID, user.Roles)
 		if err != nil {
-			log.Error().AnErr("encode token", err).Msg("userLogin")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("encode token", err).Msg("userLogin")
+			writeError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		writeJSON(w, r, tokens, "userLogin")
 	}
 }
 
 func (s *server) userRegister() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !isValidType(w, r, "application/json") {
-			return
-		}
-
 		user := domain.UserModel{}
-		err := json.NewDecoder(r.Body).Decode(&user)
-		if err != nil {
-			log.Error().AnErr("decode body", err).Msg("userRegister")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		if !s.decodeJSON(w, r, &user) {
 			return
 		}
 
-		userID, err := user.Register(s.db)
+		userID, err := user.Register(r.Context(), s.db, s.passwords)
 		if err != nil {
-			log.Error().AnErr("register", err).Msg("userRegister")
-			switch errors.Is(err, repo.ErrAlreadyExists) {
-			case true:
-				http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
-				return
-			default:
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
+			requestLog(r).Error().AnErr("register", err).Msg("userRegister")
+			writeError(w, r, err)
+			return
 		}
 
-		err = generateToken(w, userID)
+		tokens, err := s.generateToken(w, r, userID, user.Roles)
 		if err != nil {
-			log.Error().AnErr("encode token", err).Msg("userRegister")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("encode token", err).Msg("userRegister")
+			writeError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		writeJSON(w, r, tokens, "userRegister")
 	}
 }
 
 func (s *server) userBalance() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, claims, err := jwtauth.FromContext(r.Context())
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		user := domain.UserModel{ID: principal.UserID}
+
+		balance, err := user.GetBalance(r.Context(), s.db)
+		if err != nil {
+			requestLog(r).Error().AnErr("get balance", err).Msg("userBalance")
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(balance)
 		if err != nil {
-			log.Error().AnErr("jwt form context", err).Msg("userBalance")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("encoding response", err).Msg("userBalance")
+			writeError(w, r, err)
 			return
 		}
+	}
+}
 
-		userID, ok := claims["userId"].(float64)
+func (s *server) userDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
 		if !ok {
-			log.Error().AnErr("not valid claims", err).Msg("userBalance")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, r, errUnauthenticated)
 			return
 		}
 
-		user := domain.UserModel{ID: int64(userID)}
+		request := struct {
+			Password string `json:"password" validate:"required"`
+		}{}
+		if !s.decodeJSON(w, r, &request) {
+			return
+		}
 
-		balance, err := user.GetBalance(s.db)
+		user := domain.UserModel{ID: principal.UserID}
+		err := user.Delete(r.Context(), s.db, s.passwords, request.Password)
 		if err != nil {
-			log.Error().AnErr("get balance", err).Msg("userBalance")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("delete", err).Msg("userDelete")
+			writeError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(&balance)
+		s.clearTokens(w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *server) userExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, r, errUnauthenticated)
+			return
+		}
+
+		user := domain.UserModel{ID: principal.UserID}
+		export, err := user.Export(r.Context(), s.db)
 		if err != nil {
-			log.Error().AnErr("encoding response", err).Msg("userBalance")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			requestLog(r).Error().AnErr("export", err).Msg("userExport")
+			writeError(w, r, err)
 			return
 		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.json"`)
+		writeJSON(w, r, export, "userExport")
 	}
 }
@@ -6,10 +6,12 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/andrei-cloud/gophermart/internal/config"
 	repo "github.com/andrei-cloud/gophermart/internal/repo/inmem"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_server_User(t *testing.T) {
@@ -30,12 +32,20 @@ func Test_server_User(t *testing.T) {
 			status:  http.StatusBadRequest,
 		},
 		{
-			name:    "user login internal error",
+			name:    "user login empty body",
 			method:  "POST",
 			content: "application/json",
 			path:    "/api/user/login",
 			body:    nil,
-			status:  http.StatusInternalServerError,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "user login missing password",
+			method:  "POST",
+			content: "application/json",
+			path:    "/api/user/login",
+			body:    strings.NewReader(`{"login":"user"}`),
+			status:  http.StatusBadRequest,
 		},
 		{
 			name:    "user login unauthorized",
@@ -54,12 +64,20 @@ func Test_server_User(t *testing.T) {
 			status:  http.StatusBadRequest,
 		},
 		{
-			name:    "user register internal error",
+			name:    "user register empty body",
 			method:  "POST",
 			content: "application/json",
 			path:    "/api/user/register",
 			body:    nil,
-			status:  http.StatusInternalServerError,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "user register missing password",
+			method:  "POST",
+			content: "application/json",
+			path:    "/api/user/register",
+			body:    strings.NewReader(`{"login":"user"}`),
+			status:  http.StatusBadRequest,
 		},
 		{
 			name:    "user register created",
@@ -85,6 +103,30 @@ func Test_server_User(t *testing.T) {
 			body:    strings.NewReader(`{"login":"user","password":"1234"}`),
 			status:  http.StatusOK,
 		},
+		{
+			name:    "user login OK with charset",
+			method:  "POST",
+			content: "application/json; charset=utf-8",
+			path:    "/api/user/login",
+			body:    strings.NewReader(`{"login":"user","password":"1234"}`),
+			status:  http.StatusOK,
+		},
+		{
+			name:    "user login password too long",
+			method:  "POST",
+			content: "application/json",
+			path:    "/api/user/login",
+			body:    strings.NewReader(`{"login":"user","password":"` + strings.Repeat("é", 40) + `"}`),
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "user login body too large",
+			method:  "POST",
+			content: "application/json",
+			path:    "/api/user/login",
+			body:    strings.NewReader(`{"login":"` + strings.Repeat("u", defaultMaxBodyBytes) + `"}`),
+			status:  http.StatusRequestEntityTooLarge,
+		},
 	}
 
 	db := repo.NewInMemRepo()
@@ -104,3 +146,89 @@ func Test_server_User(t *testing.T) {
 		})
 	}
 }
+
+func Test_server_CookieLifetime(t *testing.T) {
+	tests := []struct {
+		name        string
+		lifetime    time.Duration
+		wantAccess  time.Duration
+		wantRefresh time.Duration
+	}{
+		{
+			name:        "cookies follow their tokens",
+			wantAccess:  config.GetConfig().AccessTokenTTL,
+			wantRefresh: config.GetConfig().RefreshTokenTTL,
+		},
+		{
+			name:        "lifetime applies to the access cookie",
+			lifetime:    10 * time.Minute,
+			wantAccess:  10 * time.Minute,
+			wantRefresh: config.GetConfig().RefreshTokenTTL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *config.GetConfig()
+			cfg.CookieLifetime = tt.lifetime
+			ts := newTestServer(t, &cfg)
+
+			cookies := map[string]*http.Cookie{}
+			for _, c := range ts.register("lifetime") {
+				cookies[c.Name] = c
+			}
+			require.Contains(t, cookies, "jwt")
+			require.Contains(t, cookies, "refresh")
+			assert.Equal(t, int(tt.wantAccess.Seconds()), cookies["jwt"].MaxAge)
+			assert.WithinDuration(t, time.Now().Add(tt.wantAccess), cookies["jwt"].Expires, time.Minute)
+			assert.Equal(t, int(tt.wantRefresh.Seconds()), cookies["refresh"].MaxAge)
+			assert.WithinDuration(t, time.Now().Add(tt.wantRefresh), cookies["refresh"].Expires, time.Minute)
+		})
+	}
+}
+
+func Test_server_Bearer(t *testing.T) {
+	ts := newTestServer(t, nil)
+	res := ts.do("POST", "/api/user/register", `{"login":"bearer","password":"1234"}`, nil)
+	res.Body.Close()
+
+	token := res.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(token, "Bearer "))
+	for _, c := range res.Cookies() {
+		assert.True(t, c.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{
+			name:   "bearer token accepted",
+			header: token,
+			status: http.StatusOK,
+		},
+		{
+			name:   "malformed bearer token rejected",
+			header: "Bearer garbage",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "missing token rejected",
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/user/balance", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			res := ts.serve(req)
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andrei-cloud/gophermart/pkg/validate"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+func isValidType(w http.ResponseWriter, r *http.Request, expectedType string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != expectedType {
+		requestLog(r).Debug().Msg("isValidType: invalid content type")
+		writeValidationError(w, r, validate.Errors{{
+			Field:   "Content-Type",
+			Rule:    "media_type",
+			Message: "must be " + expectedType,
+		}})
+		return false
+	}
+	return true
+}
+
+// limitedBody fails reads with errTooLarge once more than n bytes were
+// read, and asks for the connection to be closed so that the rest of
+// the body is not drained.
+type limitedBody struct {
+	io.ReadCloser
+	w http.ResponseWriter
+	n int64
+}
+
+func limitBody(w http.ResponseWriter, body io.ReadCloser, n int64) io.ReadCloser {
+	return &limitedBody{ReadCloser: body, w: w, n: n}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.n {
+		b.n -= int64(n)
+		return n, err
+	}
+	n, b.n = int(b.n), -1
+	b.w.Header().Set("Connection", "close")
+	return n, errTooLarge
+}
+
+// decodeJSON reads a size limited JSON body into dst and validates it.
+// On failure the response is written and false returned.
+func (s *server) decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	if !isValidType(w, r, "application/json") {
+		return false
+	}
+
+	r.Body = limitBody(w, r.Body, s.maxBodyBytes)
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err != nil {
+		requestLog(r).Debug().AnErr("decode body", err).Msg("decodeJSON")
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.Is(err, errTooLarge):
+			writeProblem(w, r, &problem{
+				Status: http.StatusRequestEntityTooLarge,
+				Code:   errTooLarge.Code,
+				Detail: errTooLarge.Message,
+			})
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			writeValidationError(w, r, validate.Errors{{
+				Field:   typeErr.Field,
+				Rule:    "type",
+				Message: "must be " + typeErr.Type.String(),
+			}})
+		default:
+			writeValidationError(w, r, validate.Errors{{
+				Field:   "body",
+				Rule:    "json",
+				Message: "is not valid JSON",
+			}})
+		}
+		return false
+	}
+
+	return s.validate(w, r, dst)
+}
+
+// decodeText reads a size limited text/plain body.
+func (s *server) decodeText(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !isValidType(w, r, "text/plain") {
+		return "", false
+	}
+
+	r.Body = limitBody(w, r.Body, s.maxBodyBytes)
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		requestLog(r).Debug().AnErr("read body", err).Msg("decodeText")
+		if errors.Is(err, errTooLarge) {
+			writeProblem(w, r, &problem{
+				Status: http.StatusRequestEntityTooLarge,
+				Code:   errTooLarge.Code,
+				Detail: errTooLarge.Message,
+			})
+			return "", false
+		}
+		writeError(w, r, errBadParameter)
+		return "", false
+	}
+	return strings.TrimSpace(string(b)), true
+}
+
+// validate checks v against its declared rules.
+func (s *server) validate(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := validate.Struct(v)
+	if err == nil {
+		return true
+	}
+
+	requestLog(r).Debug().AnErr("validate", err).Msg("validate")
+	writeError(w, r, err)
+	return false
+}
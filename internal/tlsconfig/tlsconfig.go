@@ -0,0 +1,208 @@
+// Package tlsconfig builds the TLS configuration of the server and
+// reloads its certificate when the files change on disk.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Cipher policies accepted by Config. They only affect TLS 1.2; the
+// TLS 1.3 suites are not configurable and all of them are safe.
+const (
+	// PolicyModern allows forward secret AEAD suites only.
+	PolicyModern = "modern"
+	// PolicyDefault leaves the choice to the Go standard library.
+	PolicyDefault = "default"
+)
+
+var errNoClientCA = errors.New("no certificates found")
+
+var modernSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is "1.2" or "1.3".
+	MinVersion   string
+	CipherPolicy string
+	// ClientCAFile, when set, makes the server ask for a client
+	// certificate signed by one of its CAs. Connections without one are
+	// still accepted; see VerifiedClient.
+	ClientCAFile string
+}
+
+// ParseMinVersion returns the tls package constant for "1.2" or "1.3".
+func ParseMinVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, want 1.2 or 1.3", v)
+	}
+}
+
+// CipherSuites returns the TLS 1.2 suites of policy; nil means the Go
+// defaults.
+func CipherSuites(policy string) ([]uint16, error) {
+	switch policy {
+	case PolicyModern:
+		return modernSuites, nil
+	case PolicyDefault:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown cipher policy %q, want %s or %s", policy, PolicyModern, PolicyDefault)
+	}
+}
+
+// New loads the certificate and returns a server configuration serving
+// it through the returned Reloader.
+func New(cfg Config) (*tls.Config, *Reloader, error) {
+	version, err := ParseMinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	suites, err := CipherSuites(cfg.CipherPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   suites,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("client CA %s: %w", cfg.ClientCAFile, errNoClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, reloader, nil
+}
+
+// VerifiedClient reports whether the peer presented a client certificate
+// that chains to a configured CA.
+func VerifiedClient(state *tls.ConnectionState) bool {
+	return state != nil && len(state.VerifiedChains) > 0
+}
+
+// Reloader serves a certificate and key pair, replacing it when the
+// files change. A pair that fails to load is logged and the previous one
+// is kept, so that a half written renewal does not take the server down.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files unconditionally.
+func (r *Reloader) Reload() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files every interval and reloads them once either
+// has changed, until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reloadIfChanged(); err != nil {
+				log.Error().Err(err).Str("cert", r.certFile).Msg("certificate reload failed, keeping the current one")
+			}
+		}
+	}
+}
+
+func (r *Reloader) reloadIfChanged() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+	if err := r.Reload(); err != nil {
+		return err
+	}
+	log.Info().Str("cert", r.certFile).Msg("certificate reloaded")
+	return nil
+}
+
+// lastModified is the later modification time of the two files.
+func (r *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
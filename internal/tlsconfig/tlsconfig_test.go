@@ -0,0 +1,154 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self-signed certificate for localhost and its key.
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "first")
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, "first", commonName(t, r))
+
+	require.NoError(t, r.reloadIfChanged())
+	assert.Equal(t, "first", commonName(t, r))
+
+	later := time.Now().Add(time.Minute)
+	writeCert(t, dir, "second")
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, r.reloadIfChanged())
+	assert.Equal(t, "second", commonName(t, r))
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("half written"), 0o600))
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	assert.Error(t, r.reloadIfChanged())
+	assert.Equal(t, "second", commonName(t, r), "a broken pair keeps the current certificate")
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "first")
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	later := time.Now().Add(time.Minute)
+	writeCert(t, dir, "second")
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	assert.Eventually(t, func() bool { return commonName(t, r) == "second" }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "server")
+
+	_, _, err := New(Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.1", CipherPolicy: PolicyModern})
+	assert.Error(t, err)
+	_, _, err = New(Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", CipherPolicy: "legacy"})
+	assert.Error(t, err)
+	_, _, err = New(Config{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile, MinVersion: "1.2", CipherPolicy: PolicyModern})
+	assert.Error(t, err)
+
+	// a self-signed certificate is its own CA
+	tlsConfig, _, err := New(Config{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.2",
+		CipherPolicy: PolicyModern,
+		ClientCAFile: certFile,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, modernSuites, tlsConfig.CipherSuites)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if VerifiedClient(r.TLS) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	leaf, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	pem, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	roots.AppendCertsFromPEM(pem)
+
+	get := func(certs ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: certs,
+		}}}
+		res, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, get(), "client certificates are optional")
+	assert.Equal(t, http.StatusNoContent, get(leaf))
+}
@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestSetup(t *testing.T) {
+	provider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(provider)
+
+	t.Run("unknown exporter", func(t *testing.T) {
+		_, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+		assert.Error(t, err)
+	})
+
+	t.Run("none still propagates", func(t *testing.T) {
+		shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone})
+		require.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+		assert.Contains(t, otel.GetTextMapPropagator().Fields(), "traceparent")
+		_, span := Tracer().Start(context.Background(), "noop")
+		assert.False(t, span.IsRecording())
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "traces.json")
+		shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: path, SampleRatio: 1})
+		require.NoError(t, err)
+
+		ctx, parent := Tracer().Start(context.Background(), "parent")
+		_, child := Tracer().Start(ctx, "child")
+		End(child, errors.New("boom"))
+		End(parent, nil)
+
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		assert.Contains(t, carrier["traceparent"], parent.SpanContext().TraceID().String())
+
+		require.NoError(t, shutdown(context.Background()))
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(b), `"Name":"parent"`)
+		assert.Contains(t, string(b), `"Name":"child"`)
+		assert.Contains(t, string(b), `"Description":"boom"`)
+		assert.Contains(t, string(b), ServiceName)
+	})
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/health"
+)
+
+var (
+	errNotRunning = errors.New("worker is not running")
+	errStalled    = errors.New("worker loop stalled")
+)
+
+// HealthChecks reports whether the poll loop is alive and whether the
+// accrual system answers. Neither is critical: the API keeps serving
+// while accruals lag behind.
+func (w *worker) HealthChecks() []health.Check {
+	return []health.Check{
+		{Name: "worker", Run: w.checkLoop},
+		{Name: "accrual", Run: w.checkAccrual},
+	}
+}
+
+func (w *worker) checkLoop(context.Context) (map[string]string, error) {
+	w.mu.RLock()
+	lastTick, lastPoll, interval := w.lastTick, w.lastPoll, w.interval
+	w.mu.RUnlock()
+
+	if lastTick.IsZero() {
+		return nil, errNotRunning
+	}
+	details := map[string]string{"last_tick": lastTick.Format(time.RFC3339)}
+	if !lastPoll.IsZero() {
+		details["last_poll"] = lastPoll.Format(time.RFC3339)
+	}
+	// a tick is late while GetJob waits for Process to drain the queue
+	if time.Since(lastTick) > 3*interval {
+		return details, errStalled
+	}
+	return details, nil
+}
+
+// checkAccrual looks up an order that does not exist; any answer but a
+// server error means the accrual system is usable.
+func (w *worker) checkAccrual(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.accrual()+"/api/orders/0", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	details := map[string]string{"status_code": fmt.Sprint(res.StatusCode)}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return details, fmt.Errorf("accrual system answered %s", res.Status)
+	}
+	return details, nil
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorker_checkLoop(t *testing.T) {
+	w := NewWorker("", inmem.NewInMemRepo())
+
+	_, err := w.checkLoop(context.Background())
+	assert.ErrorIs(t, err, errNotRunning)
+
+	w.tick()
+	details, err := w.checkLoop(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, details, "last_tick")
+	assert.NotContains(t, details, "last_poll")
+
+	w.GetJob(context.Background(), make(chan<- repo.Order))
+	details, err = w.checkLoop(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, details, "last_poll")
+
+	w.lastTick = time.Now().Add(-4 * w.interval)
+	_, err = w.checkLoop(context.Background())
+	assert.ErrorIs(t, err, errStalled)
+}
+
+func TestWorker_checkAccrual(t *testing.T) {
+	status := http.StatusNoContent
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/orders/0", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	w := NewWorker(accrual.URL, inmem.NewInMemRepo())
+
+	details, err := w.checkAccrual(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "204", details["status_code"])
+
+	status = http.StatusServiceUnavailable
+	_, err = w.checkAccrual(context.Background())
+	assert.Error(t, err)
+
+	accrual.Close()
+	_, err = w.checkAccrual(context.Background())
+	assert.Error(t, err, "unreachable")
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/rs/zerolog/log"
+)
+
+type tierWorker struct {
+	db       repo.Repository
+	tiers    domain.Tiers
+	interval time.Duration
+}
+
+func NewTierWorker(db repo.Repository, tiers domain.Tiers, interval time.Duration) *tierWorker {
+	return &tierWorker{db: db, tiers: tiers, interval: interval}
+}
+
+func (w *tierWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	w.Recalculate(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Recalculate(ctx)
+		}
+	}
+}
+
+func (w *tierWorker) Recalculate(ctx context.Context) {
+	err := w.tiers.Recalculate(ctx, w.db, time.Now())
+	if err != nil {
+		log.Error().AnErr("Recalculate", err).Msg("tierWorker")
+		return
+	}
+	log.Debug().Msg("tierWorker: tiers recalculated")
+}
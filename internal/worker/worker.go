@@ -3,92 +3,243 @@ package worker
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"sync"
 	"time"
 
+	"github.com/andrei-cloud/gophermart/internal/domain"
+	"github.com/andrei-cloud/gophermart/internal/metrics"
 	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/requestid"
+	"github.com/andrei-cloud/gophermart/internal/tracing"
 	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultInterval       = 10 * time.Second
+	defaultAccrualTimeout = 10 * time.Second
 )
 
 type worker struct {
-	addr string
-	db   repo.Repository
+	db             repo.Repository
+	tiers          domain.Tiers
+	metrics        *metrics.Metrics
+	accrualTimeout time.Duration
+	reconfigured   chan struct{}
+
+	mu         sync.RWMutex
+	accrualURL string
+	interval   time.Duration
+	poolSize   int
+	lastTick   time.Time
+	lastPoll   time.Time
+}
+
+// NewWorker polls the accrual system at accrualURL, e.g.
+// http://localhost:9090, for the orders in db.
+func NewWorker(accrualURL string, db repo.Repository) *worker {
+	return &worker{
+		accrualURL:     accrualURL,
+		db:             db,
+		metrics:        metrics.New(),
+		interval:       defaultInterval,
+		poolSize:       1,
+		accrualTimeout: defaultAccrualTimeout,
+		reconfigured:   make(chan struct{}, 1),
+	}
+}
+
+func (w *worker) WithInterval(d time.Duration) *worker {
+	w.interval = d
+	return w
+}
+
+// WithPoolSize sets how many orders are sent to the accrual system at
+// once.
+func (w *worker) WithPoolSize(n int) *worker {
+	w.poolSize = n
+	return w
+}
+
+func (w *worker) WithAccrualTimeout(d time.Duration) *worker {
+	w.accrualTimeout = d
+	return w
+}
+
+func (w *worker) WithMetrics(m *metrics.Metrics) *worker {
+	w.metrics = m
+	return w
+}
+
+func (w *worker) WithTiers(t domain.Tiers) *worker {
+	w.tiers = t
+	return w
 }
 
-func NewWorker(addr string, db repo.Repository) *worker {
-	return &worker{addr: addr, db: db}
+// Reconfigure changes the accrual address, poll interval and pool size
+// of a running worker. Orders in flight finish against the old address.
+func (w *worker) Reconfigure(accrualURL string, interval time.Duration, poolSize int) {
+	w.mu.Lock()
+	w.accrualURL, w.interval, w.poolSize = accrualURL, interval, poolSize
+	w.mu.Unlock()
+
+	select {
+	case w.reconfigured <- struct{}{}:
+	default:
+	}
 }
 
 func (w *worker) Run(ctx context.Context) {
-	ticker := time.NewTicker(10 * time.Second)
-	ordersChan := make(chan string)
-	done := make(chan struct{})
-	defer close(ordersChan)
-
-	go w.Process(ctx, ordersChan)
-
-	go func() {
-		defer func() {
-			ticker.Stop()
-			close(done)
-		}()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				w.GetJob(ordersChan)
-			}
+	w.mu.RLock()
+	ticker := time.NewTicker(w.interval)
+	w.mu.RUnlock()
+	defer ticker.Stop()
+	w.tick()
+	ordersChan := make(chan repo.Order)
+
+	// each Process goroutine can be stopped on its own to shrink the pool
+	var pool []context.CancelFunc
+	resize := func() {
+		w.mu.RLock()
+		size := w.poolSize
+		w.mu.RUnlock()
+		for len(pool) < size {
+			processCtx, cancel := context.WithCancel(ctx)
+			pool = append(pool, cancel)
+			go w.Process(processCtx, ordersChan)
+		}
+		for len(pool) > size {
+			pool[len(pool)-1]()
+			pool = pool[:len(pool)-1]
+		}
+	}
+	resize()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.reconfigured:
+			w.mu.RLock()
+			ticker.Reset(w.interval)
+			w.mu.RUnlock()
+			resize()
+		case <-ticker.C:
+			w.tick()
+			w.GetJob(ctx, ordersChan)
 		}
-	}()
+	}
+}
+
+// accrual returns the current base URL of the accrual system.
+func (w *worker) accrual() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.accrualURL
+}
 
-	<-done
+func (w *worker) tick() {
+	w.mu.Lock()
+	w.lastTick = time.Now()
+	w.mu.Unlock()
 }
 
-func (w *worker) GetJob(ch chan<- string) {
-	orders, err := w.db.OrderToProcess()
+func (w *worker) GetJob(ctx context.Context, ch chan<- repo.Order) {
+	orders, err := w.db.OrderToProcess(ctx)
 	if err != nil {
 		log.Error().AnErr("OrderToProcess", err).Msg("GetJob")
 		return
 	}
+	w.metrics.ObserveQueue(orders, time.Now())
+	w.mu.Lock()
+	w.lastPoll = time.Now()
+	w.mu.Unlock()
 	//log.Debug().Msgf("GetJob: got %d order for processing", len(orders))
 	for _, order := range orders {
-		ch <- order
+		select {
+		case ch <- order:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Process polls the accrual system for each order. Log lines and the
+// accrual request carry the ID of the request that uploaded the order,
+// or a new one for orders uploaded before IDs were recorded.
+func (w *worker) Process(ctx context.Context, ch <-chan repo.Order) {
+	client := resty.New().SetTimeout(w.accrualTimeout)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case order := <-ch:
+			w.process(ctx, client, order)
+		}
 	}
 }
 
-func (w *worker) Process(ctx context.Context, ch <-chan string) {
+// process polls the accrual system for a single order within a span of
+// its own; the accrual request carries the trace context.
+func (w *worker) process(ctx context.Context, client *resty.Client, order repo.Order) {
 	body := struct {
 		Order   string  `json:"order"`
 		Status  string  `json:"status"`
 		Accrual float64 `json:"accrual"`
 	}{}
 
-	client := resty.New().SetTimeout(10 * time.Second)
+	id := order.RequestID
+	if id == "" {
+		id = requestid.New()
+	}
+	logger := log.With().Str("request_id", id).Str("order", order.Order).Logger()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case number := <-ch:
-			log.Debug().Msgf("Process: got order %s for processing", number)
-			res, err := client.R().Get(fmt.Sprintf("http://%s/api/orders/%s", w.addr, number))
-			if err != nil {
-				log.Debug().Msgf("Process: resty get %v", err.Error())
-			}
-			if res.StatusCode() == 200 {
-				err := json.Unmarshal(res.Body(), &body)
-				if err != nil {
-					log.Debug().Msgf("Process: unmarshal %v", err.Error())
-				}
-				log.Debug().Msgf("Process: parsed %+v", body)
-				err = w.db.OrderUpdate(body.Order, repo.OrderStatus(body.Status), body.Accrual)
-				if err != nil {
-					log.Debug().Msgf("Process: OrderUpdate %v", err.Error())
-				}
-			}
-		}
+	ctx, span := tracing.Tracer().Start(ctx, "worker.Process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("order", order.Order),
+			attribute.String("request_id", id),
+		))
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	logger.Debug().Msg("Process: got order for processing")
+	callCtx, call := tracing.Tracer().Start(ctx, "accrual.GetOrder", trace.WithSpanKind(trace.SpanKindClient))
+	req := client.R().SetContext(callCtx).SetHeader(requestid.Header, id)
+	otel.GetTextMapPropagator().Inject(callCtx, propagation.HeaderCarrier(req.Header))
+	start := time.Now()
+	res, err := req.Get(w.accrual() + "/api/orders/" + order.Order)
+	w.metrics.ObserveAccrual(res.StatusCode(), err, time.Since(start))
+	call.SetAttributes(semconv.HTTPStatusCodeKey.Int(res.StatusCode()))
+	tracing.End(call, err)
+	if err != nil {
+		logger.Debug().Msgf("Process: resty get %v", err.Error())
+	}
+	if res.StatusCode() != 200 {
+		return
+	}
+
+	err = json.Unmarshal(res.Body(), &body)
+	if err != nil {
+		logger.Debug().Msgf("Process: unmarshal %v", err.Error())
+	}
+	logger.Debug().Msgf("Process: parsed %+v", body)
+	bonus, err := w.tiers.Bonus(ctx, w.db, body.Order, body.Accrual)
+	if err != nil {
+		// leave the order unprocessed so that it is polled again
+		logger.Error().Err(err).Msg("Process: Bonus")
+		return
+	}
+	credited, err := w.db.OrderUpdate(ctx, body.Order, repo.OrderStatus(body.Status), body.Accrual, bonus)
+	if err != nil {
+		logger.Debug().Msgf("Process: OrderUpdate %v", err.Error())
+		return
 	}
+	w.metrics.AddAccrued(credited)
 }
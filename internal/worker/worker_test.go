@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrei-cloud/gophermart/internal/repo"
+	"github.com/andrei-cloud/gophermart/internal/repo/inmem"
+	"github.com/andrei-cloud/gophermart/internal/repo/traced"
+	"github.com/andrei-cloud/gophermart/internal/requestid"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWorker_process(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	}()
+
+	var header http.Header
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":100}`))
+	}))
+	defer accrual.Close()
+
+	db := inmem.NewInMemRepo()
+	uid, err := db.UserCreate(context.Background(), &repo.User{Username: "alice"})
+	require.NoError(t, err)
+	order := repo.Order{Order: "12345678903", UserID: uid, Type: repo.CREDIT, Status: repo.NEW, RequestID: "upload-1"}
+	_, err = db.OrderCreate(context.Background(), &order)
+	require.NoError(t, err)
+
+	w := NewWorker(accrual.URL, traced.New(db))
+	w.process(context.Background(), resty.New(), order)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	job, ok := spans["worker.Process"]
+	require.True(t, ok)
+	call, ok := spans["accrual.GetOrder"]
+	require.True(t, ok)
+	assert.Equal(t, job.SpanContext().SpanID(), call.Parent().SpanID())
+	update, ok := spans["repo.OrderUpdate"]
+	require.True(t, ok)
+	assert.Equal(t, job.SpanContext().SpanID(), update.Parent().SpanID())
+
+	assert.Equal(t, "upload-1", header.Get(requestid.Header))
+	assert.Equal(t, "00-"+call.SpanContext().TraceID().String()+"-"+call.SpanContext().SpanID().String()+"-01",
+		header.Get("traceparent"), "accrual request continues the trace")
+
+	user, err := db.UserGetByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.Equal(t, float64(100), user.Balance)
+}
+
+// tierlessRepo fails to load users, so that no tier bonus can be computed.
+type tierlessRepo struct {
+	repo.Repository
+}
+
+func (tierlessRepo) UserGetByID(context.Context, int64) (*repo.User, error) {
+	return nil, repo.ErrUnavailable
+}
+
+func TestWorker_processBonusError(t *testing.T) {
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":100}`))
+	}))
+	defer accrual.Close()
+
+	db := inmem.NewInMemRepo()
+	uid, err := db.UserCreate(context.Background(), &repo.User{Username: "alice"})
+	require.NoError(t, err)
+	order := repo.Order{Order: "12345678903", UserID: uid, Type: repo.CREDIT, Status: repo.NEW}
+	_, err = db.OrderCreate(context.Background(), &order)
+	require.NoError(t, err)
+
+	w := NewWorker(accrual.URL, tierlessRepo{db})
+	w.process(context.Background(), resty.New(), order)
+
+	stored, err := db.OrderGet(context.Background(), order.Order)
+	require.NoError(t, err)
+	assert.Equal(t, repo.NEW, stored.Status, "the order is polled again")
+	user, err := db.UserGetByID(context.Background(), uid)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), user.Balance)
+}
+
+func TestWorker_Reconfigure(t *testing.T) {
+	hits := make(chan string, 16)
+	accrual := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case hits <- name:
+			default:
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	oldAccrual, newAccrual := accrual("old"), accrual("new")
+	defer oldAccrual.Close()
+	defer newAccrual.Close()
+
+	db := inmem.NewInMemRepo()
+	uid, err := db.UserCreate(context.Background(), &repo.User{Username: "alice"})
+	require.NoError(t, err)
+	_, err = db.OrderCreate(context.Background(), &repo.Order{Order: "12345678903", UserID: uid, Type: repo.CREDIT, Status: repo.NEW})
+	require.NoError(t, err)
+
+	w := NewWorker(oldAccrual.URL, db).WithInterval(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	wait := func(want string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case got := <-hits:
+				if got == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no request reached the %s accrual system", want)
+			}
+		}
+	}
+	wait("old")
+
+	w.Reconfigure(newAccrual.URL, 20*time.Millisecond, 3)
+	wait("new")
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	assert.Equal(t, 20*time.Millisecond, w.interval)
+	assert.Equal(t, 3, w.poolSize)
+}
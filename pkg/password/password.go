@@ -0,0 +1,213 @@
+// Package password hashes and verifies passwords with argon2id or
+// bcrypt. Hashes are self-describing so that parameters can change
+// while existing hashes keep verifying:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//	$2a$12$<bcrypt salt and hash>
+//
+// A hash of a peppered password is additionally prefixed with $pepper.
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+
+	pepperPrefix = "$pepper"
+	saltLength   = 16
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("password: unknown algorithm")
+	ErrMalformedHash    = errors.New("password: malformed hash")
+	ErrNoPepper         = errors.New("password: hash is peppered but no pepper is configured")
+)
+
+// Params select the algorithm used for new hashes. Memory is in KiB.
+type Params struct {
+	Algorithm  string
+	BcryptCost int
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	Pepper     string
+}
+
+func DefaultParams() Params {
+	return Params{
+		Algorithm:  Argon2id,
+		BcryptCost: 12,
+		Time:       2,
+		Memory:     19 * 1024,
+		Threads:    1,
+		KeyLength:  32,
+	}
+}
+
+type Hasher struct {
+	params Params
+	dummy  string
+}
+
+func New(p Params) (*Hasher, error) {
+	switch p.Algorithm {
+	case Argon2id:
+		if p.Time == 0 || p.Memory == 0 || p.Threads == 0 || p.KeyLength == 0 {
+			return nil, fmt.Errorf("password: invalid argon2id parameters")
+		}
+	case Bcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("password: bcrypt cost %d out of range", p.BcryptCost)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, p.Algorithm)
+	}
+
+	h := &Hasher{params: p}
+	dummy, err := h.Hash("gophermart")
+	if err != nil {
+		return nil, err
+	}
+	h.dummy = dummy
+	return h, nil
+}
+
+// Hash returns the encoded hash of password using the current params.
+func (h *Hasher) Hash(password string) (string, error) {
+	secret := h.pepper(password)
+	var (
+		encoded string
+		err     error
+	)
+	switch h.params.Algorithm {
+	case Argon2id:
+		encoded, err = h.argon2id(secret)
+	case Bcrypt:
+		var b []byte
+		b, err = bcrypt.GenerateFromPassword(secret, h.params.BcryptCost)
+		encoded = string(b)
+	}
+	if err != nil {
+		return "", err
+	}
+	if h.params.Pepper != "" {
+		encoded = pepperPrefix + encoded
+	}
+	return encoded, nil
+}
+
+// Verify reports whether password matches encoded and whether encoded
+// should be replaced by a fresh Hash because it was made with other
+// params.
+func (h *Hasher) Verify(password, encoded string) (match bool, rehash bool, err error) {
+	peppered := strings.HasPrefix(encoded, pepperPrefix+"$")
+	if peppered {
+		if h.params.Pepper == "" {
+			return false, false, ErrNoPepper
+		}
+		encoded = strings.TrimPrefix(encoded, pepperPrefix)
+	}
+	secret := []byte(password)
+	if peppered {
+		secret = h.pepper(password)
+	}
+	rehash = peppered != (h.params.Pepper != "")
+
+	switch {
+	case strings.HasPrefix(encoded, "$"+Argon2id+"$"):
+		var p Params
+		match, p, err = verifyArgon2id(secret, encoded)
+		rehash = rehash || h.params.Algorithm != Argon2id ||
+			p.Time != h.params.Time || p.Memory != h.params.Memory ||
+			p.Threads != h.params.Threads || p.KeyLength != h.params.KeyLength
+	case strings.HasPrefix(encoded, "$2"):
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), secret)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+		}
+		match = true
+		cost, _ := bcrypt.Cost([]byte(encoded))
+		rehash = rehash || h.params.Algorithm != Bcrypt || cost != h.params.BcryptCost
+	default:
+		return false, false, ErrUnknownAlgorithm
+	}
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, rehash, nil
+}
+
+// VerifyDummy spends as long as Verify of a real hash would, so that
+// unknown users cannot be told apart by response time.
+func (h *Hasher) VerifyDummy(password string) {
+	_, _, _ = h.Verify(password, h.dummy)
+}
+
+func (h *Hasher) pepper(password string) []byte {
+	if h.params.Pepper == "" {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, []byte(h.params.Pepper))
+	mac.Write([]byte(password))
+	// bcrypt rejects NUL bytes and truncates at 72 bytes
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func (h *Hasher) argon2id(secret []byte) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey(secret, salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2id, argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifyArgon2id(secret []byte, encoded string) (bool, Params, error) {
+	var (
+		p       Params
+		version int
+	)
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, p, ErrMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, p, ErrMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return false, p, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, p, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, p, ErrMalformedHash
+	}
+	p.KeyLength = uint32(len(key))
+
+	other := argon2.IDKey(secret, salt, p.Time, p.Memory, p.Threads, p.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, p, nil
+}
@@ -0,0 +1,78 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHasher(t *testing.T) {
+	argon, err := New(DefaultParams())
+	require.NoError(t, err)
+
+	bcryptParams := DefaultParams()
+	bcryptParams.Algorithm = Bcrypt
+	bcryptParams.BcryptCost = bcrypt.MinCost
+	bcr, err := New(bcryptParams)
+	require.NoError(t, err)
+
+	pepperParams := DefaultParams()
+	pepperParams.Pepper = "pepper"
+	peppered, err := New(pepperParams)
+	require.NoError(t, err)
+
+	stronger := DefaultParams()
+	stronger.Time++
+	strong, err := New(stronger)
+	require.NoError(t, err)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), 8)
+	require.NoError(t, err)
+
+	argonHash, err := argon.Hash("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(argonHash, "$argon2id$v=19$m=19456,t=2,p=1$"))
+
+	pepperHash, err := peppered.Hash("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(pepperHash, "$pepper$argon2id$"))
+
+	tests := []struct {
+		name     string
+		hasher   *Hasher
+		password string
+		hash     string
+		match    bool
+		rehash   bool
+		err      error
+	}{
+		{name: "Verify: current argon2id", hasher: argon, password: "secret", hash: argonHash, match: true},
+		{name: "Verify: wrong password", hasher: argon, password: "wrong", hash: argonHash},
+		{name: "Verify: legacy bcrypt is upgraded", hasher: argon, password: "secret", hash: string(legacy), match: true, rehash: true},
+		{name: "Verify: bcrypt cost change", hasher: bcr, password: "secret", hash: string(legacy), match: true, rehash: true},
+		{name: "Verify: argon2id params change", hasher: strong, password: "secret", hash: argonHash, match: true, rehash: true},
+		{name: "Verify: pepper added", hasher: peppered, password: "secret", hash: argonHash, match: true, rehash: true},
+		{name: "Verify: peppered", hasher: peppered, password: "secret", hash: pepperHash, match: true},
+		{name: "Verify: pepper missing", hasher: argon, password: "secret", hash: pepperHash, err: ErrNoPepper},
+		{name: "Verify: malformed", hasher: argon, password: "secret", hash: "$argon2id$v=19$bad", err: ErrMalformedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := tt.hasher.Verify(tt.password, tt.hash)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.match, match)
+			assert.Equal(t, tt.rehash, rehash)
+		})
+	}
+
+	_, err = New(Params{Algorithm: "md5"})
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
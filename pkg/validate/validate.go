@@ -0,0 +1,149 @@
+// Package validate checks struct fields against rules declared in
+// `validate` struct tags, e.g. `validate:"required,max=64"`.
+//
+// Supported rules:
+//
+//	required  value is not the zero value
+//	min=N     string length or number is at least N
+//	max=N     string length or number is at most N
+//	maxbytes=N  string is at most N bytes long
+//	gt=N      number is greater than N
+//	luhn      string is a number passing the Luhn check
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andrei-cloud/gophermart/pkg/utils"
+)
+
+const RuleLuhn = "luhn"
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Field+" "+fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Only reports whether every error was produced by rule.
+func (e Errors) Only(rule string) bool {
+	for _, fe := range e {
+		if fe.Rule != rule {
+			return false
+		}
+	}
+	return len(e) > 0
+}
+
+// Struct validates v, a struct or a pointer to a struct, and returns
+// Errors listing every invalid field, or nil. A malformed tag, such as
+// an unknown rule or a rule not applicable to the field kind, is
+// reported as a plain error.
+func Struct(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validate: %T is not a struct", v)
+	}
+
+	var errs Errors
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+
+		name := fieldName(field)
+		value := rv.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			msg, ok, err := check(value, rule, arg)
+			if err != nil {
+				return fmt.Errorf("validate: field %s: %w", field.Name, err)
+			}
+			if !ok {
+				errs = append(errs, FieldError{Field: name, Rule: rule, Message: msg})
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func check(v reflect.Value, rule, arg string) (string, bool, error) {
+	switch rule {
+	case "required":
+		return "is required", !v.IsZero(), nil
+	case RuleLuhn:
+		return "is not a valid number", v.Kind() == reflect.String && utils.IsValidLuhn(v.String()), nil
+	}
+
+	n, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return "", false, fmt.Errorf("rule %s: invalid argument %q", rule, arg)
+	}
+	switch rule {
+	case "min":
+		if v.Kind() == reflect.String {
+			return "must be at least " + arg + " characters long", float64(utf8.RuneCountInString(v.String())) >= n, nil
+		}
+		x, err := number(v)
+		return "must be at least " + arg, x >= n, err
+	case "max":
+		if v.Kind() == reflect.String {
+			return "must be at most " + arg + " characters long", float64(utf8.RuneCountInString(v.String())) <= n, nil
+		}
+		x, err := number(v)
+		return "must be at most " + arg, x <= n, err
+	case "maxbytes":
+		if v.Kind() != reflect.String {
+			return "", false, fmt.Errorf("rule %s: not a string: %s", rule, v.Kind())
+		}
+		return "must be at most " + arg + " bytes long", float64(len(v.String())) <= n, nil
+	case "gt":
+		x, err := number(v)
+		return "must be greater than " + arg, x > n, err
+	default:
+		return "", false, fmt.Errorf("unknown rule %s", rule)
+	}
+}
+
+func number(v reflect.Value) (float64, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	default:
+		return 0, fmt.Errorf("not a number: %s", v.Kind())
+	}
+}
@@ -0,0 +1,105 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStruct(t *testing.T) {
+	type request struct {
+		Login  string  `json:"login" validate:"required,max=5"`
+		Order  string  `json:"order" validate:"luhn"`
+		Sum    float64 `json:"sum" validate:"gt=0"`
+		Memo   string  `json:"memo,omitempty" validate:"max=3"`
+		Secret string  `json:"secret" validate:"maxbytes=4"`
+		hidden string  `validate:"required"`
+	}
+
+	tests := []struct {
+		name   string
+		req    request
+		fields []string
+	}{
+		{
+			name: "valid request",
+			req:  request{Login: "user", Order: "12345678903", Sum: 1},
+		},
+		{
+			name:   "missing login",
+			req:    request{Order: "12345678903", Sum: 1},
+			fields: []string{"login"},
+		},
+		{
+			name:   "every field invalid",
+			req:    request{Login: "toolong", Order: "123", Sum: -1, Memo: "memo", Secret: "12345"},
+			fields: []string{"login", "order", "sum", "memo", "secret"},
+		},
+		{
+			name:   "max counts characters, maxbytes counts bytes",
+			req:    request{Login: "ééééé", Order: "12345678903", Sum: 1, Secret: "ééé"},
+			fields: []string{"secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Struct(&tt.req)
+			if tt.fields == nil {
+				require.NoError(t, err)
+				return
+			}
+			var errs Errors
+			require.ErrorAs(t, err, &errs)
+			got := make([]string, 0)
+			for _, fe := range errs {
+				got = append(got, fe.Field)
+			}
+			assert.Equal(t, tt.fields, got)
+		})
+	}
+}
+
+func TestStructMalformedTag(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{
+			name: "unknown rule",
+			v: &struct {
+				Login string `validate:"email"`
+			}{},
+		},
+		{
+			name: "number rule on a string",
+			v: &struct {
+				Login string `validate:"gt=0"`
+			}{},
+		},
+		{
+			name: "byte rule on a number",
+			v: &struct {
+				Sum float64 `validate:"maxbytes=4"`
+			}{},
+		},
+		{
+			name: "invalid argument",
+			v: &struct {
+				Login string `validate:"max=many"`
+			}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			require.NotPanics(t, func() { err = Struct(tt.v) })
+			require.Error(t, err)
+			var errs Errors
+			assert.False(t, errors.As(err, &errs))
+		})
+	}
+}